@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+
+	stats "github.com/hartfordfive/logshipper-benchmark/lib/stats"
+)
+
+// suiteMetric is a result of a run which is aggregated over repetitions
+type suiteMetric struct {
+	Name  string
+	Unit  string
+	Scale float64
+	Value func(r *benchmarkReport) (float64, bool)
+}
+
+var suiteMetrics = []suiteMetric{
+	{"lines_per_second", "lines/s", 1, func(r *benchmarkReport) (float64, bool) {
+		return r.LinesPerSecond, true
+	}},
+	{"delivered_lines_per_second", "delivered lines/s", 1, (*benchmarkReport).deliveredLinesPerSecond},
+	{"cpu_pct_avg", "CPU % avg", 1, func(r *benchmarkReport) (float64, bool) {
+		if r.Resources == nil {
+			return 0, false
+		}
+		return r.Resources.CPUPctAvg, true
+	}},
+	{"rss_bytes_max", "RSS max (MB)", 1024 * 1024, func(r *benchmarkReport) (float64, bool) {
+		if r.Resources == nil {
+			return 0, false
+		}
+		return float64(r.Resources.RSSBytesMax), true
+	}},
+}
+
+type suiteRun struct {
+	ID          string `json:"id"`
+	Combination string `json:"combination"`
+	Repetition  int    `json:"repetition"`
+	Status      string `json:"status"`
+	Report      string `json:"report,omitempty"`
+}
+
+// combinationSummary aggregates the runs of one combination of the matrix.
+// Metrics missing from every run, such as delivery without verification,
+// are left out.
+type combinationSummary struct {
+	Name              string                   `json:"name"`
+	Shipper           string                   `json:"shipper"`
+	ShipperConfig     string                   `json:"shipper_config"`
+	LogLineSize       int                      `json:"log_line_size"`
+	NumActiveLogFiles int                      `json:"num_active_log_files"`
+	WriteWaitPeriodMs int                      `json:"write_wait_period_ms"`
+	Runs              int                      `json:"runs"`
+	Failed            int                      `json:"failed"`
+	Metrics           map[string]stats.Summary `json:"metrics"`
+}
+
+// combinationComparison holds the differences between the metrics of two
+// combinations which only differ by their shipper
+type combinationComparison struct {
+	A           string                      `json:"a"`
+	B           string                      `json:"b"`
+	Differences map[string]stats.Difference `json:"differences"`
+}
+
+type suiteSummary struct {
+	SchemaVersion int                      `json:"schema_version"`
+	Runs          []suiteRun               `json:"runs"`
+	Combinations  []*combinationSummary    `json:"combinations"`
+	Comparisons   []*combinationComparison `json:"comparisons"`
+}
+
+func newSuiteSummary(results []*suiteResult) *suiteSummary {
+
+	summary := &suiteSummary{SchemaVersion: reportSchemaVersion}
+	byName := map[string]*combinationSummary{}
+	values := map[string]map[string][]float64{}
+
+	for _, res := range results {
+		cell := res.Cell
+		summary.Runs = append(summary.Runs, suiteRun{
+			ID:          cell.ID,
+			Combination: cell.Combination,
+			Repetition:  cell.Repetition,
+			Status:      suiteStatus(res),
+			Report:      res.ReportPath,
+		})
+
+		c, ok := byName[cell.Combination]
+		if !ok {
+			c = &combinationSummary{
+				Name:              cell.Combination,
+				Shipper:           cell.Config.LogShipperName,
+				ShipperConfig:     cell.ShipperConfig,
+				LogLineSize:       cell.Config.LogLineSize,
+				NumActiveLogFiles: cell.Config.NumActiveLogFiles,
+				WriteWaitPeriodMs: cell.Config.WriteWaitPeriodMs,
+				Metrics:           map[string]stats.Summary{},
+			}
+			byName[cell.Combination] = c
+			values[cell.Combination] = map[string][]float64{}
+			summary.Combinations = append(summary.Combinations, c)
+		}
+		if res.Report == nil || res.Err != nil {
+			c.Failed++
+			continue
+		}
+		c.Runs++
+		for _, m := range suiteMetrics {
+			if v, ok := m.Value(res.Report); ok {
+				values[c.Name][m.Name] = append(values[c.Name][m.Name], v)
+			}
+		}
+	}
+
+	for _, c := range summary.Combinations {
+		for name, v := range values[c.Name] {
+			c.Metrics[name] = stats.Summarize(v)
+		}
+	}
+
+	// Only combinations run with the same parameters can be compared
+	for i, a := range summary.Combinations {
+		for _, b := range summary.Combinations[i+1:] {
+			if a.ShipperConfig == b.ShipperConfig || a.LogLineSize != b.LogLineSize ||
+				a.NumActiveLogFiles != b.NumActiveLogFiles || a.WriteWaitPeriodMs != b.WriteWaitPeriodMs {
+				continue
+			}
+			cmp := &combinationComparison{A: a.Name, B: b.Name, Differences: map[string]stats.Difference{}}
+			for _, m := range suiteMetrics {
+				sa, okA := a.Metrics[m.Name]
+				sb, okB := b.Metrics[m.Name]
+				if okA && okB && sa.N >= 2 && sb.N >= 2 {
+					cmp.Differences[m.Name] = stats.Compare(sa, sb)
+				}
+			}
+			if len(cmp.Differences) > 0 {
+				summary.Comparisons = append(summary.Comparisons, cmp)
+			}
+		}
+	}
+	return summary
+}
+
+func (s *suiteSummary) JSON() (string, error) {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b) + "\n", nil
+}
+
+// Text returns the statistics of each combination and their comparisons,
+// which is empty unless some combination was run more than once.
+func (s *suiteSummary) Text() string {
+
+	repeated := false
+	for _, c := range s.Combinations {
+		repeated = repeated || c.Runs > 1
+	}
+	if !repeated {
+		return ""
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString("\n--------------------- Aggregated Results ------------------\n")
+	for _, c := range s.Combinations {
+		buffer.WriteString(fmt.Sprintf("%s (%d runs, %d failed)\n", c.Name, c.Runs, c.Failed))
+		w := tabwriter.NewWriter(&buffer, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(w, "\tMEAN\tSTDDEV\tMIN\tMAX\t95% CI\t")
+		for _, m := range suiteMetrics {
+			sum, ok := c.Metrics[m.Name]
+			if !ok {
+				continue
+			}
+			f := func(v float64) string { return fmt.Sprintf("%.2f", v/m.Scale) }
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t[%s, %s]\t\n", m.Unit, f(sum.Mean), f(sum.StdDev), f(sum.Min), f(sum.Max), f(sum.CILow), f(sum.CIHigh))
+		}
+		w.Flush()
+		buffer.WriteString("\n")
+	}
+
+	if len(s.Comparisons) > 0 {
+		buffer.WriteString("Comparisons (difference of the means of the second to the first, at 95% confidence):\n")
+		for _, cmp := range s.Comparisons {
+			buffer.WriteString(fmt.Sprintf("%s vs %s\n", cmp.A, cmp.B))
+			for _, m := range suiteMetrics {
+				d, ok := cmp.Differences[m.Name]
+				if !ok {
+					continue
+				}
+				verdict := "not significant"
+				if d.Significant {
+					verdict = "significant"
+				}
+				line := fmt.Sprintf("  %-20s %+.2f [%+.2f, %+.2f]", m.Unit+":", d.Diff/m.Scale, d.CILow/m.Scale, d.CIHigh/m.Scale)
+				for _, c := range s.Combinations {
+					if c.Name == cmp.A && c.Metrics[m.Name].Mean != 0 {
+						line += fmt.Sprintf(" (%+.2f%%)", d.Diff/c.Metrics[m.Name].Mean*100)
+					}
+				}
+				buffer.WriteString(fmt.Sprintf("%s %s\n", line, verdict))
+			}
+		}
+	}
+	buffer.WriteString("----------------------------------------------------------\n")
+	return buffer.String()
+}
@@ -1,10 +1,10 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -12,26 +12,38 @@ import (
 	"plugin"
 	"regexp"
 	"runtime"
-	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	utils "github.com/hartfordfive/logshipper-benchmark/lib"
+	cgroup "github.com/hartfordfive/logshipper-benchmark/lib/cgroup"
 	counter "github.com/hartfordfive/logshipper-benchmark/lib/counter"
+	generator "github.com/hartfordfive/logshipper-benchmark/lib/generator"
+	kafka "github.com/hartfordfive/logshipper-benchmark/lib/kafka"
+	rate "github.com/hartfordfive/logshipper-benchmark/lib/rate"
+	replay "github.com/hartfordfive/logshipper-benchmark/lib/replay"
+	shipperlog "github.com/hartfordfive/logshipper-benchmark/lib/shipperlog"
 )
 
+// shipperExitTimeout is how long the shipper is given to exit once told to
+const shipperExitTimeout = 30 * time.Second
+
+// shipperOutputLines is the number of last lines of the shipper's output
+// included in the report
+const shipperOutputLines = 20
+
 var GitHash string
 var BuildDate string
 var Version string
 
-func catchExitSig(sigChan chan os.Signal, shutdownChan chan bool) {
+func catchExitSig(sigChan chan os.Signal, shutdown func()) {
 	for {
 		select {
 		case <-sigChan:
 			fmt.Printf("[INFO] Caught signal. Notifying all goroutines via hutdown channel.\n")
-			close(shutdownChan)
+			shutdown()
 		}
 	}
 }
@@ -53,24 +65,14 @@ func showBuildInfoAndExit() {
 	os.Exit(0)
 }
 
-func generateBenchmarkResults(logShipperName string, pid int, linesWritten int64, startTime time.Time, totalSeconds float64, logStr string, numActiveLogFiles int, writeWaitPeriod int, metricDataFile string) string {
-
-	var buffer bytes.Buffer
-	endTime := startTime.Add(time.Second * time.Duration(uint64(totalSeconds)))
-	buffer.WriteString("\n----------------------- Test Results ---------------------\n")
-	buffer.WriteString(fmt.Sprintf("Log Shipper:              %s\n", logShipperName))
-	buffer.WriteString(fmt.Sprintf("PID:                      %d\n", pid))
-	buffer.WriteString(fmt.Sprintf("Start Time:               %s\n", startTime.Format(time.RFC3339)))
-	buffer.WriteString(fmt.Sprintf("End Time:                 %s\n", endTime.Format(time.RFC3339)))
-	buffer.WriteString(fmt.Sprintf("Total Time (s):           %f\n", totalSeconds))
-	buffer.WriteString(fmt.Sprintf("Sample Log Entry:         %s\n", logStr))
-	buffer.WriteString(fmt.Sprintf("Write Wait Period (ms):   %d\n", writeWaitPeriod))
-	buffer.WriteString(fmt.Sprintf("Total Lines Written:      %d\n", linesWritten))
-	buffer.WriteString(fmt.Sprintf("Total Files Written:      %d\n", numActiveLogFiles))
-	buffer.WriteString(fmt.Sprintf("Calculated lines/s:       %d\n", (linesWritten / utils.RoundToEven(totalSeconds))))
-	buffer.WriteString(fmt.Sprintf("Metricbeat data file:     %s\n", metricDataFile))
-	buffer.WriteString("----------------------------------------------------------\n")
-	return buffer.String()
+func showUsageAndExit() {
+	fmt.Println("Usage: ./benchmark [CONFIG_FILE]\n       ./benchmark -suite [SUITE_FILE]\n       ./benchmark -saturate [SATURATION_FILE]")
+	os.Exit(1)
+}
+
+// kafkaTopicName returns the topic each shipper module is configured to produce to
+func kafkaTopicName(shipperName string) string {
+	return fmt.Sprintf("dev-logs-shipper-benchmarks-%s", shipperName)
 }
 
 func SaveToFile(filePath string, data string, fileMode int) error {
@@ -89,9 +91,8 @@ func main() {
 
 	utils.Debug = true
 
-	if len(os.Args) != 2 {
-		fmt.Println("Usage: ./benchmark [CONFIG_FILE]")
-		os.Exit(1)
+	if len(os.Args) < 2 {
+		showUsageAndExit()
 	}
 	args := os.Args[1:]
 
@@ -99,6 +100,30 @@ func main() {
 		showBuildInfoAndExit()
 	}
 
+	if args[0] == "-suite" {
+		if len(args) != 2 {
+			showUsageAndExit()
+		}
+		if failed := RunSuite(LoadSuiteConfig(args[1])); failed > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if args[0] == "-saturate" {
+		if len(args) != 2 {
+			showUsageAndExit()
+		}
+		if failed := RunSaturation(LoadSaturationConfig(args[1])); failed > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if len(args) != 1 {
+		showUsageAndExit()
+	}
+
 	confPath := args[0]
 	if _, err := os.Stat(confPath); os.IsNotExist(err) {
 		fmt.Println("[ERROR] The specified config does not exist!")
@@ -107,15 +132,45 @@ func main() {
 
 	config := LoadConfig(confPath)
 
+	if config.Repetitions > 1 {
+		if failed := RunRepetitions(confPath, config); failed > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	runtime.GOMAXPROCS(config.MaxProcs)
 
-	fileHandles := make(map[int]*os.File)
+	placement, err := NewCPUPlacement(config)
+	if err != nil {
+		fmt.Println("[ERROR] Invalid CPU placement: ", err)
+		os.Exit(1)
+	}
+	if err := placement.PinHarness(config.MaxProcs); err != nil {
+		fmt.Println("[ERROR] Could not pin the harness to its CPUs: ", err)
+		os.Exit(1)
+	}
+
+	if config.LogShipperProcessName != "" {
+		utils.ShipperProcessNames = []string{config.LogShipperProcessName}
+	}
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	shutdownChan := make(chan bool, 1)
+	var shutdownOnce sync.Once
+	shutdown := func() { shutdownOnce.Do(func() { close(shutdownChan) }) }
+
+	// Writers stop before the shipper does, so it may drain what's left
+	writersStop := make(chan bool)
+	var writersStopOnce sync.Once
+	stopWriters := func() { writersStopOnce.Do(func() { close(writersStop) }) }
+	go func() {
+		<-shutdownChan
+		stopWriters()
+	}()
 
-	go catchExitSig(sigChan, shutdownChan)
+	go catchExitSig(sigChan, shutdown)
 
 	// Create the test files that will be written to
 	var filesToMonitor []string
@@ -123,25 +178,116 @@ func main() {
 	os.Remove(config.LogFilesBaseDir)
 	utils.CreateDir(config.LogFilesBaseDir)
 
+	var gen generator.Generator
+	if config.CustomLogEntry != "" {
+		gen = generator.Fixed(config.CustomLogEntry)
+	} else {
+		format := config.LogFormat
+		if format == "" {
+			format = "random"
+		}
+		var err error
+		if gen, err = generator.New(format); err != nil {
+			fmt.Println("[ERROR] ", err)
+			os.Exit(1)
+		}
+	}
+
+	var replaySrc *replay.Source
+	if len(config.ReplayFiles) > 0 {
+		if config.ReplayTiming != "" && config.ReplayTiming != replayTimingRate && config.ReplayTiming != replayTimingTimestamps {
+			fmt.Printf("[ERROR] Invalid replay timing: %s (must be %s or %s)\n", config.ReplayTiming, replayTimingRate, replayTimingTimestamps)
+			os.Exit(1)
+		}
+		var err error
+		replaySrc, err = replay.NewSource(config.ReplayFiles, config.ReplayLoop, config.ReplayTiming == replayTimingTimestamps, config.ReplaySpeed)
+		if err != nil {
+			fmt.Println("[ERROR] Could not open replay files: ", err)
+			os.Exit(1)
+		}
+	}
+
+	if config.TargetLinesPerSecond > 0 && config.TargetMbPerSecond > 0 {
+		fmt.Println("[ERROR] Only one of target_lines_per_second and target_mb_per_second can be set")
+		os.Exit(1)
+	}
+	if config.LoadProfile != nil {
+		if config.TargetLinesPerSecond > 0 || config.TargetMbPerSecond > 0 {
+			fmt.Println("[ERROR] A target rate can't be set along with a load profile")
+			os.Exit(1)
+		}
+		if err := config.LoadProfile.Check(); err != nil {
+			fmt.Println("[ERROR] Invalid load profile: ", err)
+			os.Exit(1)
+		}
+	}
+	if (config.TargetLinesPerSecond > 0 || config.TargetMbPerSecond > 0 || config.LoadProfile != nil) && config.ReplayTiming == replayTimingTimestamps {
+		fmt.Println("[ERROR] A target rate can't be set when replaying with timestamps")
+		os.Exit(1)
+	}
+
+	if err := CheckChaosEvents(config.ChaosEvents); err != nil {
+		fmt.Println("[ERROR] Invalid chaos events: ", err)
+		os.Exit(1)
+	}
+	if len(config.ChaosEvents) > 0 && !config.VerifyDelivery {
+		fmt.Println("[ERROR] Chaos events require verify_delivery, to count the lines lost and duplicated across restarts")
+		os.Exit(1)
+	}
+	if config.ShipperCgroup != nil {
+		if err := config.ShipperCgroup.Check(); err != nil {
+			fmt.Println("[ERROR] Invalid shipper cgroup: ", err)
+			os.Exit(1)
+		}
+	}
+	if err := kafka.CheckSinkSchedule(config.SinkSchedule); err != nil {
+		fmt.Println("[ERROR] Invalid sink schedule: ", err)
+		os.Exit(1)
+	}
+	if len(config.SinkSchedule) > 0 && len(config.KafkaBrokerList) > 0 {
+		fmt.Println("[ERROR] A sink schedule can only be applied to the embedded broker, kafka_broker_list must be empty")
+		os.Exit(1)
+	}
+
+	if err := CheckRotationConfig(config); err != nil {
+		fmt.Println("[ERROR] Invalid rotation config: ", err)
+		os.Exit(1)
+	}
+
+	var writers []*logWriter
 	for i := 0; i < config.NumActiveLogFiles; i++ {
-		fPath := config.LogFilesBaseDir + "/file" + strconv.Itoa(i) + ".log"
-		f, err := os.Create(fPath)
+		w, err := NewLogWriter(i, config)
 		if err != nil {
 			fmt.Println(err)
 		}
-		filesToMonitor = append(filesToMonitor, fPath)
-		fileHandles[i] = f
 		utils.CheckErr(err)
-		defer f.Close()
+		writers = append(writers, w)
+		filesToMonitor = append(filesToMonitor, w.MonitorPath())
+	}
+
+	var churner *fileChurner
+	if config.ChurnIntervalMs > 0 {
+		if len(writers) == 0 {
+			fmt.Println("[ERROR] File churn requires at least one active log file")
+			os.Exit(1)
+		}
+		churner = NewFileChurner(config, writers)
+		filesToMonitor = []string{churner.MonitorPattern()}
 	}
 
 	var wg sync.WaitGroup
 	wg.Add(config.NumActiveLogFiles)
 	wg.Add(1) // Also add an increment for the confirmation of the log shipper being shut down
+	if churner != nil {
+		wg.Add(1)
+	}
 
 	linesWrittenCounter := counter.NewCounter()
+	bytesWrittenCounter := counter.NewCounter()
+	rotationsCounter := counter.NewCounter()
 
 	execAck := make(chan *exec.Cmd, 1)
+	startErrs := make(chan error, 1)
 
 	re := regexp.MustCompile("  +")
 	flags := string(re.ReplaceAll(bytes.TrimSpace([]byte(config.LogShipperFlags)), []byte(" ")))
@@ -162,13 +308,61 @@ func main() {
 	}
 
 	shipperIface, err := symShipper.(func() (interface{}, error))()
-	shipper := shipperIface.(Shipper)
+	if err != nil {
+		fmt.Println("[ERROR] Could not initialise the shipper: ", err)
+		os.Exit(1)
+	}
+	shipper, ok := shipperIface.(Shipper)
+	if !ok {
+		fmt.Printf("[ERROR] The %s module doesn't implement the shipper interface\n", config.ModuleName)
+		os.Exit(1)
+	}
+
+	// Without any broker configured, the shipper produces to an embedded one
+	var broker *kafka.Broker
+	if len(config.KafkaBrokerList) == 0 {
+		brokerAddr := config.EmbeddedBrokerAddr
+		if brokerAddr == "" {
+			brokerAddr = "127.0.0.1:0"
+		}
+		retentionMb := config.EmbeddedBrokerRetentionMb
+		if retentionMb <= 0 {
+			retentionMb = 256
+		}
+		broker, err = kafka.NewBroker(brokerAddr, int64(retentionMb)*1024*1024)
+		if err != nil {
+			fmt.Println("[ERROR] Could not start embedded Kafka broker: ", err)
+			os.Exit(1)
+		}
+		go broker.Serve()
+		defer broker.Close()
+		config.KafkaBrokerList = []string{broker.Addr()}
+		fmt.Printf("[INFO] No Kafka brokers configured, using embedded broker at %s\n", broker.Addr())
+	}
+
+	// The verifier must be positioned on the topic before anything is shipped
+	var verifier *deliveryVerifier
+	var verifierWg sync.WaitGroup
+	if config.VerifyDelivery {
+		gracePeriod := config.VerifyGracePeriodSecs
+		if gracePeriod <= 0 {
+			gracePeriod = 10
+		}
+		verifier = NewDeliveryVerifier(config.KafkaBrokerList, kafkaTopicName(shipper.Name()), time.Duration(gracePeriod)*time.Second, config.StampLines, linesWrittenCounter)
+		if err := verifier.Start(); err != nil {
+			fmt.Println("[ERROR] Could not start delivery verifier: ", err)
+			os.Exit(1)
+		}
+		// Kept out of wg, so the grace period isn't part of the run time
+		verifierWg.Add(1)
+		go verifier.Run(shutdownChan, &verifierWg)
+	}
 
 	// Get the start time of the execution
 	start := utils.TimeTraceStart()
 
 	// Startup the metric collector before running the log shipper
-	mc := NewMetricCollector()
+	var mc MetricCollector
 	tags := []string{
 		"benchmark",
 	}
@@ -186,105 +380,279 @@ func main() {
 	t := time.Now()
 	dt := fmt.Sprintf("%d%02d%02d%02d%02d%02d", t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second())
 	metricsFileName := fmt.Sprintf("benchmark-%s-%dbytes_%dfiles_%ds_%s.log", config.LogShipperName, config.LogLineSize, config.NumActiveLogFiles, config.TotalRunTimeSeconds, dt)
-	mbWorkingDir := fmt.Sprintf("%s/%s/", strings.TrimRight(config.WorkingDir, "/"), "metricbeat")
-	utils.CreateDir(mbWorkingDir)
-	go mc.RunMetricbeat("/usr/share/metricbeat/bin/metricbeat", []string{"-c", "metricbeat.yml", "--path.data", "."}, mbWorkingDir, []string{config.LogShipperProcessName}, fields, tags, metricsFileName, shutdownChan, &wg)
-
-	if config.TotalRunTimeSeconds >= 1 {
-		go func(shutdownChan chan bool) {
-			fmt.Printf("[INFO] Running benchark for %d seconds and then exiting.\n", config.TotalRunTimeSeconds)
-			timerRunTime := time.NewTimer(time.Duration(config.TotalRunTimeSeconds) * time.Second)
-			<-timerRunTime.C
-			close(shutdownChan)
-		}(shutdownChan)
+	metricsPeriod := 2000 * time.Millisecond
+	if config.MetricsPeriodMs > 0 {
+		metricsPeriod = time.Duration(config.MetricsPeriodMs) * time.Millisecond
 	}
+	switch config.MetricCollector {
+	case "metricbeat":
+		mbBinPath := config.MetricbeatBinPath
+		if mbBinPath == "" {
+			mbBinPath = "/usr/share/metricbeat/bin/metricbeat"
+		}
+		mbWorkingDir := fmt.Sprintf("%s/%s/", strings.TrimRight(config.WorkingDir, "/"), "metricbeat")
+		utils.CreateDir(mbWorkingDir)
+		mc = NewMetricbeatCollector(mbBinPath, []string{"-c", "metricbeat.yml", "--path.data", "."}, mbWorkingDir)
+	case "", "native":
+		mc = NewNativeCollector(config.MetricsDir, metricsPeriod)
+	default:
+		fmt.Printf("[ERROR] Unknown metric collector: %s\n", config.MetricCollector)
+		os.Exit(1)
+	}
+	go mc.Run([]string{config.LogShipperProcessName}, fields, tags, metricsFileName, shutdownChan, &wg)
 
 	// Start the log shipper
 	workingDir := fmt.Sprintf("%s/%s/", strings.TrimRight(config.WorkingDir, "/"), config.LogShipperName)
 	utils.CreateDir(workingDir)
-	go shipper.Run(config.LogShipperBinPath, cmdArgs, workingDir, filesToMonitor, config.KafkaBrokerList, execAck, shutdownChan, &wg)
-
-	// Now wait until we get a copy of the pointer to the exec.Cmd struct
+	output, err := shipperlog.New(workingDir+"shipper.log", shipperOutputLines)
+	if err != nil {
+		fmt.Println("[ERROR] Could not create the shipper output file: ", err)
+		os.Exit(1)
+	}
+	opts := utils.RunOptions{FileScanIntervalSecs: config.FileScanIntervalSecs, Stdout: output.Stdout(), Stderr: output.Stderr()}
+	if ml, ok := gen.(generator.Multiline); ok && replaySrc == nil {
+		opts.MultilineStart = ml.FirstLine()
+	}
+	var group *cgroup.Group
+	if config.ShipperCgroup != nil {
+		group, err = cgroup.New(fmt.Sprintf("lsb-%s-%d", config.LogShipperName, os.Getpid()), *config.ShipperCgroup)
+		if err != nil {
+			fmt.Println("[ERROR] Could not create the cgroup of the shipper: ", err)
+			os.Exit(1)
+		}
+		if opts.Cgroup, err = group.Open(); err != nil {
+			fmt.Println("[ERROR] Could not open the cgroup of the shipper: ", err)
+			group.Remove()
+			os.Exit(1)
+		}
+		fmt.Printf("[INFO] Running %s in the cgroup %s\n", config.LogShipperName, group.Path())
+	}
+	startShipper := func(preserveState bool) (*exec.Cmd, <-chan bool, error) {
+		runOpts := opts
+		runOpts.PreserveState = preserveState
+		exited := make(chan bool)
+		go func() {
+			shipper.Run(config.LogShipperBinPath, cmdArgs, workingDir, filesToMonitor, config.KafkaBrokerList, &runOpts, execAck, startErrs, shutdownChan, &wg)
+			close(exited)
+		}()
+		// Now wait until we get a copy of the pointer to the exec.Cmd struct
+		select {
+		case cmd := <-execAck:
+			placement.PinShipper(cmd.Process.Pid)
+			return cmd, exited, nil
+		case err := <-startErrs:
+			return nil, exited, err
+		}
+	}
 	fmt.Println("Waiting for confirmation of shipper started...")
-	shipperExec := <-execAck
+	supervisor, err := SuperviseShipper(config.LogShipperName, startShipper, shutdown, shutdownChan)
+	if err != nil {
+		fmt.Printf("[ERROR] Could not start %s: %s\n", config.LogShipperName, err)
+		if group != nil {
+			opts.Cgroup.Close()
+			group.Remove()
+		}
+		os.Exit(1)
+	}
+	// The shipper is only replaced by chaos events, the report is about the first one
+	shipperExec, _ := supervisor.Current()
+
+	wg.Add(1)
+	go placement.Run(supervisor, shutdownChan, &wg)
 
 	go waitForShutdown(linesWrittenCounter, shutdownChan)
 
-	logStrLen := config.LogLineSize
-	if config.EnableRandom {
-		logStrLen = utils.GetRandInt(config.RandomLineSize[0], config.RandomLineSize[1])
+	lineSize := func() int {
+		if config.EnableRandom {
+			return config.RandomLineSize[0] + rand.Intn(config.RandomLineSize[1]-config.RandomLineSize[0])
+		}
+		return config.LogLineSize
 	}
+	var newSource func() entrySource
+	var logStr string
+	if replaySrc != nil {
+		// Every writer takes its lines from the same replay
+		src := &replaySource{lines: replaySrc.Lines(), paced: config.ReplayTiming == replayTimingTimestamps}
+		newSource = func() entrySource { return src }
+		fmt.Printf("Replaying %s\n", strings.Join(config.ReplayFiles, ", "))
+		go replaySrc.Run(writersStop)
+	} else {
+		corpusSize, entropy := config.CorpusSize, config.CorpusEntropy
+		if corpusSize <= 0 {
+			corpusSize = 1024
+		}
+		if config.CustomLogEntry != "" {
+			// The custom entry is written as is
+			corpusSize, entropy = 1, 0
+		}
+		corpus := generator.NewCorpus(gen, corpusSize, lineSize, entropy)
+		newSource = func() entrySource { return newCorpusSource(corpus) }
+		logStr = string(corpus.Entry(0).Text)
 
-	logStr := utils.GenerateRandomString(logStrLen) + "\n"
+		fmt.Printf("Using %d distinct log entries, such as (%d bytes):\n\t%s\n", corpus.Len(), len(logStr), logStr)
+	}
+	counters := &writeCounters{Lines: linesWrittenCounter, Bytes: bytesWrittenCounter, Rotations: rotationsCounter}
+
+	var target *rate.Target
+	if config.TargetLinesPerSecond > 0 {
+		target = rate.NewTarget(config.TargetLinesPerSecond, rate.Lines)
+		fmt.Printf("[INFO] Writing %.2f lines/s across all files.\n", config.TargetLinesPerSecond)
+	} else if config.TargetMbPerSecond > 0 {
+		target = rate.NewTarget(config.TargetMbPerSecond*1024*1024, rate.Bytes)
+		fmt.Printf("[INFO] Writing %.2f MB/s across all files.\n", config.TargetMbPerSecond)
+	} else if profile := config.LoadProfile; profile != nil {
+		target = profile.NewTarget()
+		fmt.Printf("[INFO] Following a %s load profile.\n", profile.Shape)
+		go profile.Drive(target, writersStop)
+	}
+	if target != nil {
+		wg.Add(1)
+		var received func() int64
+		if verifier != nil {
+			received = verifier.Received
+		}
+		go recordLoadTimeline(loadTimelineFile(mc.DataFile(metricsFileName)), target, counters, received, metricsPeriod, writersStop, &wg)
+	}
 
-	fmt.Printf("Using dummy log entry (%d bytes):\n\t%s\n", config.LogLineSize, logStr)
+	// Registries are only read from shippers which keep one
+	reporter, _ := shipperIface.(PositionReporter)
+	lag, err := NewLagMonitor(lagTimelineFile(mc.DataFile(metricsFileName)), filesToMonitor, shipperExec.Process.Pid, config.LogShipperProcessName, reporter)
+	if err != nil {
+		fmt.Println("[ERROR] Could not monitor the read lag of the shipper: ", err)
+	} else {
+		wg.Add(1)
+		go lag.Run(metricsPeriod, shutdownChan, &wg)
+	}
 
-	// Now itterate ovear each file handle and write to the file
-	for i := 0; i < config.NumActiveLogFiles; i++ {
+	var cgroupMon *cgroupMonitor
+	if group != nil {
+		cgroupMon = NewCgroupMonitor(cgroupTimelineFile(mc.DataFile(metricsFileName)), group, *config.ShipperCgroup)
+		wg.Add(1)
+		go cgroupMon.Run(metricsPeriod, shutdownChan, &wg)
+	}
+
+	// Now itterate ovear each file and write to it
+	for i, w := range writers {
 
 		if utils.Debug {
-			fmt.Printf("[DEBUG] Creating goroutine #%d to write to %s\n", i, fileHandles[i].Name())
+			fmt.Printf("[DEBUG] Creating goroutine #%d to write to %s\n", i, w.path)
 		}
 
-		go func(logStr string, filePath string, fh *os.File, counter *counter.Counter, shutdownChan <-chan bool, wg *sync.WaitGroup) {
-
-			buffWritter := bufio.NewWriterSize(fh, 4096*8) // 32K buffer
-			writeWaitPeriod := config.WriteWaitPeriodMs
-			if config.EnableRandom {
-				writeWaitPeriod = utils.GetRandInt(config.RandomWriteWait[0], config.RandomWriteWait[1])
-			}
-			ticker_write := time.NewTicker(time.Millisecond * time.Duration(writeWaitPeriod))
-			ticker_flush := time.NewTicker(time.Millisecond * 2000)
-			defer ticker_write.Stop()
-			defer ticker_flush.Stop()
-
-			logMsgSize := len(logStr)
-			for {
-				select {
-				case <-ticker_write.C:
-					_, err := buffWritter.WriteString(logStr)
-					if err != nil {
-						fmt.Println(err)
-					}
-					counter.Incr(1)
-				case <-ticker_flush.C:
-					if buffWritter.Available() < logMsgSize {
-						buffWritter.Flush()
-					}
-				case <-shutdownChan:
-					fInfo, _ := fh.Stat()
-					if utils.Debug {
-						fmt.Printf("[INFO] Terminating file writter for %s\n", fInfo.Name())
-					}
-					fh.Close()
-					if err := os.Remove(filePath); err != nil {
-						if utils.Debug {
-							fmt.Printf("[ERROR] Coud not delete %s: %s\n", filePath, err)
-						}
-					}
-					wg.Done()
-					return
-				}
-			}
-		}(logStr, filesToMonitor[i], fileHandles[i], linesWrittenCounter, shutdownChan, &wg)
+		go w.Run(newSource(), target, counters, writersStop, shutdownChan, &wg)
+	}
+	if churner != nil {
+		go churner.Run(newSource, target, counters, writersStop, shutdownChan, &wg)
+	}
 
+	var chaos *faultInjector
+	if len(config.ChaosEvents) > 0 {
+		chaos = NewFaultInjector(config.ChaosEvents, supervisor, verifier, lag, counters)
+		wg.Add(1)
+		go chaos.Run(shutdownChan, &wg)
 	}
 
+	var sink *sinkMonitor
+	if len(config.SinkSchedule) > 0 {
+		sink = NewSinkMonitor(sinkTimelineFile(mc.DataFile(metricsFileName)), broker, config.SinkSchedule, kafkaTopicName(shipper.Name()), workingDir, counters)
+		wg.Add(1)
+		go sink.Run(metricsPeriod, shutdownChan, &wg)
+	}
+
+	phases := NewRunPhases(config, lag, verifier, target, counters)
+	go phases.Run(stopWriters, shutdown, shutdownChan)
+
 	wg.Wait()
+	<-phases.Done()
 	totalSeconds := utils.TimeTraceEnd(start)
+	verifierWg.Wait()
+
+	// The shipper may still be saving its state, which must be done before
+	// its files are cleaned up for the next run.
+	lastExec, shipperExited := supervisor.Current()
+	select {
+	case <-shipperExited:
+	case <-time.After(shipperExitTimeout):
+		fmt.Printf("[ERROR] %s didn't exit within %s, killing it.\n", config.LogShipperName, shipperExitTimeout)
+		syscall.Kill(-lastExec.Process.Pid, syscall.SIGKILL)
+		<-shipperExited
+	}
+	shipper.CleanupFiles()
+	var cgroupResults *cgroupSummary
+	if group != nil {
+		// The accounting is gone once the group is removed
+		cgroupResults = cgroupMon.Summary()
+		opts.Cgroup.Close()
+		if err := group.Remove(); err != nil {
+			fmt.Printf("[ERROR] Could not remove the cgroup %s: %s\n", group.Path(), err)
+		}
+	}
+	if err := output.Close(); err != nil {
+		fmt.Println("[ERROR] Could not save the shipper output: ", err)
+	}
+	if replaySrc != nil && replaySrc.Err() != nil {
+		fmt.Println("[ERROR] Replay stopped early: ", replaySrc.Err())
+	}
+	report := newBenchmarkReport(config, shipper, shipperExec.Process.Pid, start, totalSeconds)
+	report.SampleLogEntry = logStr
+	report.Failure = supervisor.Failure()
+	report.Cgroup = cgroupResults
+	report.Placement = placement.Summary()
+	if report.Failure != nil && cgroupResults != nil && cgroupResults.MemoryOOMKills > 0 {
+		report.Failure.Reason += fmt.Sprintf(" (%d processes killed by the OOM killer of the cgroup)", cgroupResults.MemoryOOMKills)
+	}
+	if chaos != nil {
+		report.Restarts = chaos.Records()
+	}
+	report.MetricsDataFile = mc.DataFile(metricsFileName)
+	outputSummary := output.Summary()
+	report.ShipperOutput = &outputSummary
+	report.setLineCounts(linesWrittenCounter.Value(), bytesWrittenCounter.Value())
+	report.Rotations = rotationsCounter.Value()
+	if phases.Measured() {
+		report.setMeasurement(phases, linesWrittenCounter.Value(), bytesWrittenCounter.Value())
+	}
+	report.setDrain(phases)
+	if target != nil {
+		report.setRate(target.Unit(), phases.requestedRate, phases.writeSeconds)
+		report.LoadTimelineFile = loadTimelineFile(report.MetricsDataFile)
+	}
+	if lag != nil {
+		report.Lag = lag.Summary()
+	}
+	if churner != nil {
+		report.FilesWritten = churner.FilesCreated()
+	}
+	if verifier != nil {
+		report.Delivery = verifier.Summary(linesWrittenCounter.Value())
+	}
+	if broker != nil {
+		records, bytes := broker.Received(kafkaTopicName(shipper.Name()))
+		report.EmbeddedBroker = &embeddedBrokerSummary{Addr: broker.Addr(), Records: records, Bytes: bytes}
+	}
+	if sink != nil {
+		report.Sink = sink.Summary()
+	}
+	// Restarted shippers are accounted along with the first one
+	if resources, ok := utils.GetProcessTreeSummary(supervisor.Pids()...); ok {
+		report.Resources = &resources
+	}
+
 	fmt.Println("[INFO] Generating report...")
-	report := generateBenchmarkResults(
-		config.LogShipperName,
-		shipperExec.Process.Pid,
-		linesWrittenCounter.Value(),
-		start,
-		totalSeconds,
-		logStr,
-		config.NumActiveLogFiles,
-		config.WriteWaitPeriodMs,
-		config.MetricsDir+"/"+metricsFileName,
-	)
-
-	fmt.Println(SaveToFile(fmt.Sprintf("%s/report-%s_%s.txt", strings.TrimRight(config.WorkingDir, "/"), config.LogShipperName, dt), report, 0644))
+	reportBasePath := fmt.Sprintf("%s/report-%s_%s", strings.TrimRight(config.WorkingDir, "/"), config.LogShipperName, dt)
+	fmt.Println(SaveToFile(reportBasePath+".txt", generateBenchmarkResults(report), 0644))
+
+	if jsonReport, err := report.JSON(); err != nil {
+		fmt.Println("[ERROR] Could not generate JSON report: ", err)
+	} else {
+		fmt.Println(SaveToFile(reportBasePath+".json", jsonReport, 0644))
+	}
+	if csvReport, err := report.CSV(); err != nil {
+		fmt.Println("[ERROR] Could not generate CSV report: ", err)
+	} else {
+		fmt.Println(SaveToFile(reportBasePath+".csv", csvReport, 0644))
+	}
+	if report.Failure != nil {
+		os.Exit(1)
+	}
 
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	utils "github.com/hartfordfive/logshipper-benchmark/lib"
+	cgroup "github.com/hartfordfive/logshipper-benchmark/lib/cgroup"
+)
+
+// cgroupEvent is a sample of the cgroup timeline, which is written next to
+// the metrics data file so the limits the shipper ran into can be lined up
+// with its resource usage.
+type cgroupEvent struct {
+	Timestamp string            `json:"@timestamp"`
+	Metricset map[string]string `json:"metricset"`
+	Cgroup    cgroupSample      `json:"cgroup"`
+}
+
+type cgroupSample struct {
+	CPUPct           float64 `json:"cpu_pct"`
+	ThrottledPct     float64 `json:"throttled_pct"` // Share of the periods which were throttled
+	ThrottledSeconds float64 `json:"throttled_seconds"`
+	MemoryBytes      uint64  `json:"memory_bytes"`
+	MemoryMaxEvents  uint64  `json:"memory_max_events"`
+	OOMKills         uint64  `json:"oom_kills"`
+	IOReadPerSecond  float64 `json:"io_read_bytes_per_second"`
+	IOWritePerSecond float64 `json:"io_write_bytes_per_second"`
+	Processes        uint64  `json:"processes"`
+}
+
+// cgroupSummary holds what the cgroup of the shipper accounted for during
+// the run, along with the limits it had
+type cgroupSummary struct {
+	cgroup.Stats
+	Path           string        `json:"path"`
+	Limits         cgroup.Limits `json:"limits"`
+	CPUPctAvg      float64       `json:"cpu_pct_avg"`
+	ThrottledPct   float64       `json:"throttled_pct"`
+	MemoryMaxBytes uint64        `json:"memory_max_bytes"` // Largest memory use seen
+	TimelineFile   string        `json:"timeline_file"`
+}
+
+// cgroupTimelineFile returns the path of the cgroup timeline of a metrics data file
+func cgroupTimelineFile(metricsDataFile string) string {
+	return strings.TrimSuffix(metricsDataFile, ".log") + "-cgroup.log"
+}
+
+// describeCgroupLimits returns the limits in a line, such as for the report
+func describeCgroupLimits(l cgroup.Limits) string {
+	var limits []string
+	if l.CPUs > 0 {
+		limits = append(limits, fmt.Sprintf("%g CPUs", l.CPUs))
+	}
+	if l.MemoryMaxMb > 0 {
+		limits = append(limits, fmt.Sprintf("%d MB", l.MemoryMaxMb))
+	}
+	for _, line := range l.IOMax {
+		limits = append(limits, "io "+line)
+	}
+	if len(limits) == 0 {
+		return "none"
+	}
+	return strings.Join(limits, ", ")
+}
+
+// cgroupMonitor samples the accounting of the cgroup the shipper runs in
+type cgroupMonitor struct {
+	filePath  string
+	group     *cgroup.Group
+	limits    cgroup.Limits
+	created   time.Time
+	maxMemory uint64
+	lock      sync.Mutex
+}
+
+func NewCgroupMonitor(filePath string, group *cgroup.Group, limits cgroup.Limits) *cgroupMonitor {
+	return &cgroupMonitor{filePath: filePath, group: group, limits: limits, created: time.Now()}
+}
+
+// Summary reads the accounting of the group, which must still exist
+func (cm *cgroupMonitor) Summary() *cgroupSummary {
+	stats, err := cm.group.Stats()
+	if err != nil {
+		fmt.Printf("[ERROR] Could not read the accounting of %s: %s\n", cm.group.Path(), err)
+		return nil
+	}
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	s := &cgroupSummary{Stats: stats, Path: cm.group.Path(), Limits: cm.limits, TimelineFile: cm.filePath, MemoryMaxBytes: cm.maxMemory}
+	if secs := time.Since(cm.created).Seconds(); secs > 0 {
+		s.CPUPctAvg = stats.CPUUsageSeconds / secs * 100
+	}
+	if stats.CPUPeriods > 0 {
+		s.ThrottledPct = float64(stats.CPUThrottled) / float64(stats.CPUPeriods) * 100
+	}
+	if stats.MemoryPeak > s.MemoryMaxBytes {
+		s.MemoryMaxBytes = stats.MemoryPeak
+	}
+	return s
+}
+
+// Run writes the accounting of the group every period until shutdown
+func (cm *cgroupMonitor) Run(period time.Duration, shutdownChan <-chan bool, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	utils.CreateDir(path.Dir(cm.filePath))
+	file, err := os.OpenFile(cm.filePath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		fmt.Printf("[ERROR] Could not open %s: %s\n", cm.filePath, err)
+		return
+	}
+	defer file.Close()
+	out := bufio.NewWriter(file)
+	defer out.Flush()
+	enc := json.NewEncoder(out)
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	last := time.Now()
+	lastStats, _ := cm.group.Stats()
+	for {
+		select {
+		case now := <-ticker.C:
+			stats, err := cm.group.Stats()
+			if err != nil {
+				fmt.Printf("[ERROR] Could not read the accounting of %s: %s\n", cm.group.Path(), err)
+				continue
+			}
+			secs := now.Sub(last).Seconds()
+			sample := cgroupSample{
+				CPUPct:           (stats.CPUUsageSeconds - lastStats.CPUUsageSeconds) / secs * 100,
+				ThrottledSeconds: stats.CPUThrottledSecs - lastStats.CPUThrottledSecs,
+				MemoryBytes:      stats.MemoryCurrent,
+				MemoryMaxEvents:  stats.MemoryMaxEvents - lastStats.MemoryMaxEvents,
+				OOMKills:         stats.MemoryOOMKills,
+				IOReadPerSecond:  float64(stats.IOReadBytes-lastStats.IOReadBytes) / secs,
+				IOWritePerSecond: float64(stats.IOWriteBytes-lastStats.IOWriteBytes) / secs,
+				Processes:        stats.ProcessesCurrent,
+			}
+			if periods := stats.CPUPeriods - lastStats.CPUPeriods; periods > 0 {
+				sample.ThrottledPct = float64(stats.CPUThrottled-lastStats.CPUThrottled) / float64(periods) * 100
+			}
+			cm.lock.Lock()
+			if stats.MemoryCurrent > cm.maxMemory {
+				cm.maxMemory = stats.MemoryCurrent
+			}
+			cm.lock.Unlock()
+			enc.Encode(&cgroupEvent{
+				Timestamp: now.UTC().Format(time.RFC3339Nano),
+				Metricset: map[string]string{"module": "benchmark", "name": "cgroup"},
+				Cgroup:    sample,
+			})
+			last, lastStats = now, stats
+		case <-shutdownChan:
+			return
+		}
+	}
+}
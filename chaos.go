@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Signals chaos events may send to the shipper
+var chaosSignals = map[string]syscall.Signal{
+	"SIGKILL": syscall.SIGKILL,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGINT":  syscall.SIGINT,
+}
+
+// ChaosEvent stops the shipper with a signal at a given time of the run,
+// and starts it again once it exited and the downtime is over
+type ChaosEvent struct {
+	AtSeconds       int    `json:"at_seconds"`
+	Signal          string `json:"signal"`
+	DowntimeSeconds int    `json:"downtime_seconds"`
+}
+
+// CheckChaosEvents returns an error if an event can't be run
+func CheckChaosEvents(events []ChaosEvent) error {
+	for i, e := range events {
+		if e.AtSeconds < 1 {
+			return fmt.Errorf("event #%d must set at_seconds", i)
+		}
+		if _, ok := chaosSignals[strings.ToUpper(e.Signal)]; !ok {
+			return fmt.Errorf("event #%d has an unknown signal: %s (must be SIGKILL, SIGTERM or SIGINT)", i, e.Signal)
+		}
+	}
+	return nil
+}
+
+// restartRecord is what happened during one chaos event
+type restartRecord struct {
+	AtSeconds            float64 `json:"at_seconds"`
+	Signal               string  `json:"signal"`
+	OldPid               int     `json:"old_pid"`
+	NewPid               int     `json:"new_pid,omitempty"`
+	ExitSeconds          float64 `json:"exit_seconds"`
+	DowntimeSeconds      float64 `json:"downtime_seconds"`
+	LinesWrittenAtKill   int64   `json:"lines_written_at_kill"`
+	LinesDeliveredAtKill *int64  `json:"lines_delivered_at_kill,omitempty"`
+}
+
+// faultInjector runs the chaos events of a run in turn
+type faultInjector struct {
+	events     []ChaosEvent
+	supervisor *shipperSupervisor
+	verifier   *deliveryVerifier
+	lag        *lagMonitor
+	counters   *writeCounters
+	records    []*restartRecord
+	lock       sync.Mutex
+}
+
+// NewFaultInjector returns an injector of events into the shipper run by
+// supervisor.  The verifier and lag monitor may be nil.
+func NewFaultInjector(events []ChaosEvent, supervisor *shipperSupervisor, verifier *deliveryVerifier, lag *lagMonitor, counters *writeCounters) *faultInjector {
+	sorted := append([]ChaosEvent{}, events...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].AtSeconds < sorted[j].AtSeconds })
+	return &faultInjector{events: sorted, supervisor: supervisor, verifier: verifier, lag: lag, counters: counters}
+}
+
+// Records returns what happened during every event run so far
+func (fi *faultInjector) Records() []*restartRecord {
+	fi.lock.Lock()
+	defer fi.lock.Unlock()
+	return append([]*restartRecord{}, fi.records...)
+}
+
+// Run waits for the time of each event, measured from the first start of
+// the shipper, and runs it until shutdown
+func (fi *faultInjector) Run(shutdownChan <-chan bool, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	for _, e := range fi.events {
+		wait := time.Duration(e.AtSeconds)*time.Second - fi.supervisor.Elapsed()
+		select {
+		case <-time.After(wait):
+		case <-shutdownChan:
+			return
+		}
+
+		name := strings.ToUpper(e.Signal)
+		sig := chaosSignals[name]
+		cmd, _ := fi.supervisor.Current()
+		r := &restartRecord{
+			AtSeconds:          fi.supervisor.Elapsed().Seconds(),
+			Signal:             name,
+			OldPid:             cmd.Process.Pid,
+			LinesWrittenAtKill: fi.counters.Lines.Value(),
+		}
+		if fi.verifier != nil {
+			delivered := fi.verifier.Received()
+			r.LinesDeliveredAtKill = &delivered
+		}
+		fmt.Printf("[INFO] Chaos: sending %s to %s (pid %d).\n", name, fi.supervisor.name, r.OldPid)
+
+		start := time.Now()
+		exitTime, restarted := fi.supervisor.Restart(sig, time.Duration(e.DowntimeSeconds)*time.Second, shutdownChan)
+		r.ExitSeconds = exitTime.Seconds()
+		if restarted {
+			newCmd, _ := fi.supervisor.Current()
+			r.NewPid = newCmd.Process.Pid
+			r.DowntimeSeconds = time.Since(start).Seconds()
+			if fi.lag != nil {
+				fi.lag.AddPid(r.NewPid)
+			}
+			fmt.Printf("[INFO] Chaos: %s restarted (pid %d) after %s.\n", fi.supervisor.name, r.NewPid, time.Since(start).Round(time.Millisecond))
+		}
+		fi.lock.Lock()
+		fi.records = append(fi.records, r)
+		fi.lock.Unlock()
+		if !restarted {
+			return
+		}
+	}
+}
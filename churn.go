@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	rate "github.com/hartfordfive/logshipper-benchmark/lib/rate"
+)
+
+// fileChurner keeps replacing the oldest log file by a new one, the way
+// container logs come and go on a busy node, while the number of files
+// being written to stays the same.
+type fileChurner struct {
+	config    *BenchmarkConfig
+	active    []*logWriter // Oldest first
+	retired   []*logWriter
+	retiredAt []time.Time
+	nextIndex int
+	created   int
+	lock      sync.Mutex
+}
+
+func NewFileChurner(config *BenchmarkConfig, writers []*logWriter) *fileChurner {
+	return &fileChurner{
+		config:    config,
+		active:    append([]*logWriter{}, writers...),
+		nextIndex: len(writers),
+		created:   len(writers),
+	}
+}
+
+// MonitorPattern returns the pattern matching every file the churner may
+// create, which shippers are configured with instead of individual paths.
+func (fc *fileChurner) MonitorPattern() string {
+	return fc.config.LogFilesBaseDir + "/*.log"
+}
+
+// FilesCreated returns the number of files created, including the initial ones
+func (fc *fileChurner) FilesCreated() int {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	return fc.created
+}
+
+// Run replaces a file every churn interval until stopChan is closed, new
+// writers taking their entries from newSource and following their share of
+// target, if any.  Each new writer is added to wg, and the files of every
+// writer are removed on shutdown, or once the removal delay is over.
+func (fc *fileChurner) Run(newSource func() entrySource, target *rate.Target, counters *writeCounters, stopChan <-chan bool, shutdownChan <-chan bool, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	removalDelay := time.Duration(fc.config.ChurnRemovalDelaySecs) * time.Second
+	ticker := time.NewTicker(time.Duration(fc.config.ChurnIntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	fmt.Printf("[INFO] Replacing a log file every %d ms.\n", fc.config.ChurnIntervalMs)
+
+	for {
+		select {
+		case <-ticker.C:
+			w, err := NewLogWriter(fc.nextIndex, fc.config)
+			if err != nil {
+				fmt.Println("[ERROR] Could not create log file: ", err)
+				continue
+			}
+			fc.nextIndex++
+			wg.Add(1)
+			go w.Run(newSource(), target, counters, stopChan, shutdownChan, wg)
+
+			oldest := fc.active[0]
+			fc.active = append(fc.active[1:], w)
+			oldest.Retire()
+			fc.retired = append(fc.retired, oldest)
+			fc.retiredAt = append(fc.retiredAt, time.Now())
+
+			fc.lock.Lock()
+			fc.created++
+			fc.lock.Unlock()
+
+			for len(fc.retired) > 0 && time.Since(fc.retiredAt[0]) >= removalDelay {
+				fc.retired[0].RemoveFiles()
+				fc.retired, fc.retiredAt = fc.retired[1:], fc.retiredAt[1:]
+			}
+		case <-stopChan:
+			<-shutdownChan
+			// Active writers remove their own files as they shut down
+			for _, w := range fc.retired {
+				w.RemoveFiles()
+			}
+			fc.retired, fc.retiredAt = nil, nil
+			return
+		}
+	}
+}
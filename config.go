@@ -5,28 +5,70 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+
+	cgroup "github.com/hartfordfive/logshipper-benchmark/lib/cgroup"
+	kafka "github.com/hartfordfive/logshipper-benchmark/lib/kafka"
+	rate "github.com/hartfordfive/logshipper-benchmark/lib/rate"
 )
 
 type BenchmarkConfig struct {
-	LogLineSize           int      `json:"log_line_size"`
-	NumActiveLogFiles     int      `json:"num_active_log_files"`
-	EnableRandom          bool     `json:"enable_random"`
-	RandomLineSize        []int    `json:"random_line_size"`
-	RandomWriteWait       []int    `json:"random_write_wait"`
-	LogFilesBaseDir       string   `json:"log_files_base_dir"`
-	WriteWaitPeriodMs     int      `json:"write_wait_period_ms"`
-	LogShipperName        string   `json:"log_shipper_name"`
-	LogShipperProcessName string   `json:"log_shipper_process_name"`
-	ModuleDir             string   `json:"module_dir"`
-	ModuleName            string   `json:"module_name"`
-	LogShipperBinPath     string   `json:"log_shipper_bin_path"`
-	LogShipperFlags       string   `json:"log_shipper_flags"`
-	MetricsDir            string   `json:"metrics_dir"`
-	WorkingDir            string   `json:"working_dir"`
-	MaxProcs              int      `json:"max_procs"`
-	CustomLogEntry        string   `json:"custom_log_entry"`
-	KafkaBrokerList       []string `json:"kafka_broker_list"`
-	TotalRunTimeSeconds   int64    `json:"total_run_time_seconds"`
+	LogLineSize               int                `json:"log_line_size"`
+	LogFormat                 string             `json:"log_format"`
+	NumActiveLogFiles         int                `json:"num_active_log_files"`
+	EnableRandom              bool               `json:"enable_random"`
+	RandomLineSize            []int              `json:"random_line_size"`
+	RandomWriteWait           []int              `json:"random_write_wait"`
+	LogFilesBaseDir           string             `json:"log_files_base_dir"`
+	WriteWaitPeriodMs         int                `json:"write_wait_period_ms"`
+	TargetLinesPerSecond      float64            `json:"target_lines_per_second"`
+	TargetMbPerSecond         float64            `json:"target_mb_per_second"`
+	LoadProfile               *rate.Profile      `json:"load_profile"`
+	RotationMode              string             `json:"rotation_mode"`
+	RotationMaxSizeKb         int                `json:"rotation_max_size_kb"`
+	RotationIntervalSecs      int                `json:"rotation_interval_seconds"`
+	RotationMaxFiles          int                `json:"rotation_max_files"`
+	ChurnIntervalMs           int                `json:"churn_interval_ms"`
+	ChurnRemovalDelaySecs     int                `json:"churn_removal_delay_seconds"`
+	FileScanIntervalSecs      int                `json:"file_scan_interval_seconds"`
+	LogShipperName            string             `json:"log_shipper_name"`
+	LogShipperProcessName     string             `json:"log_shipper_process_name"`
+	ModuleDir                 string             `json:"module_dir"`
+	ModuleName                string             `json:"module_name"`
+	LogShipperBinPath         string             `json:"log_shipper_bin_path"`
+	ShipperCgroup             *cgroup.Limits     `json:"shipper_cgroup"`
+	LogShipperFlags           string             `json:"log_shipper_flags"`
+	MetricCollector           string             `json:"metric_collector"`
+	MetricbeatBinPath         string             `json:"metricbeat_bin_path"`
+	MetricsPeriodMs           int                `json:"metrics_period_ms"`
+	MetricsDir                string             `json:"metrics_dir"`
+	WorkingDir                string             `json:"working_dir"`
+	HarnessCPUs               string             `json:"harness_cpus"`
+	ShipperCPUs               string             `json:"shipper_cpus"`
+	ShipperNice               int                `json:"shipper_nice"`
+	ShipperIONice             string             `json:"shipper_ionice"`
+	MaxProcs                  int                `json:"max_procs"`
+	CustomLogEntry            string             `json:"custom_log_entry"`
+	CorpusSize                int                `json:"corpus_size"`
+	CorpusEntropy             float64            `json:"corpus_entropy"`
+	ReplayFiles               []string           `json:"replay_files"`
+	ReplayTiming              string             `json:"replay_timing"`
+	ReplaySpeed               float64            `json:"replay_speed"`
+	ReplayLoop                bool               `json:"replay_loop"`
+	KafkaBrokerList           []string           `json:"kafka_broker_list"`
+	TotalRunTimeSeconds       int64              `json:"total_run_time_seconds"`
+	WarmupSeconds             int                `json:"warmup_seconds"`
+	WarmupTimeoutSecs         int                `json:"warmup_timeout_seconds"`
+	DrainTimeoutSecs          int                `json:"drain_timeout_seconds"`
+	DrainStableSecs           int                `json:"drain_stable_seconds"`
+	ChaosEvents               []ChaosEvent       `json:"chaos_events"`
+	Repetitions               int                `json:"repetitions"`
+	RepetitionCooldownSeconds int                `json:"repetition_cooldown_seconds"`
+	StampLines                bool               `json:"stamp_lines"`
+	VerifyDelivery            bool               `json:"verify_delivery"`
+	VerifyGracePeriodSecs     int                `json:"verify_grace_period_seconds"`
+	EmbeddedBrokerAddr        string             `json:"embedded_broker_addr"`
+	EmbeddedBrokerRetentionMb int                `json:"embedded_broker_retention_mb"`
+	SinkSchedule              []kafka.SinkWindow `json:"sink_schedule"`
 }
 
 func LoadConfig(confPath string) *BenchmarkConfig {
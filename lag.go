@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	utils "github.com/hartfordfive/logshipper-benchmark/lib"
+	procstats "github.com/hartfordfive/logshipper-benchmark/lib/procstats"
+)
+
+// Where the read position of a file was found
+const (
+	positionRegistry = "registry"
+	positionFdinfo   = "fdinfo"
+	positionNone     = "none"
+)
+
+// lagEvent is a sample of how far behind the shipper is in reading the log
+// files, written next to the metrics data file like the load timeline.
+type lagEvent struct {
+	Timestamp string            `json:"@timestamp"`
+	Metricset map[string]string `json:"metricset"`
+	Lag       lagSample         `json:"lag"`
+}
+
+type lagSample struct {
+	TotalBytes  int64     `json:"total_bytes"`
+	MaxBytes    int64     `json:"max_bytes"`
+	Files       int       `json:"files"`
+	FilesBehind int       `json:"files_behind"`
+	PerFile     []fileLag `json:"per_file"`
+}
+
+type fileLag struct {
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Position int64  `json:"position"`
+	LagBytes int64  `json:"lag_bytes"`
+	Source   string `json:"source"`
+}
+
+// lagSummary holds the lag over the run
+type lagSummary struct {
+	MaxBytes     int64  `json:"max_bytes"`
+	FinalBytes   int64  `json:"final_bytes"`
+	TimelineFile string `json:"timeline_file"`
+}
+
+// lagTimelineFile returns the path of the lag timeline of a metrics data file
+func lagTimelineFile(metricsDataFile string) string {
+	return strings.TrimSuffix(metricsDataFile, ".log") + "-lag.log"
+}
+
+// lagMonitor compares the size of every monitored file with how far the
+// shipper read it.  The position is taken from the shipper's registry when
+// it has one, and otherwise from the offsets of the files its processes have
+// open.  As shippers close files they're done with, the last position seen
+// is kept for each file.
+type lagMonitor struct {
+	filePath  string
+	patterns  []string
+	reporter  PositionReporter
+	tracker   *procstats.Tracker
+	positions map[string]int64
+	sources   map[string]string
+	summary   lagSummary
+	sampled   time.Time
+	reading   chan bool
+	seen      bool // Whether reading was closed
+	pids      chan int
+	lock      sync.Mutex
+}
+
+// NewLagMonitor returns a monitor of the files matched by patterns, read by
+// the shipper process pid along with its descendants and the processes
+// named after processName.  The reporter may be nil.
+func NewLagMonitor(filePath string, patterns []string, pid int, processName string, reporter PositionReporter) (*lagMonitor, error) {
+	tracker := procstats.NewTracker(utils.GetClockTicksPerSecond())
+	if err := tracker.AddPid(pid); err != nil {
+		return nil, err
+	}
+	if processName != "" {
+		if err := tracker.AddName(processName); err != nil {
+			return nil, err
+		}
+	}
+	return &lagMonitor{
+		filePath:  filePath,
+		patterns:  patterns,
+		reporter:  reporter,
+		tracker:   tracker,
+		positions: map[string]int64{},
+		sources:   map[string]string{},
+		summary:   lagSummary{TimelineFile: filePath},
+		reading:   make(chan bool),
+		pids:      make(chan int, 16),
+	}, nil
+}
+
+// Summary returns the largest and the last total lag seen
+func (lm *lagMonitor) Summary() *lagSummary {
+	lm.lock.Lock()
+	defer lm.lock.Unlock()
+	summary := lm.summary
+	return &summary
+}
+
+// AddPid follows another process of the shipper, such as once it restarted
+func (lm *lagMonitor) AddPid(pid int) {
+	select {
+	case lm.pids <- pid:
+	default:
+	}
+}
+
+// Reading returns a channel closed once the shipper is seen reading any file
+func (lm *lagMonitor) Reading() <-chan bool {
+	return lm.reading
+}
+
+// Caught tells whether the shipper read everything as of a sample taken
+// after since
+func (lm *lagMonitor) Caught(since time.Time) bool {
+	lm.lock.Lock()
+	defer lm.lock.Unlock()
+	return lm.sampled.After(since) && lm.summary.FinalBytes == 0
+}
+
+// Backlog returns the total lag of the last sample
+func (lm *lagMonitor) Backlog() int64 {
+	lm.lock.Lock()
+	defer lm.lock.Unlock()
+	return lm.summary.FinalBytes
+}
+
+// resolvePath returns the absolute path of a file with symlinks resolved,
+// which is how the files the shipper has open are named
+func resolvePath(p string) (string, error) {
+	p, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(p); err == nil {
+		p = resolved
+	}
+	return p, nil
+}
+
+// positionsRead returns the positions the shipper currently reports for the
+// monitored files, keeping the furthest one when both sources know a file.
+// The shipper's other files, such as its jars, config or registry, are left
+// out.
+func (lm *lagMonitor) positionsRead(monitored map[string]bool) (map[string]int64, map[string]string) {
+	positions, sources := map[string]int64{}, map[string]string{}
+	update := func(found map[string]int64, source string) {
+		for p, pos := range found {
+			p, err := resolvePath(p)
+			if err != nil || !monitored[p] {
+				continue
+			}
+			if prev, ok := positions[p]; !ok || pos > prev {
+				positions[p], sources[p] = pos, source
+			}
+		}
+	}
+	if lm.reporter != nil {
+		if found, err := lm.reporter.ReadPositions(); err == nil {
+			update(found, positionRegistry)
+		} else if utils.Debug {
+			fmt.Printf("[DEBUG] Could not read the shipper registry: %s\n", err)
+		}
+	}
+	// The tracker is only used by this goroutine
+	for len(lm.pids) > 0 {
+		if err := lm.tracker.AddPid(<-lm.pids); err != nil && utils.Debug {
+			fmt.Printf("[DEBUG] Could not follow the shipper: %s\n", err)
+		}
+	}
+	if _, err := lm.tracker.Sample(); err == nil {
+		for _, pid := range lm.tracker.Pids() {
+			if found, err := procstats.FileOffsets(pid); err == nil {
+				update(found, positionFdinfo)
+			}
+		}
+	}
+	return positions, sources
+}
+
+// monitoredFiles returns the files currently matched by the patterns, by
+// their resolved path, along with their size
+func (lm *lagMonitor) monitoredFiles() map[string]int64 {
+	files := map[string]int64{}
+	for _, pattern := range lm.patterns {
+		matches, _ := filepath.Glob(pattern)
+		for _, m := range matches {
+			info, err := os.Stat(m)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			if p, err := resolvePath(m); err == nil {
+				files[p] = info.Size()
+			}
+		}
+	}
+	return files
+}
+
+func (lm *lagMonitor) sample() lagSample {
+	files := lm.monitoredFiles()
+	monitored := make(map[string]bool, len(files))
+	for p := range files {
+		monitored[p] = true
+	}
+	for p := range lm.positions {
+		if !monitored[p] {
+			// The file was removed or rotated away
+			delete(lm.positions, p)
+			delete(lm.sources, p)
+		}
+	}
+	positions, sources := lm.positionsRead(monitored)
+	for p, pos := range positions {
+		lm.positions[p], lm.sources[p] = pos, sources[p]
+		if pos > 0 && !lm.seen {
+			close(lm.reading)
+			lm.seen = true
+		}
+	}
+
+	var s lagSample
+	for p, size := range files {
+		fl := fileLag{Path: p, Size: size, Source: positionNone}
+		if pos, ok := lm.positions[p]; ok && pos <= fl.Size {
+			fl.Position, fl.Source = pos, lm.sources[p]
+		} else if ok {
+			// The file was truncated or replaced, and is read again from the start
+			delete(lm.positions, p)
+		}
+		fl.LagBytes = fl.Size - fl.Position
+		s.TotalBytes += fl.LagBytes
+		if fl.LagBytes > s.MaxBytes {
+			s.MaxBytes = fl.LagBytes
+		}
+		if fl.LagBytes > 0 {
+			s.FilesBehind++
+		}
+		s.PerFile = append(s.PerFile, fl)
+	}
+	s.Files = len(s.PerFile)
+	sort.Slice(s.PerFile, func(i, j int) bool { return s.PerFile[i].Path < s.PerFile[j].Path })
+	return s
+}
+
+// Run samples the lag every period until shutdown
+func (lm *lagMonitor) Run(period time.Duration, shutdownChan <-chan bool, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	utils.CreateDir(path.Dir(lm.filePath))
+	file, err := os.OpenFile(lm.filePath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		fmt.Printf("[ERROR] Could not open %s: %s\n", lm.filePath, err)
+		return
+	}
+	defer file.Close()
+	out := bufio.NewWriter(file)
+	defer out.Flush()
+	enc := json.NewEncoder(out)
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			s := lm.sample()
+			enc.Encode(&lagEvent{
+				Timestamp: now.UTC().Format(time.RFC3339Nano),
+				Metricset: map[string]string{"module": "benchmark", "name": "lag"},
+				Lag:       s,
+			})
+			lm.lock.Lock()
+			if s.TotalBytes > lm.summary.MaxBytes {
+				lm.summary.MaxBytes = s.TotalBytes
+			}
+			lm.summary.FinalBytes = s.TotalBytes
+			lm.sampled = time.Now()
+			lm.lock.Unlock()
+		case <-shutdownChan:
+			return
+		}
+	}
+}
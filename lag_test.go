@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLagMonitorOnlyFollowsMonitoredFiles(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	confPath := filepath.Join(dir, "shipper.yml")
+	for _, p := range []string{logPath, confPath} {
+		if err := os.WriteFile(p, []byte("0123456789"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The test plays the shipper, reading its config and then the log file
+	lm, err := NewLagMonitor(filepath.Join(dir, "lag.log"), []string{filepath.Join(dir, "*.log")}, os.Getpid(), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := func(p string, n int) {
+		fh, err := os.Open(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { fh.Close() })
+		if _, err := io.ReadFull(fh, make([]byte, n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read(confPath, 5)
+	s := lm.sample()
+	if lm.seen {
+		t.Error("reading the config was taken for reading a log file")
+	}
+	if _, ok := lm.positions[confPath]; ok {
+		t.Errorf("positions = %v, want no position for %s", lm.positions, confPath)
+	}
+	if s.Files != 1 || s.TotalBytes != 10 {
+		t.Errorf("sample = %+v, want a file 10 bytes behind", s)
+	}
+
+	read(logPath, 4)
+	s = lm.sample()
+	select {
+	case <-lm.Reading():
+	default:
+		t.Error("reading wasn't closed once the log file was read")
+	}
+	if s.Files != 1 || s.TotalBytes != 6 || s.PerFile[0].Source != positionFdinfo {
+		t.Errorf("sample = %+v, want a file 6 bytes behind, read from fdinfo", s)
+	}
+}
@@ -0,0 +1,269 @@
+package cgroup
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultParent is where cgroup v2 is usually mounted
+const DefaultParent = "/sys/fs/cgroup"
+
+// cpuPeriodUs is the period of cpu.max, the quota being a share of it
+const cpuPeriodUs = 100000
+
+// Limits are the resources the processes of a group may use.  Zero values
+// leave the resource unlimited.
+type Limits struct {
+	Parent      string   `json:"parent"`
+	CPUs        float64  `json:"cpus"`
+	MemoryMaxMb int      `json:"memory_max_mb"`
+	IOMax       []string `json:"io_max"`
+}
+
+// controllers returns the controllers the limits require
+func (l *Limits) controllers() []string {
+	var c []string
+	if l.CPUs > 0 {
+		c = append(c, "cpu")
+	}
+	if l.MemoryMaxMb > 0 {
+		c = append(c, "memory")
+	}
+	if len(l.IOMax) > 0 {
+		c = append(c, "io")
+	}
+	return c
+}
+
+// Check returns an error if the limits can't be applied
+func (l *Limits) Check() error {
+	if l.CPUs < 0 || l.MemoryMaxMb < 0 {
+		return fmt.Errorf("cpus and memory_max_mb can't be negative")
+	}
+	for _, line := range l.IOMax {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.Contains(fields[0], ":") {
+			return fmt.Errorf("io_max entries must be of the form 'MAJ:MIN rbps=N wbps=N riops=N wiops=N': %s", line)
+		}
+	}
+	return nil
+}
+
+// Group is a transient cgroup v2 the shipper runs in
+type Group struct {
+	path string
+}
+
+// New creates the group name under the parent of limits and applies them.
+// The controllers required are enabled in the parent, which must be part of
+// a cgroup v2 hierarchy.
+func New(name string, limits Limits) (*Group, error) {
+	parent := limits.Parent
+	if parent == "" {
+		parent = DefaultParent
+	}
+	available, err := ioutil.ReadFile(filepath.Join(parent, "cgroup.controllers"))
+	if err != nil {
+		return nil, fmt.Errorf("%s isn't part of a cgroup v2 hierarchy: %s", parent, err)
+	}
+	enabled, _ := ioutil.ReadFile(filepath.Join(parent, "cgroup.subtree_control"))
+	for _, c := range limits.controllers() {
+		if !hasWord(string(available), c) {
+			return nil, fmt.Errorf("the %s controller isn't available in %s", c, parent)
+		}
+		if hasWord(string(enabled), c) {
+			continue
+		}
+		if err := write(filepath.Join(parent, "cgroup.subtree_control"), "+"+c); err != nil {
+			return nil, fmt.Errorf("could not enable the %s controller in %s: %s", c, parent, err)
+		}
+	}
+
+	g := &Group{path: filepath.Join(parent, name)}
+	if err := os.Mkdir(g.path, 0755); err != nil {
+		return nil, err
+	}
+	if err := g.apply(limits); err != nil {
+		g.Remove()
+		return nil, err
+	}
+	return g, nil
+}
+
+func (g *Group) apply(limits Limits) error {
+	if limits.CPUs > 0 {
+		quota := int64(limits.CPUs * cpuPeriodUs)
+		if err := write(g.file("cpu.max"), fmt.Sprintf("%d %d", quota, cpuPeriodUs)); err != nil {
+			return err
+		}
+	}
+	if limits.MemoryMaxMb > 0 {
+		if err := write(g.file("memory.max"), strconv.FormatInt(int64(limits.MemoryMaxMb)*1024*1024, 10)); err != nil {
+			return err
+		}
+		// Like containers, the group can't make up for the limit with swap
+		if _, err := os.Stat(g.file("memory.swap.max")); err == nil {
+			if err := write(g.file("memory.swap.max"), "0"); err != nil {
+				return err
+			}
+		}
+	}
+	for _, line := range limits.IOMax {
+		if err := write(g.file("io.max"), line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Path returns the directory of the group
+func (g *Group) Path() string {
+	return g.path
+}
+
+// Open returns the directory of the group, which processes can be started
+// in with SysProcAttr.CgroupFD
+func (g *Group) Open() (*os.File, error) {
+	return os.Open(g.path)
+}
+
+// Remove kills any process left in the group and removes it
+func (g *Group) Remove() error {
+	if _, err := os.Stat(g.file("cgroup.kill")); err == nil {
+		write(g.file("cgroup.kill"), "1")
+	}
+	var err error
+	for i := 0; i < 20; i++ {
+		// The group is busy until the processes killed are gone
+		if err = os.Remove(g.path); err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return err
+}
+
+// Stats holds the accounting of a group.  Counters of controllers which
+// aren't enabled are left at zero.
+type Stats struct {
+	CPUUsageSeconds  float64 `json:"cpu_usage_seconds"`
+	CPUUserSeconds   float64 `json:"cpu_user_seconds"`
+	CPUSystemSeconds float64 `json:"cpu_system_seconds"`
+	CPUPeriods       uint64  `json:"cpu_periods"`
+	CPUThrottled     uint64  `json:"cpu_throttled_periods"`
+	CPUThrottledSecs float64 `json:"cpu_throttled_seconds"`
+	MemoryCurrent    uint64  `json:"memory_current_bytes"`
+	MemoryPeak       uint64  `json:"memory_peak_bytes"`
+	MemoryHighEvents uint64  `json:"memory_high_events"`
+	MemoryMaxEvents  uint64  `json:"memory_max_events"`
+	MemoryOOMEvents  uint64  `json:"memory_oom_events"`
+	MemoryOOMKills   uint64  `json:"memory_oom_kills"`
+	IOReadBytes      uint64  `json:"io_read_bytes"`
+	IOWriteBytes     uint64  `json:"io_write_bytes"`
+	ProcessesCurrent uint64  `json:"processes_current"`
+}
+
+// Stats reads the accounting of the group
+func (g *Group) Stats() (Stats, error) {
+	var s Stats
+	cpu, err := readKeyValues(g.file("cpu.stat"))
+	if err != nil {
+		return s, err
+	}
+	s.CPUUsageSeconds = float64(cpu["usage_usec"]) / 1e6
+	s.CPUUserSeconds = float64(cpu["user_usec"]) / 1e6
+	s.CPUSystemSeconds = float64(cpu["system_usec"]) / 1e6
+	s.CPUPeriods, s.CPUThrottled = cpu["nr_periods"], cpu["nr_throttled"]
+	s.CPUThrottledSecs = float64(cpu["throttled_usec"]) / 1e6
+
+	s.MemoryCurrent = readUint(g.file("memory.current"))
+	s.MemoryPeak = readUint(g.file("memory.peak"))
+	if events, err := readKeyValues(g.file("memory.events")); err == nil {
+		s.MemoryHighEvents, s.MemoryMaxEvents = events["high"], events["max"]
+		s.MemoryOOMEvents, s.MemoryOOMKills = events["oom"], events["oom_kill"]
+	}
+	s.IOReadBytes, s.IOWriteBytes = readIOStat(g.file("io.stat"))
+
+	if procs, err := ioutil.ReadFile(g.file("cgroup.procs")); err == nil {
+		s.ProcessesCurrent = uint64(len(strings.Fields(string(procs))))
+	}
+	return s, nil
+}
+
+func (g *Group) file(name string) string {
+	return filepath.Join(g.path, name)
+}
+
+func write(filePath string, value string) error {
+	return ioutil.WriteFile(filePath, []byte(value), 0644)
+}
+
+func hasWord(s string, word string) bool {
+	for _, w := range strings.Fields(s) {
+		if w == word {
+			return true
+		}
+	}
+	return false
+}
+
+// readKeyValues reads a flat keyed file such as cpu.stat
+func readKeyValues(filePath string) (map[string]uint64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	values := map[string]uint64{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = v
+		}
+	}
+	return values, scanner.Err()
+}
+
+// readUint reads a single value file, 0 meaning it's missing or unlimited
+func readUint(filePath string) uint64 {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return 0
+	}
+	v, _ := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	return v
+}
+
+// readIOStat sums the bytes read and written on every device
+func readIOStat(filePath string) (read uint64, written uint64) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return 0, 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		for _, field := range strings.Fields(line) {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			v, _ := strconv.ParseUint(kv[1], 10, 64)
+			switch kv[0] {
+			case "rbytes":
+				read += v
+			case "wbytes":
+				written += v
+			}
+		}
+	}
+	return read, written
+}
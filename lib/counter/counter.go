@@ -1,8 +1,10 @@
 package counter
 
+import "sync/atomic"
+
 type Counter struct {
 	accumulator chan int64
-	count       int64
+	count       int64 // Only accessed atomically, as it's read from any goroutine
 	terminate   chan bool
 	reset       chan bool
 }
@@ -21,14 +23,14 @@ func (c *Counter) start() {
 	for {
 		select {
 		case <-c.reset:
-			c.count = 0
+			atomic.StoreInt64(&c.count, 0)
 		case <-c.terminate:
 			close(c.accumulator)
 			close(c.reset)
 			close(c.terminate)
 			return
 		case incr := <-c.accumulator:
-			c.count += int64(incr)
+			atomic.AddInt64(&c.count, int64(incr))
 		}
 	}
 }
@@ -38,7 +40,7 @@ func (c *Counter) Incr(val int64) {
 }
 
 func (c *Counter) Value() int64 {
-	return c.count
+	return atomic.LoadInt64(&c.count)
 }
 
 func (c *Counter) Reset() {
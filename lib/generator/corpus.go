@@ -0,0 +1,53 @@
+package generator
+
+import (
+	"math/rand"
+)
+
+const entropyChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789+/"
+
+// Corpus is a set of distinct entries generated ahead of time, which writers
+// cycle through so each write has different content without the cost of
+// generating it.  It isn't modified once created, so it's safe to share.
+type Corpus struct {
+	entries []Entry
+	maxLen  int
+}
+
+// NewCorpus generates count entries with g, each with the size returned by
+// size.  The entropy, between 0 and 1, is the fraction of the bytes of each
+// message which are replaced by random characters: natural text compresses
+// well while random characters hardly do.
+func NewCorpus(g Generator, count int, size func() int, entropy float64) *Corpus {
+	if count < 1 {
+		count = 1
+	}
+	c := &Corpus{entries: make([]Entry, count)}
+	for i := range c.entries {
+		e := g.Generate(size())
+		if entropy > 0 {
+			msg := e.Text[e.MessageOffset : e.MessageOffset+e.MessageLen]
+			for j := range msg {
+				if rand.Float64() < entropy {
+					msg[j] = entropyChars[rand.Intn(len(entropyChars))]
+				}
+			}
+		}
+		if len(e.Text) > c.maxLen {
+			c.maxLen = len(e.Text)
+		}
+		c.entries[i] = e
+	}
+	return c
+}
+
+// Len returns the number of entries in the corpus
+func (c *Corpus) Len() int { return len(c.entries) }
+
+// MaxLen returns the size of the largest entry
+func (c *Corpus) MaxLen() int { return c.maxLen }
+
+// Entry returns the i-th entry, wrapping around the end of the corpus
+func (c *Corpus) Entry(i int) *Entry {
+	return &c.entries[i%len(c.entries)]
+}
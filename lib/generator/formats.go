@@ -0,0 +1,141 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/Pallinder/go-randomdata"
+
+	utils "github.com/hartfordfive/logshipper-benchmark/lib"
+)
+
+func init() {
+	Register("random", func() Generator { return randomGenerator{} })
+	Register("combined", func() Generator { return combinedGenerator{} })
+	Register("rfc3164", func() Generator { return rfc3164Generator{} })
+	Register("rfc5424", func() Generator { return rfc5424Generator{} })
+	Register("json", func() Generator { return jsonGenerator{} })
+	Register("java", func() Generator { return javaGenerator{} })
+}
+
+// randomGenerator produces a line of random words, which is entirely free text
+type randomGenerator struct{}
+
+func (g randomGenerator) Generate(size int) Entry {
+	text := utils.GenerateRandomString(size)
+	return Entry{Text: []byte(text + "\n"), MessageLen: len(text)}
+}
+
+// combinedGenerator produces Apache/nginx combined access log lines.  The
+// user agent holds the free text, as it's the only quoted field of any size.
+type combinedGenerator struct{}
+
+func (g combinedGenerator) Generate(size int) Entry {
+	prefix := fmt.Sprintf("%s - %s [%s] \"%s /%s/%s HTTP/1.1\" %s %d \"%s\" \"",
+		randomdata.IpV4Address(),
+		pick("-", randomdata.SillyName()),
+		time.Now().Format("02/Jan/2006:15:04:05 -0700"),
+		pick("GET", "GET", "GET", "POST", "PUT", "DELETE"),
+		randomdata.Noun(), randomdata.Adjective(),
+		pick("200", "200", "200", "201", "301", "304", "404", "500"),
+		rand.Intn(50000),
+		pick("-", "https://www.example.com/"+randomdata.Noun()),
+	)
+	return newEntry(prefix+randomdata.UserAgentString()+" ", "\"", size)
+}
+
+// rfc3164Generator produces BSD syslog lines
+type rfc3164Generator struct{}
+
+func (g rfc3164Generator) Generate(size int) Entry {
+	prefix := fmt.Sprintf("<%d>%s %s %s[%d]: ",
+		rand.Intn(192),
+		time.Now().Format(time.Stamp),
+		randomdata.SillyName(),
+		pick("sshd", "cron", "kernel", "systemd", "postfix"),
+		rand.Intn(65536),
+	)
+	return newEntry(prefix, "", size)
+}
+
+// rfc5424Generator produces IETF syslog lines with structured data
+type rfc5424Generator struct{}
+
+func (g rfc5424Generator) Generate(size int) Entry {
+	prefix := fmt.Sprintf("<%d>1 %s %s %s %d %s [meta@32473 requestId=\"%d\" source=\"%s\"] ",
+		rand.Intn(192),
+		time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+		randomdata.SillyName(),
+		pick("api", "worker", "scheduler", "gateway"),
+		rand.Intn(65536),
+		pick("ID47", "AUDIT", "REQ", "-"),
+		rand.Int63(),
+		randomdata.Noun(),
+	)
+	return newEntry(prefix, "", size)
+}
+
+// jsonGenerator produces structured JSON objects with nested fields, the
+// message field holding the free text
+type jsonGenerator struct{}
+
+func (g jsonGenerator) Generate(size int) Entry {
+	prefix := fmt.Sprintf("{\"@timestamp\":\"%s\",\"level\":\"%s\",\"logger\":\"com.example.%sService\",\"thread\":\"worker-%d\",\"message\":\"",
+		time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+		pick("DEBUG", "INFO", "INFO", "INFO", "WARN", "ERROR"),
+		randomdata.Noun(),
+		rand.Intn(64),
+	)
+	suffix := fmt.Sprintf("\",\"http\":{\"method\":\"%s\",\"status\":%s,\"duration_ms\":%.3f},\"user\":{\"id\":%d,\"name\":\"%s\",\"geo\":{\"country\":\"%s\",\"city\":\"%s\"}},\"tags\":[\"%s\",\"%s\"]}",
+		pick("GET", "POST", "PUT", "DELETE"),
+		pick("200", "201", "404", "500"),
+		rand.Float64()*1000,
+		rand.Intn(1000000),
+		randomdata.SillyName(),
+		randomdata.Country(randomdata.TwoCharCountry),
+		randomdata.City(),
+		randomdata.Noun(), randomdata.Adjective(),
+	)
+	return newEntry(prefix, suffix, size)
+}
+
+// javaGenerator produces multi-line Java exceptions.  The size is reached by
+// adding stack frames, the free text being the message of the first line.
+type javaGenerator struct{}
+
+func (g javaGenerator) FirstLine() string {
+	return `^[0-9]{4}-[0-9]{2}-[0-9]{2} [0-9]{2}:[0-9]{2}:[0-9]{2}`
+}
+
+func (g javaGenerator) Generate(size int) Entry {
+	prefix := fmt.Sprintf("%s ERROR [worker-%d] com.example.%sService - Request failed: ",
+		time.Now().Format("2006-01-02 15:04:05.000"),
+		rand.Intn(64),
+		randomdata.Noun(),
+	)
+	msgLen := minMessageLen
+
+	var trace bytes.Buffer
+	trace.WriteString(fmt.Sprintf("\njava.lang.%s: %s", pick("IllegalStateException", "NullPointerException", "IllegalArgumentException"), randomdata.Noun()))
+	frames := 0
+	for frames < 2 || len(prefix)+msgLen+trace.Len() < size-120 {
+		if frames > 0 && frames%8 == 0 {
+			trace.WriteString(fmt.Sprintf("\nCaused by: java.io.IOException: %s %s", randomdata.Adjective(), randomdata.Noun()))
+		}
+		trace.WriteString(fmt.Sprintf("\n\tat com.example.%s.%s%s(%s.java:%d)",
+			randomdata.Noun(), randomdata.Adjective(), randomdata.Noun(), randomdata.Noun(), rand.Intn(2000)+1))
+		frames++
+	}
+	trace.WriteString(fmt.Sprintf("\n\t... %d more", rand.Intn(40)+1))
+
+	// Whatever is left is given to the message
+	if rest := size - len(prefix) - trace.Len(); rest > msgLen {
+		msgLen = rest
+	}
+	e := newEntry(prefix, "", len(prefix)+msgLen)
+	e.Text = append(e.Text[:len(e.Text)-1], trace.Bytes()...)
+	e.Text = append(e.Text, '\n')
+	return e
+}
@@ -0,0 +1,119 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/Pallinder/go-randomdata"
+)
+
+// Entry is a generated log entry, which may span several lines
+type Entry struct {
+	Text          []byte // Always ends with a newline
+	MessageOffset int    // Start of the free text part of the entry
+	MessageLen    int    // Length of the free text part of the entry
+}
+
+// Generator produces log entries in a given format
+type Generator interface {
+	// Generate returns a new entry of size bytes, not counting the final
+	// newline, or as close to it as the format allows.  The free text part is
+	// the one stretched or shortened.
+	Generate(size int) Entry
+}
+
+// Multiline is implemented by generators whose entries span several lines
+type Multiline interface {
+	// FirstLine returns a regexp matching the first line of entries but
+	// none of the following ones.  It sticks to what PCRE, POSIX extended and
+	// Go regexps have in common, so every shipper accepts it.
+	FirstLine() string
+}
+
+var generators = map[string]func() Generator{}
+
+// Register makes a generator available under the given format name
+func Register(format string, factory func() Generator) {
+	generators[format] = factory
+}
+
+// New returns a generator of the given format
+func New(format string) (Generator, error) {
+	factory, ok := generators[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown log format: %s (available: %s)", format, strings.Join(Formats(), ", "))
+	}
+	return factory(), nil
+}
+
+// Formats returns the names of the registered formats
+func Formats() []string {
+	var formats []string
+	for name := range generators {
+		formats = append(formats, name)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+type fixedGenerator struct {
+	text string
+}
+
+// Fixed returns a generator which always returns the same text, whatever
+// the size requested.
+func Fixed(text string) Generator {
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return &fixedGenerator{text: text}
+}
+
+func (g *fixedGenerator) Generate(size int) Entry {
+	return Entry{Text: []byte(g.text), MessageLen: len(g.text) - 1}
+}
+
+// newEntry builds an entry from the text before and after its message, with
+// the message stretched so the whole entry is size bytes plus the newline.
+// The message is never shorter than minMessageLen.
+func newEntry(prefix string, suffix string, size int) Entry {
+	msgLen := size - len(prefix) - len(suffix)
+	if msgLen < minMessageLen {
+		msgLen = minMessageLen
+	}
+	text := make([]byte, 0, len(prefix)+msgLen+len(suffix)+1)
+	text = append(text, prefix...)
+	text = append(text, filler(msgLen)...)
+	text = append(text, suffix...)
+	text = append(text, '\n')
+	return Entry{Text: text, MessageOffset: len(prefix), MessageLen: msgLen}
+}
+
+// minMessageLen leaves room in every message for the stamp of the delivery
+// verifier.
+const minMessageLen = 48
+
+// filler returns n bytes of plain text, without any character which would
+// need escaping in any of the formats.
+func filler(n int) string {
+	var b bytes.Buffer
+	for b.Len() < n {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		for _, r := range randomdata.Paragraph() {
+			if r == '"' || r == '\\' || r == '\n' || r > 127 {
+				continue
+			}
+			b.WriteRune(r)
+		}
+	}
+	return b.String()[:n]
+}
+
+func pick(values ...string) string {
+	return values[rand.Intn(len(values))]
+}
@@ -0,0 +1,107 @@
+package histogram
+
+import (
+	"math/bits"
+)
+
+// Every power of two is split in this many linear sub-buckets, which keeps
+// the relative error of a recorded value under 1/subBuckets.
+const (
+	subBucketBits = 6
+	subBuckets    = 1 << subBucketBits
+	numBuckets    = (64 - subBucketBits + 1) * subBuckets
+)
+
+// Histogram counts non-negative values in log-linear buckets, so quantiles
+// can be computed over millions of samples in constant memory.  It isn't
+// safe for concurrent use.
+type Histogram struct {
+	counts [numBuckets]int64
+	count  int64
+	sum    float64
+	min    int64
+	max    int64
+}
+
+func New() *Histogram {
+	return &Histogram{}
+}
+
+func bucketOf(v int64) int {
+	if v < subBuckets {
+		return int(v)
+	}
+	shift := uint(bits.Len64(uint64(v)) - subBucketBits - 1)
+	return int(shift+1)*subBuckets + int(v>>shift) - subBuckets
+}
+
+// lowerBound returns the smallest value falling in bucket i
+func lowerBound(i int) int64 {
+	if i < subBuckets {
+		return int64(i)
+	}
+	shift := uint(i/subBuckets - 1)
+	return int64(i%subBuckets+subBuckets) << shift
+}
+
+// Record adds a value, negative ones counting as zero
+func (h *Histogram) Record(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	h.counts[bucketOf(v)]++
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+	h.count++
+	h.sum += float64(v)
+}
+
+func (h *Histogram) Count() int64 {
+	return h.count
+}
+
+func (h *Histogram) Min() int64 {
+	return h.min
+}
+
+func (h *Histogram) Max() int64 {
+	return h.max
+}
+
+func (h *Histogram) Mean() float64 {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / float64(h.count)
+}
+
+// Quantile returns the value below which the fraction q of the recorded
+// values fall, q being between 0 and 1.
+func (h *Histogram) Quantile(q float64) int64 {
+	if h.count == 0 {
+		return 0
+	}
+	rank := int64(q*float64(h.count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			v := lowerBound(i)
+			if v < h.min {
+				return h.min
+			}
+			if v > h.max {
+				return h.max
+			}
+			return v
+		}
+	}
+	return h.max
+}
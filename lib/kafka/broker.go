@@ -0,0 +1,618 @@
+package kafka
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	brokerNodeID   int32 = 0
+	maxRequestSize       = 100 * 1024 * 1024
+)
+
+// Supported version ranges, advertised through the ApiVersions API
+var supportedVersions = []struct {
+	key, min, max int16
+}{
+	{apiProduce, 0, 3},
+	{apiFetch, 0, 4},
+	{apiListOffsets, 0, 1},
+	{apiMetadata, 0, 1},
+	{apiVersions, 0, 1},
+}
+
+// Broker is a minimal in-process stand-in for a single Kafka broker, so
+// benchmarks can run without a cluster.  Topics are created on first use
+// with a single partition and their records are kept in memory, up to a
+// retention limit.  Produce requests may be degraded on a schedule.
+type Broker struct {
+	listener       net.Listener
+	host           string
+	port           int32
+	retentionBytes int64
+
+	mu            sync.Mutex
+	topics        map[string]*partitionLog
+	conns         map[net.Conn]bool
+	closed        bool
+	schedule      []SinkWindow
+	scheduleStart time.Time
+	ingestFree    time.Time // When the ingest rate lets the next request through
+	rng           *rand.Rand
+
+	produceRequests int64
+	rejectedSets    int64
+	disconnects     int64
+	delayed         int64 // Nanoseconds
+}
+
+type storedEntry struct {
+	base, last int64
+	data       []byte
+}
+
+type partitionLog struct {
+	mu          sync.Mutex
+	entries     []storedEntry
+	startOffset int64
+	nextOffset  int64
+	size        int64
+	records     int64
+	bytes       int64
+	appended    chan bool
+}
+
+// NewBroker starts listening on addr.  Use port 0 to pick a free port.
+func NewBroker(addr string, retentionBytes int64) (*Broker, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	host, port, err := net.SplitHostPort(l.Addr().String())
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	p, _ := strconv.Atoi(port)
+	return &Broker{
+		listener:       l,
+		host:           host,
+		port:           int32(p),
+		retentionBytes: retentionBytes,
+		topics:         map[string]*partitionLog{},
+		conns:          map[net.Conn]bool{},
+		rng:            newSinkRand(),
+	}, nil
+}
+
+// Addr returns the HOST:PORT clients should connect to
+func (b *Broker) Addr() string {
+	return net.JoinHostPort(b.host, strconv.Itoa(int(b.port)))
+}
+
+// Serve accepts connections until the broker is closed
+func (b *Broker) Serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			b.mu.Lock()
+			closed := b.closed
+			b.mu.Unlock()
+			if !closed {
+				fmt.Printf("[ERROR] Embedded Kafka broker stopped accepting connections: %s\n", err)
+			}
+			return
+		}
+		b.mu.Lock()
+		b.conns[conn] = true
+		b.mu.Unlock()
+		go b.handle(conn)
+	}
+}
+
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	b.listener.Close()
+	for conn := range b.conns {
+		conn.Close()
+	}
+}
+
+// Received returns the number of records and record set bytes produced to topic
+func (b *Broker) Received(topic string) (records int64, bytes int64) {
+	b.mu.Lock()
+	log, ok := b.topics[topic]
+	b.mu.Unlock()
+	if !ok {
+		return 0, 0
+	}
+	return atomic.LoadInt64(&log.records), atomic.LoadInt64(&log.bytes)
+}
+
+func (b *Broker) topic(name string, create bool) *partitionLog {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	log, ok := b.topics[name]
+	if !ok && create {
+		log = &partitionLog{appended: make(chan bool)}
+		b.topics[name] = log
+	}
+	return log
+}
+
+func (b *Broker) handle(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		b.mu.Lock()
+		delete(b.conns, conn)
+		b.mu.Unlock()
+	}()
+
+	rd := bufio.NewReaderSize(conn, 64*1024)
+	var size [4]byte
+	for {
+		if _, err := io.ReadFull(rd, size[:]); err != nil {
+			return
+		}
+		n := binary.BigEndian.Uint32(size[:])
+		if n > maxRequestSize {
+			fmt.Printf("[ERROR] Embedded Kafka broker received an oversized request (%d bytes)\n", n)
+			return
+		}
+		req := make([]byte, n)
+		if _, err := io.ReadFull(rd, req); err != nil {
+			return
+		}
+
+		d := newDecoder(req)
+		apiKey := d.int16()
+		apiVersion := d.int16()
+		correlationID := d.int32()
+		d.nullableString() // client id
+		if d.err != nil {
+			return
+		}
+
+		resp := &encoder{buf: make([]byte, 8, 64)}
+		binary.BigEndian.PutUint32(resp.buf[4:], uint32(correlationID))
+
+		if apiKey == apiVersions {
+			b.handleApiVersions(apiVersion, resp)
+		} else {
+			if !isSupported(apiKey, apiVersion) {
+				fmt.Printf("[ERROR] Embedded Kafka broker doesn't support api key %d v%d\n", apiKey, apiVersion)
+				return
+			}
+			var reply bool
+			switch apiKey {
+			case apiMetadata:
+				reply = b.handleMetadata(apiVersion, d, resp)
+			case apiProduce:
+				atomic.AddInt64(&b.produceRequests, 1)
+				if w := b.SinkCondition(); w != nil && w.Outage {
+					// The broker is unreachable to producers
+					atomic.AddInt64(&b.disconnects, 1)
+					return
+				}
+				reply = b.handleProduce(apiVersion, d, resp)
+			case apiFetch:
+				reply = b.handleFetch(apiVersion, d, resp)
+			case apiListOffsets:
+				reply = b.handleListOffsets(apiVersion, d, resp)
+			}
+			if d.err != nil {
+				fmt.Printf("[ERROR] Embedded Kafka broker could not decode request (api key %d v%d): %s\n", apiKey, apiVersion, d.err)
+				return
+			}
+			if !reply {
+				continue
+			}
+		}
+
+		binary.BigEndian.PutUint32(resp.buf, uint32(len(resp.buf)-4))
+		if _, err := conn.Write(resp.buf); err != nil {
+			return
+		}
+	}
+}
+
+func isSupported(apiKey, apiVersion int16) bool {
+	for _, v := range supportedVersions {
+		if v.key == apiKey {
+			return apiVersion >= v.min && apiVersion <= v.max
+		}
+	}
+	return false
+}
+
+// handleApiVersions answers with the v0 layout when the requested version
+// is unknown, as clients rely on that to downgrade.
+func (b *Broker) handleApiVersions(version int16, resp *encoder) {
+	code := ErrNone
+	if !isSupported(apiVersions, version) {
+		code = ErrUnsupportedVersion
+		version = 0
+	}
+	resp.putInt16(int16(code))
+	resp.putArrayLen(len(supportedVersions))
+	for _, v := range supportedVersions {
+		resp.putInt16(v.key)
+		resp.putInt16(v.min)
+		resp.putInt16(v.max)
+	}
+	if version >= 1 {
+		resp.putInt32(0) // throttle time
+	}
+}
+
+func (b *Broker) handleMetadata(version int16, d *decoder, resp *encoder) bool {
+	var names []string
+	n := d.int32()
+	if n < 0 || (n == 0 && version == 0) {
+		// Every topic was requested
+		b.mu.Lock()
+		for name := range b.topics {
+			names = append(names, name)
+		}
+		b.mu.Unlock()
+	}
+	for i := int32(0); i < n && d.err == nil; i++ {
+		name := d.string()
+		b.topic(name, true)
+		names = append(names, name)
+	}
+
+	resp.putArrayLen(1)
+	resp.putInt32(brokerNodeID)
+	resp.putString(b.host)
+	resp.putInt32(b.port)
+	if version >= 1 {
+		resp.putNullableString(nil) // rack
+		resp.putInt32(brokerNodeID) // controller id
+	}
+	resp.putArrayLen(len(names))
+	for _, name := range names {
+		resp.putInt16(int16(ErrNone))
+		resp.putString(name)
+		if version >= 1 {
+			resp.putInt8(0) // is internal
+		}
+		resp.putArrayLen(1)
+		resp.putInt16(int16(ErrNone))
+		resp.putInt32(0) // partition
+		resp.putInt32(brokerNodeID)
+		resp.putArrayLen(1)
+		resp.putInt32(brokerNodeID) // replicas
+		resp.putArrayLen(1)
+		resp.putInt32(brokerNodeID) // isr
+	}
+	return true
+}
+
+type produceResult struct {
+	partition int32
+	err       Error
+	base      int64
+}
+
+type produceTopicResult struct {
+	topic      string
+	partitions []produceResult
+}
+
+func (b *Broker) handleProduce(version int16, d *decoder, resp *encoder) bool {
+	if version >= 3 {
+		d.nullableString() // transactional id
+	}
+	acks := d.int16()
+	d.int32() // timeout
+
+	type producedSet struct {
+		topic, index int
+		data         []byte
+	}
+	var results []produceTopicResult
+	var sets []producedSet
+	size := 0
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		t := produceTopicResult{topic: d.string()}
+		for j, np := 0, d.arrayLen(); j < np; j++ {
+			r := produceResult{partition: d.int32(), base: -1}
+			set := d.bytes()
+			if d.err != nil {
+				return false
+			}
+			if r.partition != 0 {
+				r.err = ErrUnknownTopicOrPartition
+			} else {
+				sets = append(sets, producedSet{topic: i, index: j, data: set})
+				size += len(set)
+			}
+			t.partitions = append(t.partitions, r)
+		}
+		results = append(results, t)
+	}
+
+	w := b.SinkCondition()
+	if w != nil {
+		if delay := b.sinkDelay(w, size); delay > 0 {
+			atomic.AddInt64(&b.delayed, int64(delay))
+			time.Sleep(delay)
+		}
+	}
+	for _, s := range sets {
+		r := &results[s.topic].partitions[s.index]
+		if w != nil && b.sinkRejects(w) {
+			// Retriable, and nothing was written
+			r.err = ErrNotEnoughReplicas
+			atomic.AddInt64(&b.rejectedSets, 1)
+			continue
+		}
+		r.base, r.err = b.topic(results[s.topic].topic, true).append(s.data, b.retentionBytes)
+	}
+
+	if acks == 0 {
+		return false
+	}
+
+	resp.putArrayLen(len(results))
+	for _, t := range results {
+		resp.putString(t.topic)
+		resp.putArrayLen(len(t.partitions))
+		for _, r := range t.partitions {
+			resp.putInt32(r.partition)
+			resp.putInt16(int16(r.err))
+			resp.putInt64(r.base)
+			if version >= 2 {
+				resp.putInt64(-1) // log append time
+			}
+		}
+	}
+	if version >= 1 {
+		resp.putInt32(0) // throttle time
+	}
+	return true
+}
+
+func (b *Broker) handleFetch(version int16, d *decoder, resp *encoder) bool {
+	d.int32() // replica id
+	maxWait := time.Duration(d.int32()) * time.Millisecond
+	d.int32() // min bytes, anything available is returned
+	if version >= 3 {
+		d.int32() // max bytes
+	}
+	if version >= 4 {
+		d.int8() // isolation level
+	}
+
+	type fetchPartition struct {
+		partition int32
+		offset    int64
+		maxBytes  int32
+	}
+	type fetchTopic struct {
+		name       string
+		partitions []fetchPartition
+	}
+	var topics []fetchTopic
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		t := fetchTopic{name: d.string()}
+		for j, np := 0, d.arrayLen(); j < np; j++ {
+			t.partitions = append(t.partitions, fetchPartition{partition: d.int32(), offset: d.int64(), maxBytes: d.int32()})
+		}
+		topics = append(topics, t)
+	}
+	if d.err != nil {
+		return false
+	}
+
+	// Wait for new data on the first requested partition which has none
+	deadline := time.Now().Add(maxWait)
+	for _, t := range topics {
+		log := b.topic(t.name, false)
+		for _, p := range t.partitions {
+			if log != nil && p.partition == 0 {
+				log.waitFor(p.offset, deadline)
+			}
+		}
+	}
+
+	if version >= 1 {
+		resp.putInt32(0) // throttle time
+	}
+	resp.putArrayLen(len(topics))
+	for _, t := range topics {
+		log := b.topic(t.name, false)
+		resp.putString(t.name)
+		resp.putArrayLen(len(t.partitions))
+		for _, p := range t.partitions {
+			resp.putInt32(p.partition)
+			var data []byte
+			hwm := int64(0)
+			code := ErrUnknownTopicOrPartition
+			if log != nil && p.partition == 0 {
+				data, hwm, code = log.read(p.offset, p.maxBytes)
+			}
+			resp.putInt16(int16(code))
+			resp.putInt64(hwm)
+			if version >= 4 {
+				resp.putInt64(hwm) // last stable offset
+				resp.putInt32(-1)  // aborted transactions
+			}
+			resp.putBytes(data)
+		}
+	}
+	return true
+}
+
+func (b *Broker) handleListOffsets(version int16, d *decoder, resp *encoder) bool {
+	d.int32() // replica id
+	type request struct {
+		partition int32
+		timestamp int64
+	}
+	type topicRequest struct {
+		name     string
+		requests []request
+	}
+	var topics []topicRequest
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		t := topicRequest{name: d.string()}
+		for j, np := 0, d.arrayLen(); j < np; j++ {
+			r := request{partition: d.int32(), timestamp: d.int64()}
+			if version == 0 {
+				d.int32() // max number of offsets
+			}
+			t.requests = append(t.requests, r)
+		}
+		topics = append(topics, t)
+	}
+	if d.err != nil {
+		return false
+	}
+
+	resp.putArrayLen(len(topics))
+	for _, t := range topics {
+		log := b.topic(t.name, false)
+		resp.putString(t.name)
+		resp.putArrayLen(len(t.requests))
+		for _, r := range t.requests {
+			resp.putInt32(r.partition)
+			if log == nil || r.partition != 0 {
+				resp.putInt16(int16(ErrUnknownTopicOrPartition))
+				if version == 0 {
+					resp.putArrayLen(0)
+				} else {
+					resp.putInt64(-1)
+					resp.putInt64(-1)
+				}
+				continue
+			}
+			// Records aren't indexed by time, so any timestamp other than
+			// latest resolves to the earliest offset still retained.
+			offset := log.offsetFor(r.timestamp)
+			resp.putInt16(int16(ErrNone))
+			if version == 0 {
+				resp.putArrayLen(1)
+				resp.putInt64(offset)
+			} else {
+				resp.putInt64(-1)
+				resp.putInt64(offset)
+			}
+		}
+	}
+	return true
+}
+
+// append assigns offsets to every entry of a produced record set and stores
+// it, returning the offset of the first record.
+func (l *partitionLog) append(set []byte, retentionBytes int64) (int64, Error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	base := l.nextOffset
+	for len(set) >= 12 {
+		size := int(binary.BigEndian.Uint32(set[8:12]))
+		if size < 0 || len(set) < 12+size {
+			return -1, ErrCorruptMessage
+		}
+		entry := set[:12+size]
+		set = set[12+size:]
+
+		records, offsets, err := countEntry(entry)
+		if err != nil && err != ErrUnsupportedCompression {
+			return -1, ErrCorruptMessage
+		}
+
+		// v2 batches carry their base offset, legacy wrappers the offset of
+		// their last inner message.
+		first := l.nextOffset
+		last := first + offsets - 1
+		if entry[magicOffset] >= 2 {
+			binary.BigEndian.PutUint64(entry, uint64(first))
+		} else {
+			binary.BigEndian.PutUint64(entry, uint64(last))
+		}
+		l.entries = append(l.entries, storedEntry{base: first, last: last, data: entry})
+		l.nextOffset = last + 1
+		l.size += int64(len(entry))
+		atomic.AddInt64(&l.records, int64(records))
+		atomic.AddInt64(&l.bytes, int64(len(entry)))
+	}
+
+	for l.size > retentionBytes && len(l.entries) > 1 {
+		l.size -= int64(len(l.entries[0].data))
+		l.entries[0] = storedEntry{}
+		l.entries = l.entries[1:]
+		l.startOffset = l.entries[0].base
+	}
+
+	close(l.appended)
+	l.appended = make(chan bool)
+	return base, ErrNone
+}
+
+// waitFor blocks until a record at offset or later is available, or until
+// the deadline is reached.
+func (l *partitionLog) waitFor(offset int64, deadline time.Time) {
+	for {
+		l.mu.Lock()
+		available := offset < l.nextOffset
+		appended := l.appended
+		l.mu.Unlock()
+
+		wait := time.Until(deadline)
+		if available || wait <= 0 {
+			return
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-appended:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// read returns the stored entries from offset on, up to maxBytes but always
+// at least one entry so large batches can't stall consumers.
+func (l *partitionLog) read(offset int64, maxBytes int32) ([]byte, int64, Error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if offset < l.startOffset || offset > l.nextOffset {
+		return nil, l.nextOffset, ErrOffsetOutOfRange
+	}
+	var data []byte
+	first := sort.Search(len(l.entries), func(i int) bool { return l.entries[i].last >= offset })
+	for _, e := range l.entries[first:] {
+		if len(data) > 0 && len(data)+len(e.data) > int(maxBytes) {
+			break
+		}
+		data = append(data, e.data...)
+	}
+	return data, l.nextOffset, ErrNone
+}
+
+func (l *partitionLog) offsetFor(timestamp int64) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if timestamp == OffsetLatest {
+		return l.nextOffset
+	}
+	return l.startOffset
+}
@@ -0,0 +1,224 @@
+package kafka
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const clientID = "logshipper-benchmark"
+
+// Conn is a connection to a single Kafka broker
+type Conn struct {
+	mu            sync.Mutex
+	conn          net.Conn
+	rd            *bufio.Reader
+	correlationID int32
+	timeout       time.Duration
+}
+
+// PartitionMetadata describes a single partition of a topic
+type PartitionMetadata struct {
+	ID     int32
+	Leader int32
+	Err    Error
+}
+
+// TopicMetadata describes a topic and its partitions
+type TopicMetadata struct {
+	Name       string
+	Err        Error
+	Partitions []PartitionMetadata
+}
+
+// Metadata is the cluster layout as returned by the Metadata API
+type Metadata struct {
+	Brokers map[int32]string
+	Topics  []TopicMetadata
+}
+
+// FetchPartition is the position to fetch from for a single partition
+type FetchPartition struct {
+	Partition int32
+	Offset    int64
+	MaxBytes  int32
+}
+
+// FetchResult is the data returned for a single partition
+type FetchResult struct {
+	Partition     int32
+	Err           Error
+	HighWatermark int64
+	Records       []byte
+}
+
+func Dial(addr string, timeout time.Duration) (*Conn, error) {
+	c, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{conn: c, rd: bufio.NewReaderSize(c, 64*1024), timeout: timeout}, nil
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// roundTrip sends a request and returns a decoder positioned on the body of
+// the matching response.  wait is added to the I/O deadline for requests
+// the broker is allowed to hold on to, such as fetches.
+func (c *Conn) roundTrip(apiKey, apiVersion int16, body []byte, wait time.Duration) (*decoder, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.correlationID++
+	id := c.correlationID
+	cid := clientID
+
+	req := &encoder{buf: make([]byte, 4, 64+len(body))}
+	req.putInt16(apiKey)
+	req.putInt16(apiVersion)
+	req.putInt32(id)
+	req.putNullableString(&cid)
+	req.buf = append(req.buf, body...)
+	binary.BigEndian.PutUint32(req.buf, uint32(len(req.buf)-4))
+
+	c.conn.SetDeadline(time.Now().Add(c.timeout + wait))
+	if _, err := c.conn.Write(req.buf); err != nil {
+		return nil, err
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(c.rd, size[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(c.rd, resp); err != nil {
+		return nil, err
+	}
+	d := newDecoder(resp)
+	if got := d.int32(); got != id {
+		return nil, fmt.Errorf("kafka: correlation id mismatch (got %d, expected %d)", got, id)
+	}
+	return d, nil
+}
+
+// Metadata requests (v1) the layout of the given topics
+func (c *Conn) Metadata(topics []string) (*Metadata, error) {
+	req := &encoder{}
+	req.putArrayLen(len(topics))
+	for _, t := range topics {
+		req.putString(t)
+	}
+	d, err := c.roundTrip(apiMetadata, 1, req.buf, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	md := &Metadata{Brokers: map[int32]string{}}
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.nullableString() // rack
+		md.Brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.int32() // controller id
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		t := TopicMetadata{Err: Error(d.int16()), Name: d.string()}
+		d.int8() // is internal
+		for j, np := 0, d.arrayLen(); j < np; j++ {
+			p := PartitionMetadata{Err: Error(d.int16()), ID: d.int32(), Leader: d.int32()}
+			for k, nr := 0, d.arrayLen(); k < nr; k++ {
+				d.int32() // replicas
+			}
+			for k, ni := 0, d.arrayLen(); k < ni; k++ {
+				d.int32() // isr
+			}
+			t.Partitions = append(t.Partitions, p)
+		}
+		md.Topics = append(md.Topics, t)
+	}
+	return md, d.err
+}
+
+// ListOffsets requests (v1) the offset matching timestamp for each of the
+// given partitions, which can also be OffsetLatest or OffsetEarliest.
+func (c *Conn) ListOffsets(topic string, partitions []int32, timestamp int64) (map[int32]int64, error) {
+	req := &encoder{}
+	req.putInt32(-1) // replica id
+	req.putArrayLen(1)
+	req.putString(topic)
+	req.putArrayLen(len(partitions))
+	for _, p := range partitions {
+		req.putInt32(p)
+		req.putInt64(timestamp)
+	}
+	d, err := c.roundTrip(apiListOffsets, 1, req.buf, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := map[int32]int64{}
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		d.string() // topic
+		for j, np := 0, d.arrayLen(); j < np; j++ {
+			p := d.int32()
+			code := Error(d.int16())
+			d.int64() // timestamp
+			offset := d.int64()
+			if code != ErrNone {
+				return nil, code
+			}
+			offsets[p] = offset
+		}
+	}
+	return offsets, d.err
+}
+
+// Fetch requests (v4) records from the given partitions, waiting up to
+// maxWait for at least one byte to be available.
+func (c *Conn) Fetch(topic string, partitions []FetchPartition, maxWait time.Duration) ([]FetchResult, error) {
+	req := &encoder{}
+	req.putInt32(-1) // replica id
+	req.putInt32(int32(maxWait / time.Millisecond))
+	req.putInt32(1)       // min bytes
+	req.putInt32(1 << 26) // max bytes
+	req.putInt8(0)        // isolation level: read uncommitted
+	req.putArrayLen(1)
+	req.putString(topic)
+	req.putArrayLen(len(partitions))
+	for _, p := range partitions {
+		req.putInt32(p.Partition)
+		req.putInt64(p.Offset)
+		req.putInt32(p.MaxBytes)
+	}
+	d, err := c.roundTrip(apiFetch, 4, req.buf, maxWait)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []FetchResult
+	d.int32() // throttle time
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		d.string() // topic
+		for j, np := 0, d.arrayLen(); j < np; j++ {
+			r := FetchResult{Partition: d.int32(), Err: Error(d.int16()), HighWatermark: d.int64()}
+			d.int64() // last stable offset
+			if na := d.int32(); na > 0 {
+				for k := 0; k < int(na); k++ {
+					d.int64() // producer id
+					d.int64() // first offset
+				}
+			}
+			r.Records = d.bytes()
+			results = append(results, r)
+		}
+	}
+	return results, d.err
+}
@@ -0,0 +1,236 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	dialTimeout        = 5 * time.Second
+	partitionFetchSize = 4 * 1024 * 1024
+)
+
+var errNoBrokers = errors.New("kafka: none of the brokers could be reached")
+
+// Consumer reads every partition of a single topic, without a consumer group.
+// It isn't safe for concurrent use.
+type Consumer struct {
+	brokers    []string
+	topic      string
+	fromLatest bool
+	refreshed  bool
+
+	conns   map[int32]*Conn
+	addrs   map[int32]string
+	leaders map[int32]int32
+	offsets map[int32]int64
+	hwm     map[int32]int64
+
+	decodeErrors int64
+}
+
+// NewConsumer creates a consumer for topic.  When fromLatest is set, the
+// partitions which already exist on the first call to Refresh are read from
+// their end, so only records produced from then on are seen.  Partitions
+// that show up later are always read from the start.
+func NewConsumer(brokers []string, topic string, fromLatest bool) *Consumer {
+	return &Consumer{
+		brokers:    brokers,
+		topic:      topic,
+		fromLatest: fromLatest,
+		conns:      map[int32]*Conn{},
+		addrs:      map[int32]string{},
+		leaders:    map[int32]int32{},
+		offsets:    map[int32]int64{},
+		hwm:        map[int32]int64{},
+	}
+}
+
+// Refresh reloads the topic metadata and positions any new partition.  The
+// topic not existing yet isn't an error.
+func (c *Consumer) Refresh() error {
+	md, err := c.metadata()
+	if err != nil {
+		return err
+	}
+	c.addrs = md.Brokers
+
+	firstRefresh := !c.refreshed
+	c.refreshed = true
+	for _, t := range md.Topics {
+		if t.Name != c.topic {
+			continue
+		}
+		if t.Err != ErrNone && t.Err != ErrLeaderNotAvailable && t.Err != ErrUnknownTopicOrPartition {
+			return t.Err
+		}
+		for _, p := range t.Partitions {
+			if p.Err != ErrNone || p.Leader < 0 {
+				delete(c.leaders, p.ID)
+				continue
+			}
+			if _, ok := c.offsets[p.ID]; ok {
+				c.leaders[p.ID] = p.Leader
+				continue
+			}
+			position := OffsetEarliest
+			if firstRefresh && c.fromLatest {
+				position = OffsetLatest
+			}
+			offset, err := c.listOffset(p.ID, p.Leader, position)
+			if err != nil {
+				return err
+			}
+			c.offsets[p.ID] = offset
+			c.leaders[p.ID] = p.Leader
+		}
+	}
+	return nil
+}
+
+// Poll fetches from every partition leader once, calling fn for each new
+// record, and returns the number of records seen.  The error is the last one
+// met, whether reaching the brokers or decoding what they returned.
+func (c *Consumer) Poll(maxWait time.Duration, fn func(*Record)) (int, error) {
+	if len(c.leaders) == 0 {
+		if err := c.Refresh(); err != nil {
+			return 0, err
+		}
+		if len(c.leaders) == 0 {
+			time.Sleep(maxWait)
+			return 0, nil
+		}
+	}
+
+	byLeader := map[int32][]FetchPartition{}
+	for p, leader := range c.leaders {
+		byLeader[leader] = append(byLeader[leader], FetchPartition{Partition: p, Offset: c.offsets[p], MaxBytes: partitionFetchSize})
+	}
+
+	total := 0
+	var pollErr error
+	for leader, partitions := range byLeader {
+		conn, err := c.conn(leader)
+		if err != nil {
+			pollErr = err
+			c.leaders = map[int32]int32{}
+			continue
+		}
+		results, err := conn.Fetch(c.topic, partitions, maxWait)
+		if err != nil {
+			pollErr = err
+			c.dropConn(leader)
+			continue
+		}
+		for _, r := range results {
+			switch r.Err {
+			case ErrNone:
+			case ErrOffsetOutOfRange:
+				// Data was removed by retention before it could be read
+				offset, err := c.listOffset(r.Partition, leader, OffsetEarliest)
+				if err == nil {
+					c.offsets[r.Partition] = offset
+				}
+				continue
+			default:
+				pollErr = r.Err
+				delete(c.leaders, r.Partition)
+				continue
+			}
+			c.hwm[r.Partition] = r.HighWatermark
+			offset := c.offsets[r.Partition]
+			next, err := ReadRecords(r.Records, func(rec *Record) {
+				if rec.Offset < offset {
+					return
+				}
+				total++
+				fn(rec)
+			})
+			if err != nil {
+				c.decodeErrors++
+				pollErr = err
+			}
+			if next > offset {
+				c.offsets[r.Partition] = next
+			}
+		}
+	}
+	return total, pollErr
+}
+
+// DecodeErrors returns the number of record sets fetched so far which
+// couldn't be entirely decoded, such as when compressed with an unsupported
+// codec
+func (c *Consumer) DecodeErrors() int64 {
+	return c.decodeErrors
+}
+
+// Lag returns how many records are known to be left to read, based on the
+// high watermarks returned by the last poll.
+func (c *Consumer) Lag() int64 {
+	var lag int64
+	for p, hwm := range c.hwm {
+		if d := hwm - c.offsets[p]; d > 0 {
+			lag += d
+		}
+	}
+	return lag
+}
+
+func (c *Consumer) Close() {
+	for id := range c.conns {
+		c.dropConn(id)
+	}
+}
+
+func (c *Consumer) metadata() (*Metadata, error) {
+	for _, addr := range c.brokers {
+		conn, err := Dial(addr, dialTimeout)
+		if err != nil {
+			continue
+		}
+		md, err := conn.Metadata([]string{c.topic})
+		conn.Close()
+		if err == nil {
+			return md, nil
+		}
+	}
+	return nil, errNoBrokers
+}
+
+func (c *Consumer) listOffset(partition, leader int32, position int64) (int64, error) {
+	conn, err := c.conn(leader)
+	if err != nil {
+		return 0, err
+	}
+	offsets, err := conn.ListOffsets(c.topic, []int32{partition}, position)
+	if err != nil {
+		c.dropConn(leader)
+		return 0, err
+	}
+	return offsets[partition], nil
+}
+
+func (c *Consumer) conn(id int32) (*Conn, error) {
+	if conn, ok := c.conns[id]; ok {
+		return conn, nil
+	}
+	addr, ok := c.addrs[id]
+	if !ok {
+		return nil, fmt.Errorf("kafka: unknown broker id %d", id)
+	}
+	conn, err := Dial(addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c.conns[id] = conn
+	return conn, nil
+}
+
+func (c *Consumer) dropConn(id int32) {
+	if conn, ok := c.conns[id]; ok {
+		conn.Close()
+		delete(c.conns, id)
+	}
+}
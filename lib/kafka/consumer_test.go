@@ -0,0 +1,48 @@
+package kafka
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConsumerDecodeErrors(t *testing.T) {
+	b, err := NewBroker("127.0.0.1:0", 1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go b.Serve()
+	defer b.Close()
+	log := b.topic("lines", true)
+	log.append(batchEntry(0, compressionNone, 0, "a", "b"), b.retentionBytes)
+	log.append(batchEntry(0, compressionSnappy, 0, "c"), b.retentionBytes)
+
+	c := NewConsumer([]string{b.Addr()}, "lines", false)
+	defer c.Close()
+	seen := 0
+	for deadline := time.Now().Add(5 * time.Second); seen < 3 && time.Now().Before(deadline); {
+		n, _ := c.Poll(100*time.Millisecond, func(*Record) {})
+		seen += n
+	}
+	if seen != 3 || c.DecodeErrors() != 1 {
+		t.Errorf("Poll() saw %d records with %d decode errors, want 3 records with 1 decode error", seen, c.DecodeErrors())
+	}
+}
+
+func TestConsumerUnreachable(t *testing.T) {
+	// A port nothing listens on once the listener is closed
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	c := NewConsumer([]string{addr}, "lines", false)
+	if _, err := c.Poll(10*time.Millisecond, func(*Record) {}); err == nil {
+		t.Error("Poll() of an unreachable broker didn't fail")
+	}
+	if c.DecodeErrors() != 0 {
+		t.Errorf("DecodeErrors() = %d after failing to reach the broker, want 0", c.DecodeErrors())
+	}
+}
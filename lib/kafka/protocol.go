@@ -0,0 +1,230 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Only the handful of APIs needed to consume a topic and to stand in for a
+// broker are implemented.  Protocol reference:
+//	https://kafka.apache.org/protocol.html
+
+const (
+	apiProduce     int16 = 0
+	apiFetch       int16 = 1
+	apiListOffsets int16 = 2
+	apiMetadata    int16 = 3
+	apiVersions    int16 = 18
+)
+
+// Special timestamps accepted by the ListOffsets API
+const (
+	OffsetLatest   int64 = -1
+	OffsetEarliest int64 = -2
+)
+
+// Error is a Kafka protocol error code
+type Error int16
+
+const (
+	ErrNone                    Error = 0
+	ErrOffsetOutOfRange        Error = 1
+	ErrCorruptMessage          Error = 2
+	ErrUnknownTopicOrPartition Error = 3
+	ErrLeaderNotAvailable      Error = 5
+	ErrNotLeaderForPartition   Error = 6
+	ErrRequestTimedOut         Error = 7
+	ErrNotEnoughReplicas       Error = 19
+	ErrUnsupportedVersion      Error = 35
+)
+
+var errorNames = map[Error]string{
+	ErrNone:                    "no error",
+	ErrOffsetOutOfRange:        "offset out of range",
+	ErrCorruptMessage:          "corrupt message",
+	ErrUnknownTopicOrPartition: "unknown topic or partition",
+	ErrLeaderNotAvailable:      "leader not available",
+	ErrNotLeaderForPartition:   "not leader for partition",
+	ErrRequestTimedOut:         "request timed out",
+	ErrNotEnoughReplicas:       "not enough replicas",
+	ErrUnsupportedVersion:      "unsupported version",
+}
+
+func (e Error) Error() string {
+	if name, ok := errorNames[e]; ok {
+		return fmt.Sprintf("kafka: %s (%d)", name, int16(e))
+	}
+	return fmt.Sprintf("kafka: error code %d", int16(e))
+}
+
+var errShortBuffer = errors.New("kafka: short buffer")
+
+// encoder appends big-endian protocol primitives to a byte slice
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) putInt8(v int8) {
+	e.buf = append(e.buf, byte(v))
+}
+
+func (e *encoder) putInt16(v int16) {
+	e.buf = append(e.buf, byte(v>>8), byte(v))
+}
+
+func (e *encoder) putInt32(v int32) {
+	e.buf = append(e.buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (e *encoder) putInt64(v int64) {
+	e.putInt32(int32(v >> 32))
+	e.putInt32(int32(v))
+}
+
+func (e *encoder) putString(s string) {
+	e.putInt16(int16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) putNullableString(s *string) {
+	if s == nil {
+		e.putInt16(-1)
+		return
+	}
+	e.putString(*s)
+}
+
+func (e *encoder) putBytes(b []byte) {
+	if b == nil {
+		e.putInt32(-1)
+		return
+	}
+	e.putInt32(int32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) putArrayLen(n int) {
+	e.putInt32(int32(n))
+}
+
+// decoder reads big-endian protocol primitives.  The first error is sticky,
+// so callers only need to check err once they are done decoding.
+type decoder struct {
+	buf []byte
+	off int
+	err error
+}
+
+func newDecoder(buf []byte) *decoder {
+	return &decoder{buf: buf}
+}
+
+func (d *decoder) remaining() int {
+	return len(d.buf) - d.off
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || d.remaining() < n {
+		d.err = errShortBuffer
+		return nil
+	}
+	b := d.buf[d.off : d.off+n]
+	d.off += n
+	return b
+}
+
+func (d *decoder) int8() int8 {
+	b := d.next(1)
+	if b == nil {
+		return 0
+	}
+	return int8(b[0])
+}
+
+func (d *decoder) int16() int16 {
+	b := d.next(2)
+	if b == nil {
+		return 0
+	}
+	return int16(binary.BigEndian.Uint16(b))
+}
+
+func (d *decoder) int32() int32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (d *decoder) int64() int64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func (d *decoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+func (d *decoder) nullableString() *string {
+	n := d.int16()
+	if n < 0 || d.err != nil {
+		return nil
+	}
+	s := string(d.next(int(n)))
+	return &s
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
+
+func (d *decoder) arrayLen() int {
+	n := d.int32()
+	if n < 0 {
+		return 0
+	}
+	// Every array element is at least one byte long, which guards against
+	// allocating huge slices for garbage lengths.
+	if int(n) > d.remaining() {
+		d.err = errShortBuffer
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf[d.off:])
+	if n <= 0 {
+		d.err = errShortBuffer
+		return 0
+	}
+	d.off += n
+	return v
+}
+
+func (d *decoder) varintBytes() []byte {
+	n := d.varint()
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
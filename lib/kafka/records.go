@@ -0,0 +1,258 @@
+package kafka
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"time"
+)
+
+const (
+	compressionMask = 0x07
+	compressionNone = 0
+	compressionGzip = 1
+
+	// Byte position of the magic byte, which is the same for legacy
+	// messages (offset, size, crc) and v2 record batches (base offset,
+	// length, partition leader epoch).
+	magicOffset = 16
+
+	// Size of a v2 record batch header up to and including the record count
+	batchHeaderSize = 61
+
+	controlBatchFlag = 0x20
+)
+
+// ErrUnsupportedCompression is returned when a record set uses a codec other
+// than gzip.  The records are still counted whenever the format allows it.
+var ErrUnsupportedCompression = errors.New("kafka: unsupported compression codec")
+
+// Record is a single message read from a partition.  Value is nil for
+// records whose payload could not be decompressed.
+type Record struct {
+	Offset    int64
+	Timestamp time.Time
+	Key       []byte
+	Value     []byte
+}
+
+// ReadRecords decodes a record set as returned by a fetch, which may contain
+// both legacy message sets (magic 0 and 1) and v2 record batches.  fn is
+// called for every record, and the offset following the last complete entry
+// is returned, or -1 when the set doesn't hold a single complete entry.
+func ReadRecords(set []byte, fn func(*Record)) (int64, error) {
+	next := int64(-1)
+	var firstErr error
+	for len(set) >= magicOffset+1 {
+		d := newDecoder(set)
+		baseOffset := d.int64()
+		size := int(d.int32())
+		if size < 0 || len(set) < 12+size {
+			// Fetches are allowed to end with a partial entry
+			break
+		}
+		entry := set[:12+size]
+		set = set[12+size:]
+
+		var last int64
+		var err error
+		if entry[magicOffset] >= 2 {
+			last, err = readBatch(entry, fn)
+		} else {
+			last, err = readMessage(baseOffset, entry[12:], fn)
+		}
+		if err == errShortBuffer {
+			err = ErrCorruptMessage
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		next = last + 1
+	}
+	return next, firstErr
+}
+
+// readMessage decodes a single legacy message, unwrapping it when compressed
+func readMessage(offset int64, msg []byte, fn func(*Record)) (int64, error) {
+	d := newDecoder(msg)
+	d.int32() // crc
+	magic := d.int8()
+	attributes := d.int8()
+	var ts time.Time
+	if magic == 1 {
+		if ms := d.int64(); ms > 0 {
+			ts = time.Unix(0, ms*int64(time.Millisecond))
+		}
+	}
+	key := d.bytes()
+	value := d.bytes()
+	if d.err != nil {
+		return offset, d.err
+	}
+
+	switch attributes & compressionMask {
+	case compressionNone:
+		fn(&Record{Offset: offset, Timestamp: ts, Key: key, Value: value})
+		return offset, nil
+	case compressionGzip:
+		inner, err := gunzip(value)
+		if err != nil {
+			return offset, err
+		}
+		// The wrapper carries the offset of the last inner message.  For
+		// magic 1 the inner offsets are relative to the first one, for magic
+		// 0 they are already absolute and the difference is zero.
+		var records []*Record
+		if _, err := ReadRecords(inner, func(r *Record) { records = append(records, r) }); err != nil {
+			return offset, err
+		}
+		if len(records) == 0 {
+			return offset, nil
+		}
+		base := offset - records[len(records)-1].Offset
+		for _, r := range records {
+			r.Offset += base
+			if r.Timestamp.IsZero() {
+				r.Timestamp = ts
+			}
+			fn(r)
+		}
+		return offset, nil
+	default:
+		// The number of wrapped messages is unknown, so it counts as one
+		fn(&Record{Offset: offset, Timestamp: ts})
+		return offset, ErrUnsupportedCompression
+	}
+}
+
+// readBatch decodes a v2 record batch
+func readBatch(batch []byte, fn func(*Record)) (int64, error) {
+	d := newDecoder(batch)
+	baseOffset := d.int64()
+	d.int32() // batch length
+	d.int32() // partition leader epoch
+	d.int8()  // magic
+	d.int32() // crc
+	attributes := d.int16()
+	lastOffsetDelta := d.int32()
+	firstTimestamp := d.int64()
+	d.int64() // max timestamp
+	d.int64() // producer id
+	d.int16() // producer epoch
+	d.int32() // base sequence
+	count := int(d.int32())
+	if d.err != nil {
+		return baseOffset, d.err
+	}
+	last := baseOffset + int64(lastOffsetDelta)
+
+	if attributes&controlBatchFlag != 0 {
+		return last, nil
+	}
+
+	body := batch[batchHeaderSize:]
+	switch attributes & compressionMask {
+	case compressionNone:
+	case compressionGzip:
+		var err error
+		if body, err = gunzip(body); err != nil {
+			return last, err
+		}
+	default:
+		for i := 0; i < count; i++ {
+			fn(&Record{Offset: baseOffset + int64(i)})
+		}
+		return last, ErrUnsupportedCompression
+	}
+
+	rd := newDecoder(body)
+	for i := 0; i < count && rd.err == nil; i++ {
+		length := rd.varint()
+		rec := newDecoder(rd.next(int(length)))
+		if rd.err != nil {
+			break
+		}
+		rec.int8() // attributes
+		tsDelta := rec.varint()
+		offsetDelta := rec.varint()
+		key := rec.varintBytes()
+		value := rec.varintBytes()
+		// Headers are ignored
+		if rec.err != nil {
+			return last, rec.err
+		}
+		fn(&Record{
+			Offset:    baseOffset + offsetDelta,
+			Timestamp: time.Unix(0, (firstTimestamp+tsDelta)*int64(time.Millisecond)),
+			Key:       key,
+			Value:     value,
+		})
+	}
+	return last, rd.err
+}
+
+func gunzip(b []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return ioutil.ReadAll(zr)
+}
+
+// countEntry returns the number of records held by a single record set
+// entry, along with how many offsets it spans.  Legacy messages compressed
+// with a codec other than gzip count as a single record.
+func countEntry(entry []byte) (records int, offsets int64, err error) {
+	if len(entry) < magicOffset+1 {
+		return 0, 0, ErrCorruptMessage
+	}
+	if entry[magicOffset] >= 2 {
+		d := newDecoder(entry)
+		d.next(21) // base offset, length, leader epoch, magic, crc
+		attributes := d.int16()
+		lastOffsetDelta := d.int32()
+		d.next(30) // timestamps, producer id and epoch, base sequence
+		count := d.int32()
+		if d.err != nil {
+			return 0, 0, ErrCorruptMessage
+		}
+		if attributes&controlBatchFlag != 0 {
+			count = 0
+		}
+		return int(count), int64(lastOffsetDelta) + 1, nil
+	}
+
+	d := newDecoder(entry[12:])
+	d.int32() // crc
+	magic := d.int8()
+	attributes := d.int8()
+	if magic == 1 {
+		d.int64() // timestamp
+	}
+	d.bytes() // key
+	value := d.bytes()
+	if d.err != nil {
+		return 0, 0, ErrCorruptMessage
+	}
+	switch attributes & compressionMask {
+	case compressionNone:
+		return 1, 1, nil
+	case compressionGzip:
+		inner, err := gunzip(value)
+		if err != nil {
+			return 0, 0, err
+		}
+		n := 0
+		if _, err := ReadRecords(inner, func(*Record) { n++ }); err != nil {
+			return 0, 0, err
+		}
+		if n == 0 {
+			return 0, 1, nil
+		}
+		return n, int64(n), nil
+	default:
+		return 1, 1, ErrUnsupportedCompression
+	}
+}
@@ -0,0 +1,191 @@
+package kafka
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const compressionSnappy = 2
+
+func gzipped(b []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(b)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// legacyEntry builds a message set entry of magic 0 or 1.  The crc isn't
+// checked when reading, so it's left at zero.
+func legacyEntry(offset int64, magic int8, attributes int8, timestampMs int64, value []byte) []byte {
+	msg := &encoder{}
+	msg.putInt32(0) // crc
+	msg.putInt8(magic)
+	msg.putInt8(attributes)
+	if magic == 1 {
+		msg.putInt64(timestampMs)
+	}
+	msg.putBytes(nil) // key
+	msg.putBytes(value)
+	e := &encoder{}
+	e.putInt64(offset)
+	e.putInt32(int32(len(msg.buf)))
+	return append(e.buf, msg.buf...)
+}
+
+// batchEntry builds a v2 record batch, with a record per value whose
+// timestamp and offset deltas are its index
+func batchEntry(baseOffset int64, attributes int16, firstTimestampMs int64, values ...string) []byte {
+	var body []byte
+	for i, v := range values {
+		rec := []byte{0}                         // attributes
+		rec = binary.AppendVarint(rec, int64(i)) // timestamp delta
+		rec = binary.AppendVarint(rec, int64(i)) // offset delta
+		rec = binary.AppendVarint(rec, -1)       // key
+		rec = binary.AppendVarint(rec, int64(len(v)))
+		rec = append(rec, v...)
+		rec = binary.AppendVarint(rec, 0) // headers
+		body = binary.AppendVarint(body, int64(len(rec)))
+		body = append(body, rec...)
+	}
+	if attributes&compressionMask == compressionGzip {
+		body = gzipped(body)
+	}
+	e := &encoder{}
+	e.putInt64(baseOffset)
+	e.putInt32(int32(batchHeaderSize - 12 + len(body)))
+	e.putInt32(0) // partition leader epoch
+	e.putInt8(2)
+	e.putInt32(0) // crc
+	e.putInt16(attributes)
+	e.putInt32(int32(len(values) - 1))
+	e.putInt64(firstTimestampMs)
+	e.putInt64(firstTimestampMs + int64(len(values)) - 1)
+	e.putInt64(-1) // producer id
+	e.putInt16(-1) // producer epoch
+	e.putInt32(-1) // base sequence
+	e.putInt32(int32(len(values)))
+	return append(e.buf, body...)
+}
+
+func concat(entries ...[]byte) []byte {
+	return bytes.Join(entries, nil)
+}
+
+type readRecord struct {
+	Offset      int64
+	TimestampMs int64
+	Value       string
+}
+
+func TestReadRecords(t *testing.T) {
+	innerV1 := concat(
+		legacyEntry(0, 1, compressionNone, 1000, []byte("a")),
+		legacyEntry(1, 1, compressionNone, 1001, []byte("b")),
+		legacyEntry(2, 1, compressionNone, 0, []byte("c")),
+	)
+	tests := []struct {
+		name    string
+		set     []byte
+		want    []readRecord
+		next    int64
+		wantErr error
+	}{
+		{"empty", nil, nil, -1, nil},
+		{"magic 0", legacyEntry(5, 0, compressionNone, 0, []byte("a")), []readRecord{{5, 0, "a"}}, 6, nil},
+		{"magic 1", legacyEntry(5, 1, compressionNone, 1500, []byte("a")), []readRecord{{5, 1500, "a"}}, 6, nil},
+		{
+			// Inner offsets are relative, the wrapper has the last one
+			"gzip wrapper",
+			legacyEntry(12, 1, compressionGzip, 2000, gzipped(innerV1)),
+			[]readRecord{{10, 1000, "a"}, {11, 1001, "b"}, {12, 2000, "c"}},
+			13, nil,
+		},
+		{
+			"batch",
+			batchEntry(100, compressionNone, 5000, "a", "b", "c"),
+			[]readRecord{{100, 5000, "a"}, {101, 5001, "b"}, {102, 5002, "c"}},
+			103, nil,
+		},
+		{
+			"gzip batch",
+			batchEntry(100, compressionGzip, 5000, "a", "b"),
+			[]readRecord{{100, 5000, "a"}, {101, 5001, "b"}},
+			102, nil,
+		},
+		{"control batch", batchEntry(7, controlBatchFlag, 0, "commit"), nil, 8, nil},
+		{
+			"legacy then batch",
+			concat(legacyEntry(0, 0, compressionNone, 0, []byte("a")), batchEntry(1, compressionNone, 0, "b")),
+			[]readRecord{{0, 0, "a"}, {1, 0, "b"}},
+			2, nil,
+		},
+		{
+			// Fetches may end with a partial entry
+			"partial entry",
+			concat(batchEntry(0, compressionNone, 0, "a"), batchEntry(1, compressionNone, 0, "b")[:40]),
+			[]readRecord{{0, 0, "a"}},
+			1, nil,
+		},
+		{
+			// Records are counted even when they can't be read
+			"unsupported batch compression",
+			batchEntry(20, compressionSnappy, 0, "a", "b"),
+			[]readRecord{{20, 0, ""}, {21, 0, ""}},
+			22, ErrUnsupportedCompression,
+		},
+	}
+	for _, tt := range tests {
+		var got []readRecord
+		next, err := ReadRecords(tt.set, func(r *Record) {
+			var ms int64
+			if !r.Timestamp.IsZero() {
+				ms = r.Timestamp.UnixNano() / int64(time.Millisecond)
+			}
+			got = append(got, readRecord{r.Offset, ms, string(r.Value)})
+		})
+		if err != tt.wantErr || next != tt.next || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: ReadRecords() = %v, %d, %v, want %v, %d, %v", tt.name, got, next, err, tt.want, tt.next, tt.wantErr)
+		}
+	}
+}
+
+func TestReadRecordsCorrupt(t *testing.T) {
+	entry := batchEntry(0, compressionNone, 0, "a", "b")
+	// The first record claims to be longer than the batch
+	entry[batchHeaderSize] = 0x7e
+	if _, err := ReadRecords(entry, func(*Record) {}); err != ErrCorruptMessage {
+		t.Errorf("ReadRecords() of a corrupt batch = %v, want %v", err, ErrCorruptMessage)
+	}
+}
+
+func TestCountEntry(t *testing.T) {
+	inner := concat(
+		legacyEntry(0, 1, compressionNone, 0, []byte("a")),
+		legacyEntry(1, 1, compressionNone, 0, []byte("b")),
+	)
+	tests := []struct {
+		name    string
+		entry   []byte
+		records int
+		offsets int64
+		wantErr error
+	}{
+		{"magic 0", legacyEntry(0, 0, compressionNone, 0, []byte("a")), 1, 1, nil},
+		{"gzip wrapper", legacyEntry(0, 1, compressionGzip, 0, gzipped(inner)), 2, 2, nil},
+		{"unsupported wrapper", legacyEntry(0, 1, compressionSnappy, 0, []byte("?")), 1, 1, ErrUnsupportedCompression},
+		{"batch", batchEntry(0, compressionNone, 0, "a", "b", "c"), 3, 3, nil},
+		{"control batch", batchEntry(0, controlBatchFlag, 0, "commit"), 0, 1, nil},
+		{"too short", []byte{0, 1, 2}, 0, 0, ErrCorruptMessage},
+	}
+	for _, tt := range tests {
+		records, offsets, err := countEntry(tt.entry)
+		if records != tt.records || offsets != tt.offsets || err != tt.wantErr {
+			t.Errorf("%s: countEntry() = %d, %d, %v, want %d, %d, %v", tt.name, records, offsets, err, tt.records, tt.offsets, tt.wantErr)
+		}
+	}
+}
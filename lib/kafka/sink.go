@@ -0,0 +1,141 @@
+package kafka
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Sink states, as reported by SinkWindow.State
+const (
+	SinkHealthy  = "healthy"
+	SinkDegraded = "degraded"
+	SinkOutage   = "outage"
+)
+
+// SinkWindow degrades the broker for a while, so shippers can be seen
+// buffering and retrying while their output is slow or failing.  Times are
+// in seconds from when the schedule was set.  Only produce requests are
+// affected, so the records delivered can still be fetched.
+type SinkWindow struct {
+	StartSeconds   int     `json:"start_seconds"`
+	EndSeconds     int     `json:"end_seconds"`
+	MaxMbPerSecond float64 `json:"max_mb_per_second"`
+	LatencyMs      int     `json:"latency_ms"`
+	ErrorRate      float64 `json:"error_rate"`
+	Outage         bool    `json:"outage"`
+}
+
+// State returns SinkOutage or SinkDegraded
+func (w *SinkWindow) State() string {
+	if w.Outage {
+		return SinkOutage
+	}
+	return SinkDegraded
+}
+
+// SinkStats counts what the broker did to degrade produce requests
+type SinkStats struct {
+	ProduceRequests int64   `json:"produce_requests"`
+	RejectedSets    int64   `json:"rejected_sets"`
+	Disconnects     int64   `json:"disconnects"`
+	DelayedSeconds  float64 `json:"delayed_seconds"`
+}
+
+// CheckSinkSchedule returns an error if the windows can't be applied
+func CheckSinkSchedule(windows []SinkWindow) error {
+	for i, w := range windows {
+		if w.StartSeconds < 0 || w.EndSeconds <= w.StartSeconds {
+			return fmt.Errorf("window #%d must end after it starts", i)
+		}
+		if w.ErrorRate < 0 || w.ErrorRate > 1 {
+			return fmt.Errorf("window #%d has an error_rate outside of 0 to 1", i)
+		}
+		if w.MaxMbPerSecond < 0 || w.LatencyMs < 0 {
+			return fmt.Errorf("window #%d has a negative max_mb_per_second or latency_ms", i)
+		}
+	}
+	sorted := SortSinkWindows(windows)
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].StartSeconds < sorted[i-1].EndSeconds {
+			return fmt.Errorf("windows starting at %ds and %ds overlap", sorted[i-1].StartSeconds, sorted[i].StartSeconds)
+		}
+	}
+	return nil
+}
+
+// SortSinkWindows returns a copy of windows in the order they start
+func SortSinkWindows(windows []SinkWindow) []SinkWindow {
+	sorted := append([]SinkWindow{}, windows...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].StartSeconds < sorted[j].StartSeconds })
+	return sorted
+}
+
+// SetSinkSchedule degrades the broker according to windows from now on
+func (b *Broker) SetSinkSchedule(windows []SinkWindow) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.schedule = SortSinkWindows(windows)
+	b.scheduleStart = time.Now()
+}
+
+// SinkCondition returns the window the broker is in, or nil while it's healthy
+func (b *Broker) SinkCondition() *SinkWindow {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.schedule) == 0 {
+		return nil
+	}
+	elapsed := time.Since(b.scheduleStart)
+	for i := range b.schedule {
+		w := &b.schedule[i]
+		if elapsed >= time.Duration(w.StartSeconds)*time.Second && elapsed < time.Duration(w.EndSeconds)*time.Second {
+			return w
+		}
+	}
+	return nil
+}
+
+// SinkStats returns what was done to produce requests so far
+func (b *Broker) SinkStats() SinkStats {
+	return SinkStats{
+		ProduceRequests: atomic.LoadInt64(&b.produceRequests),
+		RejectedSets:    atomic.LoadInt64(&b.rejectedSets),
+		Disconnects:     atomic.LoadInt64(&b.disconnects),
+		DelayedSeconds:  time.Duration(atomic.LoadInt64(&b.delayed)).Seconds(),
+	}
+}
+
+// sinkDelay returns how long a produce request of size bytes is held within
+// window w, which is its latency along with the time the ingest rate takes
+// to let it through after the requests ahead of it.
+func (b *Broker) sinkDelay(w *SinkWindow, size int) time.Duration {
+	delay := time.Duration(w.LatencyMs) * time.Millisecond
+	if w.MaxMbPerSecond > 0 {
+		b.mu.Lock()
+		now := time.Now()
+		if b.ingestFree.Before(now) {
+			b.ingestFree = now
+		}
+		b.ingestFree = b.ingestFree.Add(time.Duration(float64(size) / (w.MaxMbPerSecond * 1024 * 1024) * float64(time.Second)))
+		delay += b.ingestFree.Sub(now)
+		b.mu.Unlock()
+	}
+	return delay
+}
+
+// sinkRejects tells whether a record set is refused within window w
+func (b *Broker) sinkRejects(w *SinkWindow) bool {
+	if w.ErrorRate <= 0 {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rng.Float64() < w.ErrorRate
+}
+
+func newSinkRand() *rand.Rand {
+	return rand.New(rand.NewSource(time.Now().UnixNano()))
+}
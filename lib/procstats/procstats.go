@@ -0,0 +1,283 @@
+package procstats
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	linuxproc "github.com/c9s/goprocinfo/linux"
+)
+
+const procDir = "/proc"
+
+// Sample holds the raw counters read from /proc for a single process
+type Sample struct {
+	Pid                     int
+	PPid                    int
+	Pgid                    int
+	Name                    string
+	CPUTicks                uint64
+	RSS                     uint64
+	VMS                     uint64
+	Swap                    uint64
+	ReadBytes               uint64
+	WriteBytes              uint64
+	OpenFDs                 int
+	Threads                 int
+	VoluntaryCtxSwitches    uint64
+	NonvoluntaryCtxSwitches uint64
+	StartTime               uint64
+}
+
+// ProcessStats is a sample along with the rates computed since the
+// previous sample of the same process.
+type ProcessStats struct {
+	Sample
+	CPUPct float64 // Fraction of a single CPU, so 1.0 is one fully used core
+}
+
+// ReadSample reads the current counters of a process.  The I/O counters and
+// open file descriptors are left empty when they can't be read, as they
+// require more privileges than the rest.
+func ReadSample(pid int) (*Sample, error) {
+	dir := fmt.Sprintf("%s/%d", procDir, pid)
+
+	stat, err := linuxproc.ReadProcessStat(dir + "/stat")
+	if err != nil {
+		return nil, err
+	}
+	status, err := linuxproc.ReadProcessStatus(dir + "/status")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Sample{
+		Pid:                     pid,
+		PPid:                    int(stat.Ppid),
+		Pgid:                    int(stat.Pgrp),
+		Name:                    status.Name,
+		CPUTicks:                stat.Utime + stat.Stime,
+		RSS:                     status.VmRSS * 1024,
+		VMS:                     stat.Vsize,
+		Swap:                    status.VmSwap * 1024,
+		Threads:                 int(status.Threads),
+		VoluntaryCtxSwitches:    status.VoluntaryCtxtSwitches,
+		NonvoluntaryCtxSwitches: status.NonvoluntaryCtxtSwitches,
+		StartTime:               stat.Starttime,
+	}
+
+	if io, err := linuxproc.ReadProcessIO(dir + "/io"); err == nil {
+		s.ReadBytes = io.ReadBytes
+		s.WriteBytes = io.WriteBytes
+	}
+	if fh, err := os.Open(dir + "/fd"); err == nil {
+		names, _ := fh.Readdirnames(-1)
+		s.OpenFDs = len(names)
+		fh.Close()
+	}
+	return s, nil
+}
+
+// ListPids returns the ids of every running process
+func ListPids() ([]int, error) {
+	fh, err := os.Open(procDir)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	names, err := fh.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	pids := make([]int, 0, len(names))
+	for _, name := range names {
+		if pid, err := strconv.Atoi(name); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// Tracker follows a set of processes along with all of their descendants,
+// and computes CPU usage between successive samples.  Processes are either
+// added by pid, in which case their whole process group is followed, or by
+// name.  It isn't safe for concurrent use.
+type Tracker struct {
+	patterns []*regexp.Regexp
+	pgids    map[int]bool
+	minStart uint64
+	hz       float64
+	tracked  map[int]bool
+	prev     map[int]*Sample
+	prevTime time.Time
+}
+
+// NewTracker creates a tracker, ticksPerSecond being the kernel clock tick
+// rate used for CPU times in /proc.
+func NewTracker(ticksPerSecond uint64) *Tracker {
+	return &Tracker{
+		hz:      float64(ticksPerSecond),
+		pgids:   map[int]bool{},
+		tracked: map[int]bool{},
+		prev:    map[int]*Sample{},
+	}
+}
+
+// AddPid starts tracking a process and the members of the process group it
+// leads.  Once a pid is added, processes matched by name are only tracked
+// if they started after it, which keeps unrelated processes sharing the
+// same name out while still catching shippers that daemonize.
+func (t *Tracker) AddPid(pid int) error {
+	s, err := ReadSample(pid)
+	if err != nil {
+		return err
+	}
+	t.tracked[pid] = true
+	t.pgids[s.Pgid] = true
+	if t.minStart == 0 || s.StartTime < t.minStart {
+		t.minStart = s.StartTime
+	}
+	return nil
+}
+
+// AddName starts tracking processes whose name matches the regexp pattern
+func (t *Tracker) AddName(pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	t.patterns = append(t.patterns, re)
+	return nil
+}
+
+// Sample reads every tracked process once.  Processes which exited since
+// the previous call are no longer returned.
+func (t *Tracker) Sample() ([]ProcessStats, error) {
+	pids, err := ListPids()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
+	all := make(map[int]*Sample, len(pids))
+	for _, pid := range pids {
+		if s, err := ReadSample(pid); err == nil {
+			all[pid] = s
+		}
+	}
+
+	// A pid which was reused by an unrelated process can't stay tracked
+	for pid := range t.tracked {
+		s, ok := all[pid]
+		if !ok || (t.prev[pid] != nil && t.prev[pid].StartTime != s.StartTime) {
+			delete(t.tracked, pid)
+		}
+	}
+	for pid, s := range all {
+		if t.pgids[s.Pgid] || (t.matches(s) && s.StartTime >= t.minStart) {
+			t.tracked[pid] = true
+		}
+	}
+	t.addDescendants(all)
+
+	elapsed := now.Sub(t.prevTime).Seconds()
+	var stats []ProcessStats
+	prev := map[int]*Sample{}
+	for pid := range t.tracked {
+		s := all[pid]
+		ps := ProcessStats{Sample: *s}
+		if p, ok := t.prev[pid]; ok && elapsed > 0 && s.CPUTicks >= p.CPUTicks {
+			ps.CPUPct = float64(s.CPUTicks-p.CPUTicks) / t.hz / elapsed
+		}
+		stats = append(stats, ps)
+		prev[pid] = s
+	}
+	t.prev = prev
+	t.prevTime = now
+	return stats, nil
+}
+
+func (t *Tracker) matches(s *Sample) bool {
+	for _, re := range t.patterns {
+		if re.MatchString(s.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// addDescendants tracks every process whose parent is tracked
+func (t *Tracker) addDescendants(all map[int]*Sample) {
+	children := map[int][]int{}
+	for pid, s := range all {
+		children[s.PPid] = append(children[s.PPid], pid)
+	}
+	var queue []int
+	for pid := range t.tracked {
+		queue = append(queue, pid)
+	}
+	for len(queue) > 0 {
+		pid := queue[0]
+		queue = queue[1:]
+		for _, child := range children[pid] {
+			if !t.tracked[child] {
+				t.tracked[child] = true
+				queue = append(queue, child)
+			}
+		}
+	}
+}
+
+// FileOffsets returns the offset of every regular file a process has open,
+// read from /proc/<pid>/fdinfo, which is how far it read files it doesn't
+// write to.  When a file is open more than once, the furthest offset is kept.
+func FileOffsets(pid int) (map[string]int64, error) {
+	dir := fmt.Sprintf("%s/%d", procDir, pid)
+	fh, err := os.Open(dir + "/fd")
+	if err != nil {
+		return nil, err
+	}
+	fds, err := fh.Readdirnames(-1)
+	fh.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := map[string]int64{}
+	for _, fd := range fds {
+		target, err := os.Readlink(dir + "/fd/" + fd)
+		if err != nil || !strings.HasPrefix(target, "/") {
+			// Sockets, pipes and the like
+			continue
+		}
+		info, err := ioutil.ReadFile(dir + "/fdinfo/" + fd)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(info), "\n") {
+			if !strings.HasPrefix(line, "pos:") {
+				continue
+			}
+			if pos, err := strconv.ParseInt(strings.TrimSpace(line[4:]), 10, 64); err == nil {
+				if prev, ok := offsets[target]; !ok || pos > prev {
+					offsets[target] = pos
+				}
+			}
+			break
+		}
+	}
+	return offsets, nil
+}
+
+// Pids returns the processes tracked as of the last sample
+func (t *Tracker) Pids() []int {
+	pids := make([]int, 0, len(t.tracked))
+	for pid := range t.tracked {
+		pids = append(pids, pid)
+	}
+	return pids
+}
@@ -0,0 +1,127 @@
+package rate
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Shapes of load profiles
+const (
+	ShapeRamp  = "ramp"  // From From to To over DurationSeconds
+	ShapeSteps = "steps" // Steps rates from From to To, each held HoldSeconds
+	ShapeSpike = "spike" // From, with To for the last HoldSeconds of every PeriodSeconds
+	ShapeSine  = "sine"  // Between From and To, starting at From, over PeriodSeconds
+)
+
+// Units of the rates of load profiles
+const (
+	ProfileLines = "lines"
+	ProfileMb    = "mb"
+)
+
+// profileUpdateInterval is how often a driven target is updated
+const profileUpdateInterval = 100 * time.Millisecond
+
+// Profile describes how the target rate changes over the run.  Rates are
+// per second, in lines or MB depending on Unit.  Once a ramp or steps are
+// done, the rate stays at To.
+type Profile struct {
+	Shape           string  `json:"shape"`
+	Unit            string  `json:"unit"`
+	From            float64 `json:"from"`
+	To              float64 `json:"to"`
+	DurationSeconds int     `json:"duration_seconds"`
+	Steps           int     `json:"steps"`
+	HoldSeconds     int     `json:"hold_seconds"`
+	PeriodSeconds   int     `json:"period_seconds"`
+}
+
+// Check returns an error if the profile misses anything its shape requires
+func (p *Profile) Check() error {
+	if p.From < 0 || p.To < 0 {
+		return fmt.Errorf("rates can't be negative")
+	}
+	if p.Unit != "" && p.Unit != ProfileLines && p.Unit != ProfileMb {
+		return fmt.Errorf("unknown unit: %s (must be %s or %s)", p.Unit, ProfileLines, ProfileMb)
+	}
+	switch p.Shape {
+	case ShapeRamp:
+		if p.DurationSeconds < 1 {
+			return fmt.Errorf("a ramp requires duration_seconds")
+		}
+	case ShapeSteps:
+		if p.Steps < 2 || p.HoldSeconds < 1 {
+			return fmt.Errorf("steps require at least 2 steps and hold_seconds")
+		}
+	case ShapeSpike:
+		if p.PeriodSeconds < 1 || p.HoldSeconds < 1 || p.HoldSeconds >= p.PeriodSeconds {
+			return fmt.Errorf("spikes require period_seconds and hold_seconds, shorter than the period")
+		}
+	case ShapeSine:
+		if p.PeriodSeconds < 1 {
+			return fmt.Errorf("a sine requires period_seconds")
+		}
+	default:
+		return fmt.Errorf("unknown shape: %s", p.Shape)
+	}
+	return nil
+}
+
+// TargetUnit returns the unit of the target the profile drives, along with
+// the factor converting the rates of the profile to it
+func (p *Profile) TargetUnit() (string, float64) {
+	if p.Unit == ProfileMb {
+		return Bytes, 1024 * 1024
+	}
+	return Lines, 1
+}
+
+// Rate returns the rate once elapsed has passed since the start of the run
+func (p *Profile) Rate(elapsed time.Duration) float64 {
+	secs := elapsed.Seconds()
+	switch p.Shape {
+	case ShapeRamp:
+		if secs >= float64(p.DurationSeconds) {
+			return p.To
+		}
+		return p.From + (p.To-p.From)*secs/float64(p.DurationSeconds)
+	case ShapeSteps:
+		step := int(secs) / p.HoldSeconds
+		if step >= p.Steps-1 {
+			return p.To
+		}
+		return p.From + (p.To-p.From)*float64(step)/float64(p.Steps-1)
+	case ShapeSpike:
+		if math.Mod(secs, float64(p.PeriodSeconds)) >= float64(p.PeriodSeconds-p.HoldSeconds) {
+			return p.To
+		}
+		return p.From
+	case ShapeSine:
+		phase := 2 * math.Pi * secs / float64(p.PeriodSeconds)
+		return p.From + (p.To-p.From)*(1-math.Cos(phase))/2
+	}
+	return p.From
+}
+
+// NewTarget returns a target starting at the first rate of the profile
+func (p *Profile) NewTarget() *Target {
+	unit, scale := p.TargetUnit()
+	return NewTarget(p.Rate(0)*scale, unit)
+}
+
+// Drive updates the target to follow the profile until stop is closed
+func (p *Profile) Drive(target *Target, stop <-chan bool) {
+	_, scale := p.TargetUnit()
+	ticker := time.NewTicker(profileUpdateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			target.Set(p.Rate(target.Elapsed()) * scale)
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package rate
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestProfileCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile Profile
+		valid   bool
+	}{
+		{"ramp", Profile{Shape: ShapeRamp, From: 10, To: 100, DurationSeconds: 60}, true},
+		{"ramp without duration", Profile{Shape: ShapeRamp, From: 10, To: 100}, false},
+		{"steps", Profile{Shape: ShapeSteps, Steps: 3, HoldSeconds: 10}, true},
+		{"single step", Profile{Shape: ShapeSteps, Steps: 1, HoldSeconds: 10}, false},
+		{"spike", Profile{Shape: ShapeSpike, PeriodSeconds: 60, HoldSeconds: 5}, true},
+		{"spike as long as its period", Profile{Shape: ShapeSpike, PeriodSeconds: 60, HoldSeconds: 60}, false},
+		{"sine", Profile{Shape: ShapeSine, Unit: ProfileMb, PeriodSeconds: 60}, true},
+		{"sine without period", Profile{Shape: ShapeSine}, false},
+		{"negative rate", Profile{Shape: ShapeRamp, From: -1, DurationSeconds: 60}, false},
+		{"unknown unit", Profile{Shape: ShapeRamp, Unit: "kb", DurationSeconds: 60}, false},
+		{"unknown shape", Profile{Shape: "square"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.profile.Check(); (err == nil) != tt.valid {
+			t.Errorf("%s: Check() = %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestProfileRate(t *testing.T) {
+	ramp := Profile{Shape: ShapeRamp, From: 100, To: 200, DurationSeconds: 10}
+	steps := Profile{Shape: ShapeSteps, From: 100, To: 400, Steps: 4, HoldSeconds: 5}
+	spike := Profile{Shape: ShapeSpike, From: 100, To: 1000, PeriodSeconds: 10, HoldSeconds: 2}
+	sine := Profile{Shape: ShapeSine, From: 100, To: 300, PeriodSeconds: 20}
+
+	tests := []struct {
+		name    string
+		profile Profile
+		elapsed time.Duration
+		want    float64
+	}{
+		{"ramp start", ramp, 0, 100},
+		{"ramp halfway", ramp, 5 * time.Second, 150},
+		{"ramp done", ramp, 30 * time.Second, 200},
+		{"first step", steps, 4 * time.Second, 100},
+		{"second step", steps, 5 * time.Second, 200},
+		{"third step", steps, 14 * time.Second, 300},
+		{"last step held", steps, time.Minute, 400},
+		{"before spike", spike, 7 * time.Second, 100},
+		{"spike", spike, 8 * time.Second, 1000},
+		{"after spike", spike, 10 * time.Second, 100},
+		{"next spike", spike, 19 * time.Second, 1000},
+		{"sine start", sine, 0, 100},
+		{"sine quarter", sine, 5 * time.Second, 200},
+		{"sine peak", sine, 10 * time.Second, 300},
+		{"sine period", sine, 20 * time.Second, 100},
+	}
+	for _, tt := range tests {
+		if got := tt.profile.Rate(tt.elapsed); math.Abs(got-tt.want) > 1e-6 {
+			t.Errorf("%s: Rate(%s) = %g, want %g", tt.name, tt.elapsed, got, tt.want)
+		}
+	}
+}
+
+func TestProfileNewTarget(t *testing.T) {
+	tests := []struct {
+		profile   Profile
+		unit      string
+		perSecond float64
+	}{
+		{Profile{Shape: ShapeRamp, From: 10, To: 20, DurationSeconds: 5}, Lines, 10},
+		{Profile{Shape: ShapeRamp, Unit: ProfileLines, From: 10, To: 20, DurationSeconds: 5}, Lines, 10},
+		{Profile{Shape: ShapeRamp, Unit: ProfileMb, From: 2, To: 20, DurationSeconds: 5}, Bytes, 2 * 1024 * 1024},
+	}
+	for _, tt := range tests {
+		target := tt.profile.NewTarget()
+		if target.Unit() != tt.unit || target.PerSecond() != tt.perSecond {
+			t.Errorf("NewTarget() of %+v = %g %s/s, want %g %s/s", tt.profile, target.PerSecond(), target.Unit(), tt.perSecond, tt.unit)
+		}
+	}
+}
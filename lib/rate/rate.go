@@ -0,0 +1,114 @@
+package rate
+
+import (
+	"sync"
+	"time"
+)
+
+// Units of a target rate
+const (
+	Lines = "lines"
+	Bytes = "bytes"
+)
+
+// maxBacklog is how far behind a pacer may fall before what it owes is
+// forgotten, so a stall isn't followed by an unrealistic burst.
+const maxBacklog = time.Second
+
+// Target is the rate, per second, shared by all writers.  It may be changed
+// during the run, and keeps track of its mean over the run so the achieved
+// rate can be compared to it.
+type Target struct {
+	unit      string
+	perSecond float64
+	start     time.Time
+	changed   time.Time
+	total     float64 // Integral of the rate until the last change
+	lock      sync.RWMutex
+}
+
+func NewTarget(perSecond float64, unit string) *Target {
+	now := time.Now()
+	return &Target{unit: unit, perSecond: perSecond, start: now, changed: now}
+}
+
+// Unit returns what the rate is counted in, either Lines or Bytes
+func (t *Target) Unit() string {
+	return t.unit
+}
+
+// PerSecond returns the current rate
+func (t *Target) PerSecond() float64 {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.perSecond
+}
+
+// Set changes the rate from now on
+func (t *Target) Set(perSecond float64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := time.Now()
+	t.total += t.perSecond * now.Sub(t.changed).Seconds()
+	t.perSecond, t.changed = perSecond, now
+}
+
+// Elapsed returns the time since the target was created
+func (t *Target) Elapsed() time.Duration {
+	return time.Since(t.start)
+}
+
+// Mean returns the mean rate since the target was created
+func (t *Target) Mean() float64 {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	now := time.Now()
+	elapsed := now.Sub(t.start).Seconds()
+	if elapsed <= 0 {
+		return t.perSecond
+	}
+	return (t.total + t.perSecond*now.Sub(t.changed).Seconds()) / elapsed
+}
+
+// Pacer tells one writer how much it may write to follow its share of the
+// target.  Rather than a write every tick, which can't express intervals
+// under a millisecond and loses ticks under load, the writer is given what it
+// earned since it was last asked and writes it as a batch.
+type Pacer struct {
+	target *Target
+	shares int
+	budget float64
+	last   time.Time
+}
+
+// NewPacer returns a pacer for a writer getting one of shares equal parts
+// of the target.
+func NewPacer(target *Target, shares int) *Pacer {
+	if shares < 1 {
+		shares = 1
+	}
+	return &Pacer{target: target, shares: shares, last: time.Now()}
+}
+
+// Allow adds what was earned since the last call to the budget, and returns
+// whether anything may be written
+func (p *Pacer) Allow(now time.Time) bool {
+	share := p.target.PerSecond() / float64(p.shares)
+	p.budget += share * now.Sub(p.last).Seconds()
+	p.last = now
+	if max := share * maxBacklog.Seconds(); p.budget > max {
+		p.budget = max
+	}
+	return p.budget > 0
+}
+
+// Spend takes a write of the given lines and bytes off the budget.  The
+// budget may become negative, in which case the excess is made up for in the
+// next batches.
+func (p *Pacer) Spend(lines int, bytes int) {
+	if p.target.Unit() == Bytes {
+		p.budget -= float64(bytes)
+	} else {
+		p.budget -= float64(lines)
+	}
+}
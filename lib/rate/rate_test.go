@@ -0,0 +1,77 @@
+package rate
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPacerBudget(t *testing.T) {
+	type step struct {
+		after time.Duration // Since the previous step
+		lines int           // Written once allowed
+		bytes int
+		want  bool
+	}
+	tests := []struct {
+		name   string
+		rate   float64
+		unit   string
+		shares int
+		steps  []step
+	}{
+		{"nothing earned yet", 100, Lines, 1, []step{{0, 0, 0, false}}},
+		{"share of the rate", 100, Lines, 2, []step{
+			{time.Second, 50, 0, true},
+			{0, 0, 0, false}, // The 50 lines earned were spent
+			{10 * time.Millisecond, 0, 0, true},
+		}},
+		{"overspending is made up for", 100, Lines, 1, []step{
+			{100 * time.Millisecond, 30, 0, true}, // 10 earned, -20 left
+			{100 * time.Millisecond, 0, 0, false}, // -10
+			{200 * time.Millisecond, 0, 0, true},  // 10
+		}},
+		{"backlog is capped", 100, Lines, 1, []step{
+			{10 * time.Second, 100, 0, true}, // Only a second's worth is kept
+			{0, 0, 0, false},
+		}},
+		{"bytes", 1000, Bytes, 1, []step{
+			{time.Second, 1, 1000, true},
+			{0, 0, 0, false},
+		}},
+		{"zero rate", 0, Lines, 1, []step{{time.Second, 0, 0, false}}},
+		{"shares below one", 10, Lines, 0, []step{
+			{time.Second, 10, 0, true},
+			{0, 0, 0, false},
+		}},
+	}
+	for _, tt := range tests {
+		p := NewPacer(NewTarget(tt.rate, tt.unit), tt.shares)
+		now := p.last
+		for i, s := range tt.steps {
+			now = now.Add(s.after)
+			if got := p.Allow(now); got != s.want {
+				t.Errorf("%s: step %d: Allow = %v with a budget of %g, want %v", tt.name, i, got, p.budget, s.want)
+			}
+			p.Spend(s.lines, s.bytes)
+		}
+	}
+}
+
+func TestTargetMean(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		target *Target
+		want   float64
+	}{
+		{"constant", &Target{perSecond: 100, start: now.Add(-10 * time.Second), changed: now.Add(-10 * time.Second)}, 100},
+		{"changed halfway", &Target{perSecond: 200, start: now.Add(-10 * time.Second), changed: now.Add(-5 * time.Second), total: 500}, 150},
+		{"just created", &Target{perSecond: 42, start: now.Add(time.Hour), changed: now.Add(time.Hour)}, 42},
+	}
+	for _, tt := range tests {
+		if got := tt.target.Mean(); math.Abs(got-tt.want) > 0.1 {
+			t.Errorf("%s: Mean = %g, want %g", tt.name, got, tt.want)
+		}
+	}
+}
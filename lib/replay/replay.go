@@ -0,0 +1,194 @@
+package replay
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	generator "github.com/hartfordfive/logshipper-benchmark/lib/generator"
+)
+
+const maxLineSize = 1024 * 1024
+
+// Line is a line read from the input files.  Due is when it must be written
+// to follow the pacing of the original timestamps, and is left empty when
+// the source isn't paced.
+type Line struct {
+	Entry generator.Entry
+	Due   time.Time
+}
+
+// Source reads lines from plain or gzip compressed files, one after the
+// other, and hands them out to any number of writers.
+type Source struct {
+	files []string
+	loop  bool
+	paced bool
+	speed float64
+	lines chan Line
+	err   error
+}
+
+// timestampFormats are tried in order to find the timestamp of a line
+var timestampFormats = []struct {
+	re     *regexp.Regexp
+	layout string
+}{
+	{regexp.MustCompile(`\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d(\.\d+)?(Z|[+-]\d\d:?\d\d)`), time.RFC3339Nano},
+	{regexp.MustCompile(`\d{4}-\d\d-\d\d[ T]\d\d:\d\d:\d\d[.,]\d+`), "2006-01-02 15:04:05.999999999"},
+	{regexp.MustCompile(`\d{4}-\d\d-\d\d[ T]\d\d:\d\d:\d\d`), "2006-01-02 15:04:05"},
+	{regexp.MustCompile(`\d\d/[A-Z][a-z]{2}/\d{4}:\d\d:\d\d:\d\d [+-]\d{4}`), "02/Jan/2006:15:04:05 -0700"},
+	{regexp.MustCompile(`[A-Z][a-z]{2} [ \d]\d \d\d:\d\d:\d\d(\.\d+)?`), "Jan _2 15:04:05.999999999"},
+}
+
+// ParseTimestamp returns the first timestamp found in the line
+func ParseTimestamp(line []byte) (time.Time, bool) {
+	for _, f := range timestampFormats {
+		m := f.re.Find(line)
+		if m == nil {
+			continue
+		}
+		s := string(m)
+		if f.layout != time.RFC3339Nano && len(s) > 10 && s[10] == 'T' {
+			s = s[:10] + " " + s[11:]
+		}
+		if len(s) > 19 && s[19] == ',' {
+			s = s[:19] + "." + s[20:]
+		}
+		if t, err := time.Parse(f.layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// NewSource checks the input files can be read.  When paced, the delays
+// between lines follow those between their timestamps divided by speed,
+// lines without any being written right after the previous one.
+func NewSource(files []string, loop bool, paced bool, speed float64) (*Source, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no file to replay")
+	}
+	for _, f := range files {
+		fh, err := os.Open(f)
+		if err != nil {
+			return nil, err
+		}
+		fh.Close()
+	}
+	if speed <= 0 {
+		speed = 1
+	}
+	return &Source{
+		files: files,
+		loop:  loop,
+		paced: paced,
+		speed: speed,
+		lines: make(chan Line, 10000),
+	}, nil
+}
+
+// Lines returns the channel the lines are sent to, which is closed once
+// every file was read, unless looping.
+func (s *Source) Lines() <-chan Line {
+	return s.lines
+}
+
+// Err returns the error which stopped the source early, if any
+func (s *Source) Err() error {
+	return s.err
+}
+
+// Run reads the files until they're done or stop is closed
+func (s *Source) Run(stop <-chan bool) {
+	defer close(s.lines)
+
+	start := time.Now()
+	var elapsed time.Duration // Replayed time, before the speed factor
+	var prev time.Time
+	for {
+		sent := 0
+		for _, f := range s.files {
+			err := s.readFile(f, func(text []byte) bool {
+				sent++
+				line := Line{Entry: generator.Entry{Text: text}}
+				if s.paced {
+					if ts, ok := ParseTimestamp(text); ok {
+						// Time going backwards, from one file to the next, adds no delay
+						if !prev.IsZero() && ts.After(prev) {
+							elapsed += ts.Sub(prev)
+						}
+						prev = ts
+					}
+					line.Due = start.Add(time.Duration(float64(elapsed) / s.speed))
+				}
+				select {
+				case s.lines <- line:
+					return true
+				case <-stop:
+					return false
+				}
+			})
+			if err != nil {
+				s.err = err
+				return
+			}
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+		if !s.loop {
+			return
+		}
+		if sent == 0 {
+			// Looping over empty files would never send anything
+			s.err = fmt.Errorf("no line to replay in %s", strings.Join(s.files, ", "))
+			return
+		}
+		// Looping restarts from the first line's timestamp
+		prev = time.Time{}
+	}
+}
+
+// readFile calls fn with every line of the file, including its newline,
+// until it returns false
+func (s *Source) readFile(path string, fn func([]byte) bool) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	br := bufio.NewReaderSize(fh, 64*1024)
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		text := make([]byte, len(scanner.Bytes())+1)
+		copy(text, scanner.Bytes())
+		text[len(text)-1] = '\n'
+		if !fn(text) {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	return nil
+}
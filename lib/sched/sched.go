@@ -0,0 +1,266 @@
+package sched
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	linuxproc "github.com/c9s/goprocinfo/linux"
+	"golang.org/x/sys/unix"
+)
+
+// I/O scheduling classes, as taken by ioprio_set, and the capability
+// required to lower nice values
+const (
+	ioClassShift = 13
+	ioClassIdle  = 3
+	ioWhoProcess = 1
+	capSysNice   = 23
+)
+
+var ioClasses = []string{"none", "realtime", "best-effort", "idle"}
+
+// Settings are how the threads of a group of processes are scheduled.
+// Zero values leave the scheduling inherited from the harness.
+type Settings struct {
+	CPUs    []int
+	Nice    int
+	IOClass int // Index in ioClasses, 0 leaving it as is
+	IOLevel int
+}
+
+// Placement is the scheduling a process actually has
+type Placement struct {
+	Pid        int    `json:"pid"`
+	CPUs       string `json:"cpus"`
+	Nice       int    `json:"nice"`
+	IOPriority string `json:"io_priority"`
+}
+
+// ParseCPUList parses a list of CPUs such as "0-3,6", the way the kernel
+// writes them in Cpus_allowed_list
+func ParseCPUList(list string) ([]int, error) {
+	seen := map[int]bool{}
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("invalid CPU list: %s", list)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil || last < first {
+				return nil, fmt.Errorf("invalid CPU list: %s", list)
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			seen[cpu] = true
+		}
+	}
+	if len(seen) == 0 {
+		return nil, fmt.Errorf("empty CPU list: %s", list)
+	}
+	cpus := make([]int, 0, len(seen))
+	for cpu := range seen {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+// ParseIOPriority parses an I/O priority such as "best-effort:4", "idle" or
+// "realtime:0", returning the class and level
+func ParseIOPriority(priority string) (int, int, error) {
+	parts := strings.SplitN(priority, ":", 2)
+	class := -1
+	for i, name := range ioClasses {
+		if i > 0 && parts[0] == name {
+			class = i
+		}
+	}
+	if class < 0 {
+		return 0, 0, fmt.Errorf("unknown I/O class '%s' (must be realtime, best-effort or idle)", parts[0])
+	}
+	level := 4
+	if class == ioClassIdle {
+		// The idle class has no levels
+		level = 0
+	} else if len(parts) == 2 {
+		var err error
+		if level, err = strconv.Atoi(parts[1]); err != nil || level < 0 || level > 7 {
+			return 0, 0, fmt.Errorf("the I/O level must be between 0 and 7: %s", priority)
+		}
+	}
+	return class, level, nil
+}
+
+// Allowed returns the CPUs the calling thread may run on
+func Allowed() ([]int, error) {
+	var set unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &set); err != nil {
+		return nil, err
+	}
+	var cpus []int
+	for cpu := 0; cpu < len(set)*64; cpu++ {
+		if set.IsSet(cpu) {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// CheckNice returns an error if the harness can't give nice value nice to
+// the processes it starts, as lowering it below its own takes CAP_SYS_NICE
+// or a high enough RLIMIT_NICE
+func CheckNice(nice int) error {
+	current, err := Effective(os.Getpid())
+	if err != nil {
+		return err
+	}
+	if nice >= current.Nice || hasCapability(capSysNice) {
+		return nil
+	}
+	var limit unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NICE, &limit); err != nil {
+		return err
+	}
+	// The limit is the lowest nice value allowed, as 20 - nice
+	lowest := 20 - int(limit.Cur)
+	if current.Nice < lowest {
+		lowest = current.Nice
+	}
+	if nice < lowest {
+		return fmt.Errorf("a nice value of %d requires root or CAP_SYS_NICE, the lowest allowed is %d", nice, lowest)
+	}
+	return nil
+}
+
+// Apply schedules every thread of process pid according to settings.
+// Threads the process creates afterwards inherit it.
+func Apply(pid int, settings Settings) error {
+	tids, err := threads(pid)
+	if err != nil {
+		return err
+	}
+	var set unix.CPUSet
+	for _, cpu := range settings.CPUs {
+		set.Set(cpu)
+	}
+	for _, tid := range tids {
+		// Threads may exit meanwhile, which isn't an error
+		if len(settings.CPUs) > 0 {
+			if err := unix.SchedSetaffinity(tid, &set); err != nil && err != unix.ESRCH {
+				return fmt.Errorf("could not set the CPUs of %d: %s", tid, err)
+			}
+		}
+		if settings.Nice != 0 {
+			if err := unix.Setpriority(unix.PRIO_PROCESS, tid, settings.Nice); err != nil && err != unix.ESRCH {
+				return fmt.Errorf("could not set the nice value of %d: %s", tid, err)
+			}
+		}
+		if settings.IOClass > 0 {
+			prio := settings.IOClass<<ioClassShift | settings.IOLevel
+			if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioWhoProcess, uintptr(tid), uintptr(prio)); errno != 0 && errno != unix.ESRCH {
+				return fmt.Errorf("could not set the I/O priority of %d: %s", tid, errno)
+			}
+		}
+	}
+	return nil
+}
+
+// ProcessGroup returns the processes of group pgid
+func ProcessGroup(pgid int) []int {
+	dir, err := os.Open("/proc")
+	if err != nil {
+		return nil
+	}
+	defer dir.Close()
+	names, _ := dir.Readdirnames(-1)
+	var pids []int
+	for _, name := range names {
+		pid, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		if stat, err := linuxproc.ReadProcessStat(fmt.Sprintf("/proc/%d/stat", pid)); err == nil && int(stat.Pgrp) == pgid {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// Effective returns the scheduling of process pid
+func Effective(pid int) (Placement, error) {
+	p := Placement{Pid: pid}
+	status, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return p, err
+	}
+	defer status.Close()
+	scanner := bufio.NewScanner(status)
+	for scanner.Scan() {
+		if value := strings.TrimPrefix(scanner.Text(), "Cpus_allowed_list:"); value != scanner.Text() {
+			p.CPUs = strings.TrimSpace(value)
+		}
+	}
+	if stat, err := linuxproc.ReadProcessStat(fmt.Sprintf("/proc/%d/stat", pid)); err == nil {
+		p.Nice = int(stat.Nice)
+	}
+	prio, _, errno := unix.Syscall(unix.SYS_IOPRIO_GET, ioWhoProcess, uintptr(pid), 0)
+	if errno == 0 {
+		class, level := int(prio)>>ioClassShift, int(prio)&(1<<ioClassShift-1)
+		if class == ioClassIdle {
+			p.IOPriority = ioClasses[class]
+		} else if class > 0 && class < len(ioClasses) {
+			p.IOPriority = fmt.Sprintf("%s:%d", ioClasses[class], level)
+		} else {
+			// Derived from the nice value by the kernel
+			p.IOPriority = ioClasses[0]
+		}
+	}
+	return p, scanner.Err()
+}
+
+// hasCapability tells whether the harness has capability cap in effect
+func hasCapability(cap uint) bool {
+	status, err := os.Open("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	defer status.Close()
+	scanner := bufio.NewScanner(status)
+	for scanner.Scan() {
+		if value := strings.TrimPrefix(scanner.Text(), "CapEff:"); value != scanner.Text() {
+			caps, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+			return err == nil && caps&(1<<cap) != 0
+		}
+	}
+	return false
+}
+
+// threads returns the ids of the threads of process pid
+func threads(pid int) ([]int, error) {
+	dir, err := os.Open(fmt.Sprintf("/proc/%d/task", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	var tids []int
+	for _, name := range names {
+		if tid, err := strconv.Atoi(name); err == nil {
+			tids = append(tids, tid)
+		}
+	}
+	return tids, nil
+}
@@ -0,0 +1,59 @@
+package sched
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCPUList(t *testing.T) {
+	tests := []struct {
+		list  string
+		want  []int
+		valid bool
+	}{
+		{"0", []int{0}, true},
+		{"0-3", []int{0, 1, 2, 3}, true},
+		{"0-1,6", []int{0, 1, 6}, true},
+		{" 6 , 0-1 ", []int{0, 1, 6}, true},
+		{"2-3,3-4", []int{2, 3, 4}, true},
+		{"1,", []int{1}, true},
+		{"", nil, false},
+		{",", nil, false},
+		{"a", nil, false},
+		{"-1", nil, false},
+		{"3-1", nil, false},
+		{"1-b", nil, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseCPUList(tt.list)
+		if (err == nil) != tt.valid || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseCPUList(%q) = %v, %v, want %v and valid %v", tt.list, got, err, tt.want, tt.valid)
+		}
+	}
+}
+
+func TestParseIOPriority(t *testing.T) {
+	tests := []struct {
+		priority string
+		class    int
+		level    int
+		valid    bool
+	}{
+		{"realtime:0", 1, 0, true},
+		{"best-effort", 2, 4, true},
+		{"best-effort:7", 2, 7, true},
+		{"idle", ioClassIdle, 0, true},
+		{"idle:3", ioClassIdle, 0, true}, // The idle class has no levels
+		{"best-effort:8", 0, 0, false},
+		{"best-effort:-1", 0, 0, false},
+		{"realtime:x", 0, 0, false},
+		{"none", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, tt := range tests {
+		class, level, err := ParseIOPriority(tt.priority)
+		if (err == nil) != tt.valid || class != tt.class || level != tt.level {
+			t.Errorf("ParseIOPriority(%q) = %d, %d, %v, want %d, %d and valid %v", tt.priority, class, level, err, tt.class, tt.level, tt.valid)
+		}
+	}
+}
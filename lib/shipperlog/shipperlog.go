@@ -0,0 +1,152 @@
+package shipperlog
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Lines matching these are counted as errors or warnings
+var (
+	errorPattern   = regexp.MustCompile(`(?i)\b(error|err|fatal|panic|exception|critical|crit|failed)\b`)
+	warningPattern = regexp.MustCompile(`(?i)\b(warn|warning)\b`)
+)
+
+// Summary holds what was seen in the output of the shipper
+type Summary struct {
+	File         string   `json:"file"`
+	Lines        int64    `json:"lines"`
+	ErrorLines   int64    `json:"error_lines"`
+	WarningLines int64    `json:"warning_lines"`
+	FirstError   string   `json:"first_error,omitempty"`
+	LastLines    []string `json:"last_lines"`
+}
+
+// Capture saves the stdout and stderr of the shipper to a file, each line
+// prefixed with the time it was received and the stream it came from, and
+// keeps track of errors and of the last lines.
+type Capture struct {
+	file      *os.File
+	out       *bufio.Writer
+	keepLines int
+	summary   Summary
+	streams   []*stream
+	closed    bool
+	lock      sync.Mutex
+}
+
+// stream splits what is written to it into lines
+type stream struct {
+	capture *Capture
+	name    string
+	partial []byte
+}
+
+// New creates the file the output is saved to, replacing the one of any
+// previous run, keepLines being the number of last lines kept for the summary.
+func New(filePath string, keepLines int) (*Capture, error) {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return nil, err
+	}
+	c := &Capture{
+		file:      file,
+		out:       bufio.NewWriter(file),
+		keepLines: keepLines,
+		summary:   Summary{File: filePath},
+	}
+	return c, nil
+}
+
+// Stdout returns the writer the standard output of the shipper goes to
+func (c *Capture) Stdout() io.Writer {
+	return c.newStream("stdout")
+}
+
+// Stderr returns the writer the standard error of the shipper goes to
+func (c *Capture) Stderr() io.Writer {
+	return c.newStream("stderr")
+}
+
+func (c *Capture) newStream(name string) *stream {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	s := &stream{capture: c, name: name}
+	c.streams = append(c.streams, s)
+	return s
+}
+
+func (s *stream) Write(p []byte) (int, error) {
+	s.capture.lock.Lock()
+	defer s.capture.lock.Unlock()
+	if s.capture.closed {
+		// Left over processes may keep writing after the run
+		return len(p), nil
+	}
+
+	data := append(s.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		s.capture.add(s.name, string(bytes.TrimRight(data[:i], "\r")))
+		data = data[i+1:]
+	}
+	s.partial = append([]byte(nil), data...)
+	return len(p), nil
+}
+
+// add saves a line and updates the summary.  The lock must be held.
+func (c *Capture) add(streamName string, line string) {
+	fmt.Fprintf(c.out, "%s %s %s\n", time.Now().UTC().Format(time.RFC3339Nano), streamName, line)
+
+	c.summary.Lines++
+	if errorPattern.MatchString(line) {
+		c.summary.ErrorLines++
+		if c.summary.FirstError == "" {
+			c.summary.FirstError = line
+		}
+	} else if warningPattern.MatchString(line) {
+		c.summary.WarningLines++
+	}
+	if c.keepLines > 0 {
+		c.summary.LastLines = append(c.summary.LastLines, line)
+		if len(c.summary.LastLines) > c.keepLines {
+			c.summary.LastLines = c.summary.LastLines[1:]
+		}
+	}
+}
+
+// Summary returns what was seen so far
+func (c *Capture) Summary() Summary {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	s := c.summary
+	s.LastLines = append([]string(nil), c.summary.LastLines...)
+	return s
+}
+
+// Close saves lines which didn't end with a newline and closes the file.
+// Anything written once it's closed is dropped.
+func (c *Capture) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.closed = true
+	for _, s := range c.streams {
+		if len(s.partial) > 0 {
+			c.add(s.name, string(s.partial))
+			s.partial = nil
+		}
+	}
+	if err := c.out.Flush(); err != nil {
+		c.file.Close()
+		return err
+	}
+	return c.file.Close()
+}
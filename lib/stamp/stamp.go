@@ -0,0 +1,98 @@
+package stamp
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+)
+
+// Stamped lines start with "[lsb FILE SEQ UNIX_NANO] ", which identifies
+// every line written during a benchmark and when it was written.
+var prefix = []byte("[lsb ")
+
+// Stamp identifies a single written line
+type Stamp struct {
+	File    int
+	Seq     uint64
+	Written time.Time
+}
+
+// Append appends the stamp for a line, followed by a space, to buf
+func Append(buf []byte, file int, seq uint64, written time.Time) []byte {
+	buf = append(buf, prefix...)
+	buf = strconv.AppendInt(buf, int64(file), 10)
+	buf = append(buf, ' ')
+	buf = strconv.AppendUint(buf, seq, 10)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, written.UnixNano(), 10)
+	return append(buf, ']', ' ')
+}
+
+// Find looks for a stamp anywhere in b, as shippers usually wrap lines in
+// an envelope of their own.
+func Find(b []byte) (Stamp, bool) {
+	var s Stamp
+	i := bytes.Index(b, prefix)
+	if i < 0 {
+		return s, false
+	}
+	b = b[i+len(prefix):]
+
+	var fields [3]uint64
+	for f := range fields {
+		n := 0
+		for n < len(b) && b[n] >= '0' && b[n] <= '9' {
+			fields[f] = fields[f]*10 + uint64(b[n]-'0')
+			n++
+		}
+		sep := byte(' ')
+		if f == len(fields)-1 {
+			sep = ']'
+		}
+		if n == 0 || n >= len(b) || b[n] != sep {
+			return s, false
+		}
+		b = b[n+1:]
+	}
+
+	s.File = int(fields[0])
+	s.Seq = fields[1]
+	s.Written = time.Unix(0, int64(fields[2]))
+	return s, true
+}
+
+// Tracker remembers which sequence numbers were seen for every file, so
+// duplicated lines can be told apart from new ones.
+type Tracker struct {
+	seen   map[int][]uint64
+	unique int64
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{seen: map[int][]uint64{}}
+}
+
+// Add records a stamp and returns false if it was already seen.  The bitset
+// of the file grows up to its sequence number, which callers must bound.
+func (t *Tracker) Add(s Stamp) bool {
+	bits := t.seen[s.File]
+	word := int(s.Seq / 64)
+	if word >= len(bits) {
+		grown := make([]uint64, word+1, 2*(word+1))
+		copy(grown, bits)
+		bits = grown
+		t.seen[s.File] = bits
+	}
+	mask := uint64(1) << (s.Seq % 64)
+	if bits[word]&mask != 0 {
+		return false
+	}
+	bits[word] |= mask
+	t.unique++
+	return true
+}
+
+// Unique returns the number of distinct lines seen
+func (t *Tracker) Unique() int64 {
+	return t.unique
+}
@@ -0,0 +1,109 @@
+package stats
+
+import (
+	"math"
+)
+
+// Summary describes a set of measurements of the same value, such as the
+// throughput of several runs of a benchmark.
+type Summary struct {
+	N      int     `json:"n"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	CILow  float64 `json:"ci95_low"`
+	CIHigh float64 `json:"ci95_high"`
+}
+
+// Difference is the difference between the means of two summaries, b minus a
+type Difference struct {
+	Diff        float64 `json:"diff"`
+	CILow       float64 `json:"ci95_low"`
+	CIHigh      float64 `json:"ci95_high"`
+	Significant bool    `json:"significant"`
+}
+
+// Two-tailed 95% critical values of Student's t distribution, by degrees of
+// freedom from 1 to 30
+var tTable = []float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// tCritical returns the 95% critical value for the degrees of freedom.  A
+// fractional value is rounded down, which widens the interval slightly.
+func tCritical(df float64) float64 {
+	switch {
+	case df < 1:
+		return math.Inf(1)
+	case df <= 30:
+		return tTable[int(df)-1]
+	case df <= 40:
+		return 2.021
+	case df <= 60:
+		return 2.000
+	case df <= 120:
+		return 1.980
+	}
+	return 1.960
+}
+
+// Summarize computes the mean, sample standard deviation, extremes and the
+// 95% confidence interval of the mean of the values.  The interval assumes
+// the values are normally distributed, and is just the mean with a single
+// value.
+func Summarize(values []float64) Summary {
+	s := Summary{N: len(values)}
+	if s.N == 0 {
+		return s
+	}
+	s.Min, s.Max = values[0], values[0]
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+		s.Min = math.Min(s.Min, v)
+		s.Max = math.Max(s.Max, v)
+	}
+	s.Mean = sum / float64(s.N)
+	s.CILow, s.CIHigh = s.Mean, s.Mean
+	if s.N < 2 {
+		return s
+	}
+
+	sq := 0.0
+	for _, v := range values {
+		sq += (v - s.Mean) * (v - s.Mean)
+	}
+	s.StdDev = math.Sqrt(sq / float64(s.N-1))
+	margin := tCritical(float64(s.N-1)) * s.StdDev / math.Sqrt(float64(s.N))
+	s.CILow, s.CIHigh = s.Mean-margin, s.Mean+margin
+	return s
+}
+
+// Compare computes the 95% confidence interval of the difference between the
+// means of b and a with Welch's method, which doesn't assume both have the
+// same variance.  The difference is significant when the interval doesn't
+// include zero, which requires at least two values in each summary.
+func Compare(a, b Summary) Difference {
+	d := Difference{Diff: b.Mean - a.Mean}
+	if a.N < 2 || b.N < 2 {
+		d.CILow, d.CIHigh = math.Inf(-1), math.Inf(1)
+		return d
+	}
+	va := a.StdDev * a.StdDev / float64(a.N)
+	vb := b.StdDev * b.StdDev / float64(b.N)
+	se := math.Sqrt(va + vb)
+	if se == 0 {
+		d.CILow, d.CIHigh = d.Diff, d.Diff
+		d.Significant = d.Diff != 0
+		return d
+	}
+	// Welch–Satterthwaite degrees of freedom
+	df := (va + vb) * (va + vb) / (va*va/float64(a.N-1) + vb*vb/float64(b.N-1))
+	margin := tCritical(df) * se
+	d.CILow, d.CIHigh = d.Diff-margin, d.Diff+margin
+	d.Significant = d.CILow > 0 || d.CIHigh < 0
+	return d
+}
@@ -0,0 +1,93 @@
+package stats
+
+import (
+	"math"
+	"testing"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-3
+}
+
+func TestTCritical(t *testing.T) {
+	tests := []struct {
+		df   float64
+		want float64
+	}{
+		{0.5, math.Inf(1)},
+		{1, 12.706},
+		{4, 2.776},
+		{4.9, 2.776}, // Rounded down
+		{30, 2.042},
+		{35, 2.021},
+		{100, 1.980},
+		{1000, 1.960},
+	}
+	for _, tt := range tests {
+		if got := tCritical(tt.df); got != tt.want {
+			t.Errorf("tCritical(%g) = %g, want %g", tt.df, got, tt.want)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   Summary
+	}{
+		{"empty", nil, Summary{}},
+		{"single", []float64{7}, Summary{N: 1, Mean: 7, Min: 7, Max: 7, CILow: 7, CIHigh: 7}},
+		{"identical", []float64{3, 3, 3}, Summary{N: 3, Mean: 3, Min: 3, Max: 3, CILow: 3, CIHigh: 3}},
+		{
+			"spread",
+			[]float64{1, 2, 3, 4, 5},
+			// stddev = sqrt(2.5), margin = 2.776 * stddev / sqrt(5)
+			Summary{N: 5, Mean: 3, StdDev: 1.5811, Min: 1, Max: 5, CILow: 1.0369, CIHigh: 4.9631},
+		},
+	}
+	for _, tt := range tests {
+		got := Summarize(tt.values)
+		if got.N != tt.want.N || !near(got.Mean, tt.want.Mean) || !near(got.StdDev, tt.want.StdDev) ||
+			got.Min != tt.want.Min || got.Max != tt.want.Max || !near(got.CILow, tt.want.CILow) || !near(got.CIHigh, tt.want.CIHigh) {
+			t.Errorf("%s: Summarize(%v) = %+v, want %+v", tt.name, tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name        string
+		a, b        []float64
+		diff        float64
+		ciLow       float64
+		ciHigh      float64
+		significant bool
+	}{
+		{"too few values", []float64{1}, []float64{2, 3}, 1.5, math.Inf(-1), math.Inf(1), false},
+		{"no variance, same mean", []float64{5, 5}, []float64{5, 5, 5}, 0, 0, 0, false},
+		{"no variance, different mean", []float64{5, 5}, []float64{6, 6}, 1, 1, 1, true},
+		{
+			// Same variance and size: se = sqrt(2.5/5 * 2) = 1, df = 8
+			"overlapping", []float64{1, 2, 3, 4, 5}, []float64{2, 3, 4, 5, 6},
+			1, 1 - 2.306, 1 + 2.306, false,
+		},
+		{
+			"apart", []float64{1, 2, 3, 4, 5}, []float64{11, 12, 13, 14, 15},
+			10, 10 - 2.306, 10 + 2.306, true,
+		},
+		{
+			// Welch-Satterthwaite gives df = 4.04, taken as 4
+			"unequal variances", []float64{10, 10.1, 9.9, 10, 10}, []float64{1, 2, 3, 4, 5},
+			-7, -7 - 2.776*math.Sqrt(0.005/5+0.5), -7 + 2.776*math.Sqrt(0.005/5+0.5), true,
+		},
+	}
+	for _, tt := range tests {
+		got := Compare(Summarize(tt.a), Summarize(tt.b))
+		if !near(got.Diff, tt.diff) || got.Significant != tt.significant ||
+			!(got.CILow == tt.ciLow || near(got.CILow, tt.ciLow)) || !(got.CIHigh == tt.ciHigh || near(got.CIHigh, tt.ciHigh)) {
+			t.Errorf("%s: Compare = %+v, want diff %g, interval [%g, %g], significant %v",
+				tt.name, got, tt.diff, tt.ciLow, tt.ciHigh, tt.significant)
+		}
+	}
+}
@@ -1,15 +1,22 @@
 package lib
 
 import (
+	"encoding/csv"
 	"fmt"
+	"io"
 	"math"
 	"math/rand"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/Pallinder/go-randomdata"
-	"github.com/shirou/gopsutil/process"
+
+	procstats "github.com/hartfordfive/logshipper-benchmark/lib/procstats"
 )
 
 /*
@@ -22,6 +29,143 @@ import "C"
 
 var Debug bool
 
+// ProcessTreeSummary aggregates the samples taken by CollectCpuStats
+type ProcessTreeSummary struct {
+	Samples      int64   `json:"samples"`
+	CPUPctAvg    float64 `json:"cpu_pct_avg"`
+	CPUPctMax    float64 `json:"cpu_pct_max"`
+	RSSBytesAvg  uint64  `json:"rss_bytes_avg"`
+	RSSBytesMax  uint64  `json:"rss_bytes_max"`
+	MaxProcesses int     `json:"max_processes"`
+	cpuSamples   int64   // Samples with a CPU usage, which the first one lacks
+}
+
+var processTreeSummaries = map[int]*ProcessTreeSummary{}
+var processTreeSummariesLock sync.Mutex
+
+// GetProcessTreeSummary returns the resource usage of the process trees
+// whose stats were collected with pids as the roots, such as a shipper and
+// the processes it was restarted as.  Averages are over the samples of every
+// tree, and maximums over all trees.
+func GetProcessTreeSummary(pids ...int) (ProcessTreeSummary, bool) {
+	processTreeSummariesLock.Lock()
+	defer processTreeSummariesLock.Unlock()
+	var total ProcessTreeSummary
+	found := false
+	for _, pid := range pids {
+		if summary, ok := processTreeSummaries[pid]; ok {
+			total.merge(summary)
+			found = true
+		}
+	}
+	return total, found
+}
+
+// merge adds the samples of another summary to s
+func (s *ProcessTreeSummary) merge(o *ProcessTreeSummary) {
+	if c := s.cpuSamples + o.cpuSamples; c > 0 {
+		s.CPUPctAvg = (s.CPUPctAvg*float64(s.cpuSamples) + o.CPUPctAvg*float64(o.cpuSamples)) / float64(c)
+		s.cpuSamples = c
+	}
+	if n := s.Samples + o.Samples; n > 0 {
+		s.RSSBytesAvg = uint64((float64(s.RSSBytesAvg)*float64(s.Samples) + float64(o.RSSBytesAvg)*float64(o.Samples)) / float64(n))
+		s.Samples = n
+	}
+	if o.CPUPctMax > s.CPUPctMax {
+		s.CPUPctMax = o.CPUPctMax
+	}
+	if o.RSSBytesMax > s.RSSBytesMax {
+		s.RSSBytesMax = o.RSSBytesMax
+	}
+	if o.MaxProcesses > s.MaxProcesses {
+		s.MaxProcesses = o.MaxProcesses
+	}
+}
+
+// updateProcessTreeSummary adds a sample to the summary of the tree of pid.
+// The CPU usage is only known from the second sample on, as it's measured
+// since the previous one.
+func updateProcessTreeSummary(pid int, cpuPct float64, cpuKnown bool, rss uint64, processes int) {
+	processTreeSummariesLock.Lock()
+	defer processTreeSummariesLock.Unlock()
+	summary, ok := processTreeSummaries[pid]
+	if !ok {
+		summary = &ProcessTreeSummary{}
+		processTreeSummaries[pid] = summary
+	}
+	if cpuKnown {
+		c := float64(summary.cpuSamples)
+		summary.CPUPctAvg = (summary.CPUPctAvg*c + cpuPct) / (c + 1)
+		summary.cpuSamples++
+	}
+	n := float64(summary.Samples)
+	summary.RSSBytesAvg = uint64((float64(summary.RSSBytesAvg)*n + float64(rss)) / (n + 1))
+	summary.Samples++
+	if cpuPct > summary.CPUPctMax {
+		summary.CPUPctMax = cpuPct
+	}
+	if rss > summary.RSSBytesMax {
+		summary.RSSBytesMax = rss
+	}
+	if processes > summary.MaxProcesses {
+		summary.MaxProcesses = processes
+	}
+}
+
+// ShipperProcessNames holds name patterns of processes which belong to the
+// shipper even though they aren't descendants of the process that was
+// started, for shippers which daemonize.
+var ShipperProcessNames []string
+
+// RunOptions are the settings of the run which shipper modules apply when
+// building their config and starting the shipper
+type RunOptions struct {
+	// FileScanIntervalSecs is how often shippers should look for new files
+	// matching the patterns they monitor, 0 leaving their own default.
+	FileScanIntervalSecs int
+	// PreserveState is set once the shipper is restarted during a run, so
+	// its state, such as the registry of files read, isn't removed when its
+	// config is built again.
+	PreserveState bool
+	// Stdout and Stderr receive the output of the shipper, which is
+	// discarded when they're nil.
+	Stdout, Stderr io.Writer
+	// Cgroup is the directory of the cgroup the shipper runs in, if any
+	Cgroup *os.File
+	// MultilineStart is a regexp matching the first line of entries when
+	// they span several lines, which shippers must join into a single event.
+	// It's empty when every line is an entry.
+	MultilineStart string
+}
+
+// shipperOutputDelay is how long the output of the shipper is still read
+// once it exited, in case processes it left behind keep it open
+const shipperOutputDelay = 5 * time.Second
+
+// CaptureOutput sends the output of the shipper command to Stdout and
+// Stderr.  It must be called before the command is started.
+func (o *RunOptions) CaptureOutput(cmd *exec.Cmd) {
+	if o.Stdout == nil && o.Stderr == nil {
+		return
+	}
+	cmd.Stdout, cmd.Stderr = o.Stdout, o.Stderr
+	cmd.WaitDelay = shipperOutputDelay
+}
+
+// ConfineShipper makes the shipper command start in Cgroup, so every
+// process it forks is accounted for and limited along with it.  It must be
+// called once SysProcAttr is set and before the command is started.
+func (o *RunOptions) ConfineShipper(cmd *exec.Cmd) {
+	if o.Cgroup == nil {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(o.Cgroup.Fd())
+}
+
 func init() {
 	Debug = false
 }
@@ -78,17 +222,32 @@ func GetRandInt(min, max int) int {
 	return rand.Intn(max-min) + min
 }
 
+// CollectCpuStats samples the CPU and memory usage of a shipper every 2
+// seconds until shutdown.  As shippers are often started through a wrapper
+// or fork worker processes, the whole process group led by pid is followed
+// along with every descendant, and processes named like one of
+// ShipperProcessNames.  The totals are written to one CSV file and the
+// usage of each individual process to another.
 func CollectCpuStats(pid int, metricsFilePath string, shutdownChan chan bool) {
 
-	psStats, _ := process.NewProcess(int32(pid))
+	tracker := procstats.NewTracker(GetClockTicksPerSecond())
+	if err := tracker.AddPid(pid); err != nil {
+		fmt.Printf("[ERROR] Could not read stats of process %d: %s\n", pid, err)
+	}
+	for _, name := range ShipperProcessNames {
+		if err := tracker.AddName(name); err != nil {
+			fmt.Printf("[ERROR] Invalid process name pattern '%s': %s\n", name, err)
+		}
+	}
 
 	CreateDir(metricsFilePath)
 
 	ticker := time.NewTicker(time.Millisecond * 2000)
 	defer ticker.Stop()
 	t := time.Now()
+	dt := fmt.Sprintf("%d%02d%02d%02d%02d%02d", t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second())
 
-	filePath := fmt.Sprintf("%s/ps-%d-stats-%d%02d%02d%02d%02d%02d.csv", metricsFilePath, pid, t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second())
+	filePath := fmt.Sprintf("%s/ps-%d-stats-%s.csv", metricsFilePath, pid, dt)
 	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0664)
 	if err != nil {
 		fmt.Printf("[ERROR] Could not open %s: %s\n", filePath, err)
@@ -96,79 +255,61 @@ func CollectCpuStats(pid int, metricsFilePath string, shutdownChan chan bool) {
 	}
 	defer file.Close()
 
-	// see: https://stackoverflow.com/a/16736599
-	/*
-		var stat *linuxproc.ProcessStat
-		var totalTime uint64
-		var seconds float64
-		var totalCpuUsage float64
-		hertz := GetClockTicksPerSecond()
-
-		if Debug {
-			fmt.Println("[DEBUG] Current CPU clock ticks: ", hertz)
-			fmt.Printf("[DEBUG] Fetching PID stats from /proc/%d/stat\n", pid)
-		}
-
-		uptime, err := linuxproc.ReadUptime("/proc/uptime")
-		if err != nil {
-			fmt.Println("[ERROR] Could not read system uptime")
-		}
-	*/
-
-	_, err = file.Write([]byte("unix_timestamp_ms,total_cpu_pct,mem_bytes_rss,mem_bytes_vms,mem_bytes_swap\n"))
+	treeFilePath := fmt.Sprintf("%s/ps-%d-tree-stats-%s.csv", metricsFilePath, pid, dt)
+	treeFile, err := os.OpenFile(treeFilePath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0664)
 	if err != nil {
-		fmt.Println(err)
+		fmt.Printf("[ERROR] Could not open %s: %s\n", treeFilePath, err)
+		os.Exit(1)
 	}
+	defer treeFile.Close()
 
-	var memInfoRSS, memInfoVMS, memInfoSwap uint64
-	var psCPU float64
+	// Process names can hold commas and quotes
+	out := csv.NewWriter(file)
+	treeOut := csv.NewWriter(treeFile)
+	out.Write([]string{"unix_timestamp_ms", "total_cpu_pct", "mem_bytes_rss", "mem_bytes_vms", "mem_bytes_swap", "num_processes"})
+	treeOut.Write([]string{"unix_timestamp_ms", "pid", "ppid", "name", "cpu_pct", "mem_bytes_rss", "mem_bytes_vms", "mem_bytes_swap"})
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', 6, 64) }
+	u := func(v uint64) string { return strconv.FormatUint(v, 10) }
+
+	first := true
 
 	for {
 		select {
 
 		case <-ticker.C:
 
-			processCpuPct, err := psStats.CPUPercent()
-			if err == nil {
-				psCPU = processCpuPct
-			}
-
-			memInfo, err := psStats.MemoryInfo()
-			if err == nil {
-				memInfoRSS = memInfo.RSS
-				memInfoVMS = memInfo.VMS
-				memInfoSwap = memInfo.Swap
+			stats, err := tracker.Sample()
+			if err != nil {
+				fmt.Println("[ERROR] Could not sample process tree: ", err)
+				continue
 			}
 
-			//fmt.Printf("CPU: %f, Mem Used: %d\n", psCPU, memInfoRSS)
+			var psCPU float64
+			var memInfoRSS, memInfoVMS, memInfoSwap uint64
+			ts := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
 
-			/*
-				stat, err = linuxproc.ReadProcessStat(fmt.Sprintf("/proc/%d/stat", pid))
-				if err != nil {
-					fmt.Printf("[ERROR] Could not read filebeat CPU stats (PID %d)\n", pid)
-					continue
-				}
+			for _, ps := range stats {
+				psCPU += ps.CPUPct * 100
+				memInfoRSS += ps.RSS
+				memInfoVMS += ps.VMS
+				memInfoSwap += ps.Swap
+				treeOut.Write([]string{ts, strconv.Itoa(ps.Pid), strconv.Itoa(ps.PPid), ps.Name, f(ps.CPUPct * 100), u(ps.RSS), u(ps.VMS), u(ps.Swap)})
+			}
 
-				totalTime = stat.Utime + stat.Stime + uint64(stat.Cutime) + uint64(stat.Cstime)
-				seconds = uptime.Total - float64(stat.Starttime/hertz)
-				totalCpuUsage = 100 * (float64(totalTime/hertz) / seconds)
-			*/
+			updateProcessTreeSummary(pid, psCPU, !first, memInfoRSS, len(stats))
+			first = false
 
-			ts := time.Now().UnixNano() / int64(time.Millisecond)
 			if Debug {
-				//fmt.Printf("ts: %d, utime: %d, stime: %d, cutime: %d, cstime: %d, starttime: %d, total_cpu: %f, mem_rss: %d, mem_vms: %d, mem_swap: %d\n", ts, stat.Utime, stat.Stime, stat.Cutime, stat.Cstime, stat.Starttime, totalCpuUsage, memInfo.RSS, memInfo.VMS, memInfo.Swap)
-				fmt.Printf("ts: %d, Process CPU %%: %f, Mem Used: %d\n", ts, psCPU, memInfoRSS)
-
+				fmt.Printf("ts: %s, Process tree CPU %%: %f, Mem Used: %d, Processes: %d\n", ts, psCPU, memInfoRSS, len(stats))
 			}
 
-			/*
-				_, err = file.Write([]byte(fmt.Sprintf("%d,%d,%d,%d,%d,%d,%f,%d,%d,%d\n", ts, stat.Utime, stat.Stime, stat.Cutime, stat.Cstime, stat.Starttime, totalCpuUsage, memInfo.RSS, memInfo.VMS, memInfo.Swap)))
-				if err != nil {
-					fmt.Println(err)
-				}
-			*/
-			_, err = file.Write([]byte(fmt.Sprintf("%d,%f,%d,%d,%d\n", ts, psCPU, memInfoRSS, memInfoVMS, memInfoSwap)))
-			if err != nil {
+			out.Write([]string{ts, f(psCPU), u(memInfoRSS), u(memInfoVMS), u(memInfoSwap), strconv.Itoa(len(stats))})
+			out.Flush()
+			if err := out.Error(); err != nil {
+				fmt.Println(err)
+			}
+			treeOut.Flush()
+			if err := treeOut.Error(); err != nil {
 				fmt.Println(err)
 			}
 
@@ -177,6 +318,7 @@ func CollectCpuStats(pid int, metricsFilePath string, shutdownChan chan bool) {
 				fmt.Println("[DEBUG] Terminating CPU stats collection goroutine")
 			}
 			file.Close()
+			treeFile.Close()
 			return
 		}
 	}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	utils "github.com/hartfordfive/logshipper-benchmark/lib"
+	rate "github.com/hartfordfive/logshipper-benchmark/lib/rate"
+)
+
+// loadEvent is a sample of the load timeline, which is written next to the
+// metrics data file so the rate can be lined up with the resource usage of
+// the shipper.
+type loadEvent struct {
+	Timestamp string            `json:"@timestamp"`
+	Metricset map[string]string `json:"metricset"`
+	Load      loadSample        `json:"load"`
+}
+
+type loadSample struct {
+	Unit           string  `json:"unit"`
+	Target         float64 `json:"target"`
+	Achieved       float64 `json:"achieved"`
+	LinesPerSecond float64 `json:"lines_per_second"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+
+	// Only set when delivery is verified
+	DeliveredLinesPerSecond *float64 `json:"delivered_lines_per_second,omitempty"`
+}
+
+// loadTimelineFile returns the path of the load timeline of a metrics data file
+func loadTimelineFile(metricsDataFile string) string {
+	return strings.TrimSuffix(metricsDataFile, ".log") + "-load.log"
+}
+
+// recordLoadTimeline writes the current target rate, along with the rate
+// achieved since the previous sample, every period until shutdown.  When
+// received isn't nil, it returns the number of lines delivered so far.
+func recordLoadTimeline(filePath string, target *rate.Target, counters *writeCounters, received func() int64, period time.Duration, shutdownChan <-chan bool, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	utils.CreateDir(path.Dir(filePath))
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		fmt.Printf("[ERROR] Could not open %s: %s\n", filePath, err)
+		return
+	}
+	defer file.Close()
+	out := bufio.NewWriter(file)
+	defer out.Flush()
+	enc := json.NewEncoder(out)
+
+	unit, scale := "lines/s", 1.0
+	if target.Unit() == rate.Bytes {
+		unit, scale = "MB/s", 1024*1024
+	}
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	last := time.Now()
+	lastLines, lastBytes := counters.Lines.Value(), counters.Bytes.Value()
+	var lastReceived int64
+	if received != nil {
+		lastReceived = received()
+	}
+	for {
+		select {
+		case now := <-ticker.C:
+			lines, bytes := counters.Lines.Value(), counters.Bytes.Value()
+			secs := now.Sub(last).Seconds()
+			sample := loadSample{
+				Unit:           unit,
+				Target:         target.PerSecond() / scale,
+				LinesPerSecond: float64(lines-lastLines) / secs,
+				BytesPerSecond: float64(bytes-lastBytes) / secs,
+			}
+			if target.Unit() == rate.Bytes {
+				sample.Achieved = sample.BytesPerSecond / scale
+			} else {
+				sample.Achieved = sample.LinesPerSecond
+			}
+			if received != nil {
+				n := received()
+				delivered := float64(n-lastReceived) / secs
+				sample.DeliveredLinesPerSecond = &delivered
+				lastReceived = n
+			}
+			enc.Encode(&loadEvent{
+				Timestamp: now.UTC().Format(time.RFC3339Nano),
+				Metricset: map[string]string{"module": "benchmark", "name": "load"},
+				Load:      sample,
+			})
+			last, lastLines, lastBytes = now, lines, bytes
+		case <-shutdownChan:
+			return
+		}
+	}
+}
@@ -90,20 +90,46 @@ type metricbeatConfig struct {
 	Fields             map[string]string
 }
 
-type metricCollector struct {
+// MetricCollector gathers the resource usage of the shipper processes for
+// the duration of the benchmark.  Events carry the given fields, under
+// fields.meta, and tags.
+type MetricCollector interface {
+	Name() string
+	Run(processesToMonitor []string, fields map[string]string, tags []string, metricsFileName string, shutdownChan chan bool, wg *sync.WaitGroup)
+	DataFile(metricsFileName string) string
 }
 
-func NewMetricCollector() *metricCollector {
-	return &metricCollector{}
+type metricbeatCollector struct {
+	binPath    string
+	cmdArgs    []string
+	workingDir string
 }
 
-func (mc *metricCollector) CleanupFiles() {
+func NewMetricbeatCollector(binPath string, cmdArgs []string, workingDir string) *metricbeatCollector {
+	return &metricbeatCollector{
+		binPath:    binPath,
+		cmdArgs:    cmdArgs,
+		workingDir: workingDir,
+	}
+}
+
+func (mc *metricbeatCollector) Name() string { return "metricbeat" }
+
+func (mc *metricbeatCollector) Run(processesToMonitor []string, fields map[string]string, tags []string, metricsFileName string, shutdownChan chan bool, wg *sync.WaitGroup) {
+	mc.RunMetricbeat(mc.binPath, mc.cmdArgs, mc.workingDir, processesToMonitor, fields, tags, metricsFileName, shutdownChan, wg)
+}
+
+func (mc *metricbeatCollector) DataFile(metricsFileName string) string {
+	return fmt.Sprintf("%s/%s", strings.TrimRight(mc.workingDir, "/"), metricsFileName)
+}
+
+func (mc *metricbeatCollector) CleanupFiles() {
 	if _, err := os.Stat("metricbeat.yml"); err == nil {
 		os.Remove("metricbeat.yml")
 	}
 }
 
-func (mc *metricCollector) BuildConfig(confDestPath string, conf *metricbeatConfig) error {
+func (mc *metricbeatCollector) BuildConfig(confDestPath string, conf *metricbeatConfig) error {
 
 	mc.CleanupFiles()
 
@@ -120,7 +146,7 @@ func (mc *metricCollector) BuildConfig(confDestPath string, conf *metricbeatConf
 	return t.Execute(fh, conf)
 }
 
-func (mc *metricCollector) RunMetricbeat(binPath string, cmdArgs []string, workingDir string, processesToMonitor []string, fields map[string]string, tags []string, metricsLogFileName string, shutdownChan chan bool, wg *sync.WaitGroup) {
+func (mc *metricbeatCollector) RunMetricbeat(binPath string, cmdArgs []string, workingDir string, processesToMonitor []string, fields map[string]string, tags []string, metricsLogFileName string, shutdownChan chan bool, wg *sync.WaitGroup) {
 
 	//Generate, the config
 	wd := strings.TrimRight(workingDir, "/")
@@ -162,7 +188,7 @@ func (mc *metricCollector) RunMetricbeat(binPath string, cmdArgs []string, worki
 	wg.Done()
 }
 
-func (mc *metricCollector) waitForShutdown(metricCollectorPid int, shutdownChan <-chan bool) {
+func (mc *metricbeatCollector) waitForShutdown(metricCollectorPid int, shutdownChan <-chan bool) {
 
 	fmt.Println("[DEBUG] Waiting for shutdown signal to terminate metric collector...")
 
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	utils "github.com/hartfordfive/logshipper-benchmark/lib"
+	procstats "github.com/hartfordfive/logshipper-benchmark/lib/procstats"
+)
+
+// nativeCollector samples the shipper processes straight from /proc and
+// writes events shaped like the metricbeat system.process metricset, one
+// JSON document per line.
+type nativeCollector struct {
+	metricsDir string
+	period     time.Duration
+}
+
+type processEvent struct {
+	Timestamp string                 `json:"@timestamp"`
+	Metricset map[string]string      `json:"metricset"`
+	System    map[string]interface{} `json:"system"`
+	Fields    map[string]interface{} `json:"fields"`
+	Tags      []string               `json:"tags"`
+}
+
+func NewNativeCollector(metricsDir string, period time.Duration) *nativeCollector {
+	return &nativeCollector{
+		metricsDir: metricsDir,
+		period:     period,
+	}
+}
+
+func (nc *nativeCollector) Name() string { return "native" }
+
+func (nc *nativeCollector) DataFile(metricsFileName string) string {
+	return fmt.Sprintf("%s/%s", strings.TrimRight(nc.metricsDir, "/"), metricsFileName)
+}
+
+func (nc *nativeCollector) Run(processesToMonitor []string, fields map[string]string, tags []string, metricsFileName string, shutdownChan chan bool, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	tracker := procstats.NewTracker(utils.GetClockTicksPerSecond())
+	for _, name := range processesToMonitor {
+		if err := tracker.AddName(name); err != nil {
+			fmt.Printf("[ERROR] Invalid process name pattern '%s': %s\n", name, err)
+			return
+		}
+	}
+
+	utils.CreateDir(nc.metricsDir)
+	filePath := nc.DataFile(metricsFileName)
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		fmt.Printf("[ERROR] Could not open %s: %s\n", filePath, err)
+		return
+	}
+	defer file.Close()
+	out := bufio.NewWriter(file)
+	defer out.Flush()
+	enc := json.NewEncoder(out)
+
+	meta := map[string]interface{}{"category": "benchmark"}
+	for k, v := range fields {
+		meta[k] = v
+	}
+	eventFields := map[string]interface{}{"meta": meta}
+	numCPU := float64(runtime.NumCPU())
+
+	fmt.Printf("[INFO] Native metric collector is now running (every %s).\n", nc.period)
+
+	ticker := time.NewTicker(nc.period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			stats, err := tracker.Sample()
+			if err != nil {
+				fmt.Printf("[ERROR] Could not sample processes: %s\n", err)
+				continue
+			}
+			ts := time.Now().UTC().Format(time.RFC3339Nano)
+			for _, ps := range stats {
+				enc.Encode(&processEvent{
+					Timestamp: ts,
+					Metricset: map[string]string{"module": "system", "name": "process"},
+					System: map[string]interface{}{
+						"process": map[string]interface{}{
+							"pid":         ps.Pid,
+							"ppid":        ps.PPid,
+							"pgid":        ps.Pgid,
+							"name":        ps.Name,
+							"num_threads": ps.Threads,
+							"cpu": map[string]interface{}{
+								"total": map[string]interface{}{
+									"pct":   ps.CPUPct,
+									"norm":  map[string]float64{"pct": ps.CPUPct / numCPU},
+									"ticks": ps.CPUTicks,
+								},
+							},
+							"memory": map[string]interface{}{
+								"rss":  map[string]uint64{"bytes": ps.RSS},
+								"size": ps.VMS,
+								"swap": map[string]uint64{"bytes": ps.Swap},
+							},
+							"io": map[string]uint64{
+								"read_bytes":  ps.ReadBytes,
+								"write_bytes": ps.WriteBytes,
+							},
+							"fd": map[string]int{"open": ps.OpenFDs},
+							"ctx_switches": map[string]uint64{
+								"voluntary":    ps.VoluntaryCtxSwitches,
+								"nonvoluntary": ps.NonvoluntaryCtxSwitches,
+							},
+						},
+					},
+					Fields: eventFields,
+					Tags:   tags,
+				})
+			}
+		case <-shutdownChan:
+			fmt.Println("[INFO] Native metric collector shutdown complete.")
+			return
+		}
+	}
+}
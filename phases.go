@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	rate "github.com/hartfordfive/logshipper-benchmark/lib/rate"
+)
+
+// phaseCheckInterval is how often delivery is checked while waiting for the
+// shipper to start reading or to drain
+const phaseCheckInterval = 100 * time.Millisecond
+
+// defaultDrainStable is how long the backlog may stay the same before the
+// drain is given up on
+const defaultDrainStable = 10 * time.Second
+
+// Outcomes of the drain
+const (
+	drainDone        = "drained"
+	drainStable      = "stabilised"
+	drainTimeout     = "timeout"
+	drainInterrupted = "interrupted"
+	drainNotChecked  = "not checked"
+)
+
+// defaultWarmupTimeout is how long the shipper is waited for when only a
+// warm-up period is set
+const defaultWarmupTimeout = 120 * time.Second
+
+// runPhases times the phases of a run.  During the warm-up, lines are
+// written but not measured until the shipper is seen reading, and for the
+// warm-up period after that.  Writers then run for the run time, and once
+// they stop, the shipper is given until the drain timeout to deliver what's
+// left before it's shut down.
+type runPhases struct {
+	config   *BenchmarkConfig
+	lag      *lagMonitor
+	verifier *deliveryVerifier
+	target   *rate.Target
+	counters *writeCounters
+
+	measureStart   time.Time
+	measureEnd     time.Time
+	shipperSeen    bool
+	startLines     int64
+	startBytes     int64
+	startReceived  int64   // Lines received by the verifier, when measuring starts
+	endReceived    int64   // and ends
+	requestedRate  float64 // Mean of the target while writing
+	writeSeconds   float64
+	drainEnd       time.Time
+	drainOutcome   string
+	drainLines     int64 // Lines written, as of the end of the drain
+	drainDelivered int64
+	drainBacklog   int64 // Bytes left to read, as of the end of the drain
+	done           chan bool
+}
+
+// NewRunPhases returns the phases of a run.  The lag monitor, verifier and
+// target may be nil.
+func NewRunPhases(config *BenchmarkConfig, lag *lagMonitor, verifier *deliveryVerifier, target *rate.Target, counters *writeCounters) *runPhases {
+	return &runPhases{
+		config:   config,
+		lag:      lag,
+		verifier: verifier,
+		target:   target,
+		counters: counters,
+		done:     make(chan bool),
+	}
+}
+
+// Measured tells whether the results are restricted to the measurement
+// window, which is only the case when a warm-up or drain is configured
+func (p *runPhases) Measured() bool {
+	return p.config.WarmupSeconds > 0 || p.config.WarmupTimeoutSecs > 0 || p.config.DrainTimeoutSecs > 0
+}
+
+// Done returns a channel closed once every phase is over
+func (p *runPhases) Done() <-chan bool {
+	return p.done
+}
+
+// Run goes through the phases, calling stopWriters once the run time is
+// over and shutdown once the shipper is done.  Closing shutdownChan, such as
+// when a signal is caught, ends the current phase along with the run.
+func (p *runPhases) Run(stopWriters func(), shutdown func(), shutdownChan <-chan bool) {
+
+	defer close(p.done)
+	defer shutdown()
+
+	if p.config.WarmupSeconds > 0 || p.config.WarmupTimeoutSecs > 0 {
+		if !p.warmUp(shutdownChan) {
+			p.stop(stopWriters)
+			return
+		}
+	}
+	p.measureStart = time.Now()
+	p.startLines, p.startBytes = p.counters.Lines.Value(), p.counters.Bytes.Value()
+	if p.verifier != nil {
+		p.startReceived = p.verifier.Received()
+	}
+
+	if p.config.TotalRunTimeSeconds >= 1 {
+		fmt.Printf("[INFO] Running benchark for %d seconds and then exiting.\n", p.config.TotalRunTimeSeconds)
+		select {
+		case <-time.After(time.Duration(p.config.TotalRunTimeSeconds) * time.Second):
+		case <-shutdownChan:
+		}
+	} else {
+		<-shutdownChan
+	}
+	p.stop(stopWriters)
+
+	if p.config.DrainTimeoutSecs > 0 {
+		p.drain(shutdownChan)
+	}
+}
+
+// drainable tells whether the drain can be followed, which takes either the
+// delivery verifier or the lag monitor
+func (p *runPhases) drainable() bool {
+	return p.verifier != nil || p.lag != nil
+}
+
+// warmUp waits for the shipper to start reading, and then for the warm-up
+// period.  It returns false if shutdown came first.
+func (p *runPhases) warmUp(shutdownChan <-chan bool) bool {
+	timeout := time.Duration(p.config.WarmupTimeoutSecs) * time.Second
+	if timeout <= 0 {
+		timeout = defaultWarmupTimeout
+	}
+	var reading <-chan bool
+	if p.lag != nil {
+		reading = p.lag.Reading()
+	}
+
+	fmt.Printf("[INFO] Waiting up to %s for the shipper to start reading...\n", timeout)
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(phaseCheckInterval)
+	defer ticker.Stop()
+wait:
+	for {
+		select {
+		case <-reading:
+			p.shipperSeen = true
+			break wait
+		case <-ticker.C:
+			if p.verifier != nil && p.verifier.Received() > 0 {
+				p.shipperSeen = true
+				break wait
+			}
+		case <-deadline:
+			fmt.Printf("[ERROR] The shipper wasn't seen reading within %s, measuring anyway.\n", timeout)
+			break wait
+		case <-shutdownChan:
+			return false
+		}
+	}
+	if p.shipperSeen {
+		fmt.Println("[INFO] The shipper is reading.")
+	}
+
+	if p.config.WarmupSeconds > 0 {
+		fmt.Printf("[INFO] Warming up for %d seconds.\n", p.config.WarmupSeconds)
+		select {
+		case <-time.After(time.Duration(p.config.WarmupSeconds) * time.Second):
+		case <-shutdownChan:
+			return false
+		}
+	}
+	return true
+}
+
+// stop stops the writers and ends the measurement window
+func (p *runPhases) stop(stopWriters func()) {
+	stopWriters()
+	p.measureEnd = time.Now()
+	if p.verifier != nil {
+		p.endReceived = p.verifier.Received()
+	}
+	if p.target != nil {
+		p.requestedRate, p.writeSeconds = p.target.Mean(), p.target.Elapsed().Seconds()
+	}
+}
+
+// drain waits until everything written was delivered, or read when
+// delivery isn't verified.  It gives up once the backlog stopped shrinking
+// for the stable period, or at the drain timeout.
+func (p *runPhases) drain(shutdownChan <-chan bool) {
+	timeout := time.Duration(p.config.DrainTimeoutSecs) * time.Second
+	stable := time.Duration(p.config.DrainStableSecs) * time.Second
+	if stable <= 0 {
+		stable = defaultDrainStable
+	}
+	defer func() {
+		p.drainEnd = time.Now()
+		p.drainLines = p.counters.Lines.Value()
+		if p.verifier != nil {
+			p.drainDelivered = p.verifier.Delivered()
+		}
+		if p.lag != nil {
+			p.drainBacklog = p.lag.Backlog()
+		}
+	}()
+
+	if !p.drainable() {
+		p.drainOutcome = drainNotChecked
+		fmt.Println("[ERROR] Neither delivery nor the read lag are followed, the shipper isn't waited for to drain.")
+		return
+	}
+	fmt.Printf("[INFO] Writers stopped, waiting up to %s for the shipper to drain...\n", timeout)
+
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(phaseCheckInterval)
+	defer ticker.Stop()
+	progress, lastProgress := p.progress(), time.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			if p.drained() {
+				p.drainOutcome = drainDone
+				fmt.Printf("[INFO] The shipper drained in %s.\n", now.Sub(p.measureEnd))
+				return
+			}
+			if current := p.progress(); current != progress {
+				progress, lastProgress = current, now
+			} else if now.Sub(lastProgress) >= stable {
+				p.drainOutcome = drainStable
+				fmt.Printf("[ERROR] The backlog of the shipper didn't shrink for %s, giving up on the drain.\n", stable)
+				return
+			}
+		case <-deadline:
+			p.drainOutcome = drainTimeout
+			fmt.Printf("[ERROR] The shipper didn't drain within %s.\n", timeout)
+			return
+		case <-shutdownChan:
+			p.drainOutcome = drainInterrupted
+			return
+		}
+	}
+}
+
+// progress returns what changes as the shipper drains, which is the number
+// of lines delivered or the backlog left to read
+func (p *runPhases) progress() int64 {
+	if p.verifier != nil {
+		return p.verifier.Delivered()
+	}
+	if p.lag != nil {
+		return p.lag.Backlog()
+	}
+	return 0
+}
+
+// drained tells whether every line written was delivered, not counting
+// duplicates when lines are stamped, or read when delivery isn't verified
+func (p *runPhases) drained() bool {
+	if p.verifier != nil {
+		return p.verifier.Delivered() >= p.counters.Lines.Value()
+	}
+	if p.lag != nil {
+		return p.lag.Caught(p.measureEnd)
+	}
+	return false
+}
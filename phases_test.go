@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	counter "github.com/hartfordfive/logshipper-benchmark/lib/counter"
+)
+
+// newLineCounters returns counters of lines written once they counted lines
+func newLineCounters(t *testing.T, lines int64) *writeCounters {
+	c := &writeCounters{Lines: counter.NewCounter(), Bytes: counter.NewCounter(), Rotations: counter.NewCounter()}
+	c.Lines.Incr(lines)
+	for deadline := time.Now().Add(time.Second); c.Lines.Value() != lines; {
+		if time.Now().After(deadline) {
+			t.Fatalf("the counter didn't reach %d lines", lines)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return c
+}
+
+func TestDrained(t *testing.T) {
+	tests := []struct {
+		name      string
+		stamped   bool
+		received  int64
+		delivered int64
+		want      bool
+	}{
+		{"everything delivered", true, 10, 10, true},
+		{"duplicates aren't delivered lines", true, 10, 8, false},
+		{"without stamps duplicates can't be told", false, 10, 0, true},
+		{"lines left", false, 9, 0, false},
+	}
+	counters := newLineCounters(t, 10)
+	for _, tt := range tests {
+		v := &deliveryVerifier{stamped: tt.stamped, received: tt.received, delivered: tt.delivered}
+		p := NewRunPhases(&BenchmarkConfig{}, nil, v, nil, counters)
+		if got := p.drained(); got != tt.want {
+			t.Errorf("%s: drained() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDrainNotChecked(t *testing.T) {
+	p := NewRunPhases(&BenchmarkConfig{DrainTimeoutSecs: 60}, nil, nil, nil, newLineCounters(t, 10))
+	p.measureEnd = time.Now()
+	start := time.Now()
+	p.drain(make(chan bool))
+	if p.drainOutcome != drainNotChecked || time.Since(start) > time.Second {
+		t.Errorf("drain() without a verifier or a lag monitor = %q after %s, want %q right away", p.drainOutcome, time.Since(start), drainNotChecked)
+	}
+	if p.drainLines != 10 {
+		t.Errorf("drain() left %d lines written, want 10", p.drainLines)
+	}
+}
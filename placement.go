@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+
+	sched "github.com/hartfordfive/logshipper-benchmark/lib/sched"
+)
+
+// placementCheckInterval is how often processes the shipper forked are
+// given its scheduling
+const placementCheckInterval = time.Second
+
+// placementSummary is the scheduling the harness and the shipper had, so
+// runs can be reproduced
+type placementSummary struct {
+	HostCPUs int              `json:"host_cpus"`
+	Harness  sched.Placement  `json:"harness"`
+	Shipper  *sched.Placement `json:"shipper,omitempty"`
+}
+
+// cpuPlacement keeps the harness, which includes the writers and the native
+// metric collector, and the shipper on their own CPUs, with the nice and
+// ionice values of the shipper.  Processes are pinned as they're found, as
+// the affinity is inherited by threads but not changed for existing ones.
+type cpuPlacement struct {
+	harness sched.Settings
+	shipper sched.Settings
+	summary placementSummary
+	lock    sync.Mutex
+}
+
+// NewCPUPlacement returns the placement of the config, checking that the
+// CPUs are available to the harness and that they don't overlap.  When only
+// the harness is given CPUs, the shipper gets the others.
+func NewCPUPlacement(config *BenchmarkConfig) (*cpuPlacement, error) {
+	allowed, err := sched.Allowed()
+	if err != nil {
+		return nil, err
+	}
+	available := map[int]bool{}
+	for _, cpu := range allowed {
+		available[cpu] = true
+	}
+	parse := func(list string) ([]int, error) {
+		if list == "" {
+			return nil, nil
+		}
+		cpus, err := sched.ParseCPUList(list)
+		if err != nil {
+			return nil, err
+		}
+		for _, cpu := range cpus {
+			if !available[cpu] {
+				return nil, fmt.Errorf("CPU %d isn't available, the harness may run on %v", cpu, allowed)
+			}
+		}
+		return cpus, nil
+	}
+
+	cp := &cpuPlacement{summary: placementSummary{HostCPUs: runtime.NumCPU()}}
+	if cp.harness.CPUs, err = parse(config.HarnessCPUs); err != nil {
+		return nil, fmt.Errorf("harness_cpus: %s", err)
+	}
+	if cp.shipper.CPUs, err = parse(config.ShipperCPUs); err != nil {
+		return nil, fmt.Errorf("shipper_cpus: %s", err)
+	}
+	for _, h := range cp.harness.CPUs {
+		for _, s := range cp.shipper.CPUs {
+			if h == s {
+				return nil, fmt.Errorf("harness_cpus and shipper_cpus both have CPU %d", h)
+			}
+		}
+	}
+	if len(cp.harness.CPUs) > 0 && len(cp.shipper.CPUs) == 0 {
+		pinned := map[int]bool{}
+		for _, cpu := range cp.harness.CPUs {
+			pinned[cpu] = true
+		}
+		for _, cpu := range allowed {
+			if !pinned[cpu] {
+				cp.shipper.CPUs = append(cp.shipper.CPUs, cpu)
+			}
+		}
+		if len(cp.shipper.CPUs) == 0 {
+			return nil, fmt.Errorf("harness_cpus leaves no CPU to the shipper, out of %v", allowed)
+		}
+	}
+	if config.ShipperNice < -20 || config.ShipperNice > 19 {
+		return nil, fmt.Errorf("shipper_nice must be between -20 and 19")
+	}
+	if err := sched.CheckNice(config.ShipperNice); err != nil {
+		return nil, fmt.Errorf("shipper_nice: %s", err)
+	}
+	cp.shipper.Nice = config.ShipperNice
+	if config.ShipperIONice != "" {
+		if cp.shipper.IOClass, cp.shipper.IOLevel, err = sched.ParseIOPriority(config.ShipperIONice); err != nil {
+			return nil, fmt.Errorf("shipper_ionice: %s", err)
+		}
+	}
+	return cp, nil
+}
+
+// PinHarness moves every thread of the harness to its CPUs, and sizes
+// GOMAXPROCS after them unless max_procs is set.  Processes the harness
+// starts later on, such as metricbeat, inherit them.
+func (cp *cpuPlacement) PinHarness(maxProcs int) error {
+	if len(cp.harness.CPUs) > 0 {
+		if err := sched.Apply(os.Getpid(), cp.harness); err != nil {
+			return err
+		}
+		if maxProcs <= 0 {
+			runtime.GOMAXPROCS(len(cp.harness.CPUs))
+		}
+	}
+	p, err := sched.Effective(os.Getpid())
+	cp.lock.Lock()
+	cp.summary.Harness = p
+	cp.lock.Unlock()
+	return err
+}
+
+// PinShipper gives its scheduling to every process in the process group of
+// the shipper
+func (cp *cpuPlacement) PinShipper(pgid int) {
+	configured := len(cp.shipper.CPUs) > 0 || cp.shipper.Nice != 0 || cp.shipper.IOClass > 0
+	for _, pid := range sched.ProcessGroup(pgid) {
+		if configured {
+			if err := sched.Apply(pid, cp.shipper); err != nil {
+				fmt.Printf("[ERROR] Could not schedule the shipper: %s\n", err)
+			}
+		}
+	}
+	if p, err := sched.Effective(pgid); err == nil {
+		cp.lock.Lock()
+		cp.summary.Shipper = &p
+		cp.lock.Unlock()
+	}
+}
+
+// Run pins the processes the shipper forks until shutdown
+func (cp *cpuPlacement) Run(supervisor *shipperSupervisor, shutdownChan <-chan bool, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	ticker := time.NewTicker(placementCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cmd, _ := supervisor.Current()
+			if cmd.Process == nil {
+				continue
+			}
+			if pgid, err := syscall.Getpgid(cmd.Process.Pid); err == nil {
+				cp.PinShipper(pgid)
+			}
+		case <-shutdownChan:
+			return
+		}
+	}
+}
+
+// Summary returns the scheduling last seen
+func (cp *cpuPlacement) Summary() *placementSummary {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	summary := cp.summary
+	return &summary
+}
+
+// describePlacement returns the scheduling of a process in a line, such as
+// for the report
+func describePlacement(p *sched.Placement) string {
+	return fmt.Sprintf("CPUs %s, nice %d, ionice %s", p.CPUs, p.Nice, p.IOPriority)
+}
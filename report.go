@@ -0,0 +1,517 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	utils "github.com/hartfordfive/logshipper-benchmark/lib"
+	rate "github.com/hartfordfive/logshipper-benchmark/lib/rate"
+	shipperlog "github.com/hartfordfive/logshipper-benchmark/lib/shipperlog"
+)
+
+// reportSchemaVersion must be increased whenever fields of the JSON or CSV
+// report are renamed, removed, moved or change meaning.  Adding fields doesn't
+// require it, as long as CSV columns are added at the end.
+const reportSchemaVersion = 3
+
+// embeddedBrokerSummary holds what the embedded broker received during the run
+type embeddedBrokerSummary struct {
+	Addr    string `json:"addr"`
+	Records int64  `json:"records"`
+	Bytes   int64  `json:"bytes"`
+}
+
+// measurementSummary holds what was written during the measurement window,
+// which excludes the warm-up and drain, along with what was received when
+// delivery is verified
+type measurementSummary struct {
+	WarmupSeconds  float64 `json:"warmup_seconds"`
+	ShipperReading bool    `json:"shipper_reading"`
+	Seconds        float64 `json:"seconds"`
+	LinesWritten   int64   `json:"lines_written"`
+	BytesWritten   int64   `json:"bytes_written"`
+	LinesReceived  *int64  `json:"lines_received,omitempty"`
+}
+
+// drainSummary holds how the shipper drained once writers stopped.  Lines
+// delivered are only known when delivery is verified, and the backlog when
+// the lag monitor ran.
+type drainSummary struct {
+	Seconds        float64  `json:"seconds"`
+	Outcome        string   `json:"outcome"`
+	LinesWritten   int64    `json:"lines_written"`
+	LinesDelivered *int64   `json:"lines_delivered,omitempty"`
+	DeliveredPct   *float64 `json:"delivered_pct,omitempty"`
+	BacklogBytes   *int64   `json:"backlog_bytes,omitempty"`
+}
+
+// rateSummary compares the rate achieved by the writers to the target
+type rateSummary struct {
+	Unit      string  `json:"unit"`
+	Requested float64 `json:"requested"`
+	Achieved  float64 `json:"achieved"`
+}
+
+type shipperInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Module  string `json:"module"`
+	Pid     int    `json:"pid"`
+}
+
+// benchmarkReport holds the results of a single benchmark run
+type benchmarkReport struct {
+	SchemaVersion    int                       `json:"schema_version"`
+	Shipper          shipperInfo               `json:"shipper"`
+	Config           *BenchmarkConfig          `json:"config"`
+	StartTime        time.Time                 `json:"start_time"`
+	EndTime          time.Time                 `json:"end_time"`
+	TotalSeconds     float64                   `json:"total_seconds"`
+	Failure          *failureSummary           `json:"failure,omitempty"`
+	SampleLogEntry   string                    `json:"sample_log_entry"`
+	LinesWritten     int64                     `json:"lines_written"`
+	BytesWritten     int64                     `json:"bytes_written"`
+	FilesWritten     int                       `json:"files_written"`
+	Rotations        int64                     `json:"rotations"`
+	Measurement      *measurementSummary       `json:"measurement,omitempty"`
+	Drain            *drainSummary             `json:"drain,omitempty"`
+	Rate             *rateSummary              `json:"rate,omitempty"`
+	LinesPerSecond   float64                   `json:"lines_per_second"`
+	BytesPerSecond   float64                   `json:"bytes_per_second"`
+	MetricsDataFile  string                    `json:"metrics_data_file"`
+	LoadTimelineFile string                    `json:"load_timeline_file,omitempty"`
+	Lag              *lagSummary               `json:"lag,omitempty"`
+	Delivery         *deliverySummary          `json:"delivery,omitempty"`
+	Restarts         []*restartRecord          `json:"restarts,omitempty"`
+	EmbeddedBroker   *embeddedBrokerSummary    `json:"embedded_broker,omitempty"`
+	Sink             *sinkSummary              `json:"sink,omitempty"`
+	Resources        *utils.ProcessTreeSummary `json:"resources,omitempty"`
+	Cgroup           *cgroupSummary            `json:"cgroup,omitempty"`
+	Placement        *placementSummary         `json:"placement,omitempty"`
+	ShipperOutput    *shipperlog.Summary       `json:"shipper_output,omitempty"`
+}
+
+func newBenchmarkReport(config *BenchmarkConfig, shipper Shipper, pid int, startTime time.Time, totalSeconds float64) *benchmarkReport {
+	return &benchmarkReport{
+		SchemaVersion: reportSchemaVersion,
+		Shipper: shipperInfo{
+			Name:    config.LogShipperName,
+			Version: shipper.GetVersion(),
+			Module:  config.ModuleName,
+			Pid:     pid,
+		},
+		Config:       config,
+		StartTime:    startTime,
+		EndTime:      startTime.Add(time.Duration(totalSeconds * float64(time.Second))),
+		TotalSeconds: totalSeconds,
+		FilesWritten: config.NumActiveLogFiles,
+	}
+}
+
+// setLineCounts sets the amount of data written along with the rates
+func (r *benchmarkReport) setLineCounts(linesWritten int64, bytesWritten int64) {
+	r.LinesWritten = linesWritten
+	r.BytesWritten = bytesWritten
+	if r.TotalSeconds > 0 {
+		r.LinesPerSecond = float64(linesWritten) / r.TotalSeconds
+		r.BytesPerSecond = float64(bytesWritten) / r.TotalSeconds
+	}
+}
+
+// setMeasurement restricts the rates to the measurement window of the run,
+// given the totals written over the whole run
+func (r *benchmarkReport) setMeasurement(p *runPhases, linesWritten int64, bytesWritten int64) {
+	if p.measureStart.IsZero() {
+		// The run was interrupted during the warm-up
+		return
+	}
+	m := &measurementSummary{
+		WarmupSeconds:  p.measureStart.Sub(r.StartTime).Seconds(),
+		ShipperReading: p.shipperSeen,
+		Seconds:        p.measureEnd.Sub(p.measureStart).Seconds(),
+		LinesWritten:   linesWritten - p.startLines,
+		BytesWritten:   bytesWritten - p.startBytes,
+	}
+	if p.verifier != nil {
+		received := p.endReceived - p.startReceived
+		m.LinesReceived = &received
+	}
+	r.Measurement = m
+	if m.Seconds > 0 {
+		r.LinesPerSecond = float64(m.LinesWritten) / m.Seconds
+		r.BytesPerSecond = float64(m.BytesWritten) / m.Seconds
+	}
+}
+
+// setDrain sets how long the shipper took to drain, and how much of what
+// was written it delivered by then
+func (r *benchmarkReport) setDrain(p *runPhases) {
+	if p.drainOutcome == "" {
+		return
+	}
+	d := &drainSummary{
+		Seconds:      p.drainEnd.Sub(p.measureEnd).Seconds(),
+		Outcome:      p.drainOutcome,
+		LinesWritten: p.drainLines,
+	}
+	if p.verifier != nil {
+		delivered := p.drainDelivered
+		pct := 100.0
+		if p.drainLines > 0 {
+			pct = float64(delivered) / float64(p.drainLines) * 100
+		}
+		d.LinesDelivered, d.DeliveredPct = &delivered, &pct
+	}
+	if p.lag != nil {
+		backlog := p.drainBacklog
+		d.BacklogBytes = &backlog
+	}
+	r.Drain = d
+}
+
+// setRate sets the rate achieved, in lines/s or MB/s, along with the
+// requested one, which is the mean of the target over the run.  The achieved
+// rate is over the seconds the writers ran for, which excludes the start of
+// the shipper.
+func (r *benchmarkReport) setRate(unit string, requested float64, seconds float64) {
+	if seconds <= 0 {
+		seconds = r.TotalSeconds
+	}
+	if unit == rate.Bytes {
+		r.Rate = &rateSummary{Unit: "MB/s", Requested: requested / (1024 * 1024), Achieved: float64(r.BytesWritten) / seconds / (1024 * 1024)}
+	} else {
+		r.Rate = &rateSummary{Unit: "lines/s", Requested: requested, Achieved: float64(r.LinesWritten) / seconds}
+	}
+}
+
+// deliveredLinesPerSecond is the rate at which the shipper delivered lines,
+// which is only known when delivery is verified.  Like the rate lines are
+// written at, it's over the measurement window when there's one.
+func (r *benchmarkReport) deliveredLinesPerSecond() (float64, bool) {
+	if r.Delivery == nil {
+		return 0, false
+	}
+	if m := r.Measurement; m != nil && m.LinesReceived != nil {
+		if m.Seconds <= 0 {
+			return 0, false
+		}
+		return float64(*m.LinesReceived) / m.Seconds, true
+	}
+	if r.TotalSeconds <= 0 {
+		return 0, false
+	}
+	return float64(r.Delivery.LinesReceived) / r.TotalSeconds, true
+}
+
+func generateBenchmarkResults(r *benchmarkReport) string {
+
+	var buffer bytes.Buffer
+	buffer.WriteString("\n----------------------- Test Results ---------------------\n")
+	if r.Failure != nil {
+		buffer.WriteString(fmt.Sprintf("RUN FAILED:               %s\n", r.Failure.Reason))
+	}
+	buffer.WriteString(fmt.Sprintf("Log Shipper:              %s\n", r.Shipper.Name))
+	buffer.WriteString(fmt.Sprintf("Shipper Version:          %s\n", r.Shipper.Version))
+	buffer.WriteString(fmt.Sprintf("PID:                      %d\n", r.Shipper.Pid))
+	buffer.WriteString(fmt.Sprintf("Start Time:               %s\n", r.StartTime.Format(time.RFC3339)))
+	buffer.WriteString(fmt.Sprintf("End Time:                 %s\n", r.EndTime.Format(time.RFC3339)))
+	buffer.WriteString(fmt.Sprintf("Total Time (s):           %f\n", r.TotalSeconds))
+	if r.Config.LogFormat != "" {
+		buffer.WriteString(fmt.Sprintf("Log Format:               %s\n", r.Config.LogFormat))
+	}
+	if len(r.Config.ReplayFiles) > 0 {
+		timing := r.Config.ReplayTiming
+		if timing == "" {
+			timing = replayTimingRate
+		}
+		buffer.WriteString(fmt.Sprintf("Replayed Files:           %s\n", strings.Join(r.Config.ReplayFiles, ", ")))
+		buffer.WriteString(fmt.Sprintf("Replay Timing:            %s\n", timing))
+	} else {
+		buffer.WriteString(fmt.Sprintf("Sample Log Entry:         %s\n", r.SampleLogEntry))
+	}
+	buffer.WriteString(fmt.Sprintf("Write Wait Period (ms):   %d\n", r.Config.WriteWaitPeriodMs))
+	buffer.WriteString(fmt.Sprintf("Total Lines Written:      %d\n", r.LinesWritten))
+	buffer.WriteString(fmt.Sprintf("Total Bytes Written:      %d\n", r.BytesWritten))
+	buffer.WriteString(fmt.Sprintf("Total Files Written:      %d\n", r.FilesWritten))
+	if mode := r.Config.RotationMode; mode != "" && mode != rotationNone {
+		buffer.WriteString(fmt.Sprintf("Rotation Mode:            %s\n", mode))
+		buffer.WriteString(fmt.Sprintf("Total Rotations:          %d\n", r.Rotations))
+	}
+	if m := r.Measurement; m != nil {
+		buffer.WriteString(fmt.Sprintf("Warm-up Time (s):         %f\n", m.WarmupSeconds))
+		buffer.WriteString(fmt.Sprintf("Measured Time (s):        %f\n", m.Seconds))
+		buffer.WriteString(fmt.Sprintf("Measured Lines Written:   %d\n", m.LinesWritten))
+		if m.LinesReceived != nil {
+			buffer.WriteString(fmt.Sprintf("Measured Lines Received:  %d\n", *m.LinesReceived))
+		}
+	}
+	buffer.WriteString(fmt.Sprintf("Calculated lines/s:       %d\n", int64(r.LinesPerSecond)))
+	if rs := r.Rate; rs != nil {
+		buffer.WriteString(fmt.Sprintf("Requested Rate:           %.2f %s\n", rs.Requested, rs.Unit))
+		achievedPct := 0.0
+		if rs.Requested > 0 {
+			achievedPct = rs.Achieved / rs.Requested * 100
+		}
+		buffer.WriteString(fmt.Sprintf("Achieved Rate:            %.2f %s (%.1f%%)\n", rs.Achieved, rs.Unit, achievedPct))
+	}
+	if d := r.Drain; d != nil {
+		buffer.WriteString(fmt.Sprintf("Drain Outcome:            %s\n", d.Outcome))
+		buffer.WriteString(fmt.Sprintf("Time to Drain (s):        %f\n", d.Seconds))
+		if d.DeliveredPct != nil {
+			buffer.WriteString(fmt.Sprintf("Delivered after Drain:    %d (%.2f%%)\n", *d.LinesDelivered, *d.DeliveredPct))
+		}
+		if d.BacklogBytes != nil {
+			buffer.WriteString(fmt.Sprintf("Backlog after Drain:      %d bytes\n", *d.BacklogBytes))
+		}
+	}
+	if lag := r.Lag; lag != nil {
+		buffer.WriteString(fmt.Sprintf("Read Lag max (bytes):     %d\n", lag.MaxBytes))
+		buffer.WriteString(fmt.Sprintf("Read Lag final (bytes):   %d\n", lag.FinalBytes))
+	}
+	if delivery := r.Delivery; delivery != nil {
+		buffer.WriteString(fmt.Sprintf("Lines Received:           %d\n", delivery.LinesReceived))
+		buffer.WriteString(fmt.Sprintf("Lines Lost:               %d\n", delivery.LinesLost))
+		buffer.WriteString(fmt.Sprintf("Lines Duplicated:         %d\n", delivery.LinesDuplicated))
+		buffer.WriteString(fmt.Sprintf("Bytes Received:           %d\n", delivery.BytesReceived))
+		if delivered, ok := r.deliveredLinesPerSecond(); ok {
+			buffer.WriteString(fmt.Sprintf("Delivered lines/s:        %d\n", int64(delivered)))
+		}
+		if delivery.Stamped {
+			buffer.WriteString(fmt.Sprintf("Lines Unstamped:          %d\n", delivery.LinesUnstamped))
+			buffer.WriteString(fmt.Sprintf("Latency p50:              %s\n", delivery.LatencyP50))
+			buffer.WriteString(fmt.Sprintf("Latency p90:              %s\n", delivery.LatencyP90))
+			buffer.WriteString(fmt.Sprintf("Latency p99:              %s\n", delivery.LatencyP99))
+			buffer.WriteString(fmt.Sprintf("Latency max:              %s\n", delivery.LatencyMax))
+		}
+		if delivery.DecodeErrors > 0 {
+			buffer.WriteString(fmt.Sprintf("Decode Errors:            %d\n", delivery.DecodeErrors))
+		}
+	}
+	for i, rr := range r.Restarts {
+		restart := fmt.Sprintf("pid %d -> %d, exited in %.3fs, down for %.3fs", rr.OldPid, rr.NewPid, rr.ExitSeconds, rr.DowntimeSeconds)
+		if rr.NewPid == 0 {
+			restart = fmt.Sprintf("pid %d, not restarted before shutdown", rr.OldPid)
+		}
+		buffer.WriteString(fmt.Sprintf("Restart #%d:               %s at %.1fs, %s\n", i+1, rr.Signal, rr.AtSeconds, restart))
+	}
+	if broker := r.EmbeddedBroker; broker != nil {
+		buffer.WriteString(fmt.Sprintf("Embedded Kafka Broker:    %s\n", broker.Addr))
+		buffer.WriteString(fmt.Sprintf("Records Produced:         %d\n", broker.Records))
+		buffer.WriteString(fmt.Sprintf("Record Bytes Produced:    %d\n", broker.Bytes))
+	}
+	if sink := r.Sink; sink != nil {
+		buffer.WriteString(fmt.Sprintf("Sink Rejected Sets:       %d of %d requests\n", sink.RejectedSets, sink.ProduceRequests))
+		buffer.WriteString(fmt.Sprintf("Sink Disconnects:         %d\n", sink.Disconnects))
+		buffer.WriteString(fmt.Sprintf("Sink Delay (s):           %f\n", sink.DelayedSeconds))
+		for i, w := range sink.Windows {
+			recovery := "not recovered"
+			if w.RecoverySeconds != nil {
+				recovery = fmt.Sprintf("recovered in %.1fs", *w.RecoverySeconds)
+			}
+			buffer.WriteString(fmt.Sprintf("Sink Window #%d:           %s %ds-%ds, max backlog %d lines, max state %d bytes, %s\n",
+				i+1, w.State, w.StartSeconds, w.EndSeconds, w.MaxBacklogLines, w.MaxStateDirBytes, recovery))
+		}
+	}
+	if res := r.Resources; res != nil {
+		buffer.WriteString(fmt.Sprintf("Shipper CPU %% (avg/max): %.2f / %.2f\n", res.CPUPctAvg, res.CPUPctMax))
+		buffer.WriteString(fmt.Sprintf("Shipper RSS (avg/max):    %d / %d\n", res.RSSBytesAvg, res.RSSBytesMax))
+		buffer.WriteString(fmt.Sprintf("Shipper Processes (max):  %d\n", res.MaxProcesses))
+	}
+	if p := r.Placement; p != nil {
+		buffer.WriteString(fmt.Sprintf("Host CPUs:                %d\n", p.HostCPUs))
+		buffer.WriteString(fmt.Sprintf("Harness Placement:        %s\n", describePlacement(&p.Harness)))
+		if p.Shipper != nil {
+			buffer.WriteString(fmt.Sprintf("Shipper Placement:        %s\n", describePlacement(p.Shipper)))
+		}
+	}
+	if cg := r.Cgroup; cg != nil {
+		buffer.WriteString(fmt.Sprintf("Cgroup:                   %s\n", cg.Path))
+		buffer.WriteString(fmt.Sprintf("Cgroup Limits:            %s\n", describeCgroupLimits(cg.Limits)))
+		buffer.WriteString(fmt.Sprintf("Cgroup CPU %% (avg):       %.2f\n", cg.CPUPctAvg))
+		buffer.WriteString(fmt.Sprintf("Cgroup CPU Throttled:     %.2f%% of periods, %.3fs\n", cg.ThrottledPct, cg.CPUThrottledSecs))
+		buffer.WriteString(fmt.Sprintf("Cgroup Memory (max):      %d\n", cg.MemoryMaxBytes))
+		buffer.WriteString(fmt.Sprintf("Cgroup Memory Events:     %d max, %d oom, %d oom_kill\n", cg.MemoryMaxEvents, cg.MemoryOOMEvents, cg.MemoryOOMKills))
+		buffer.WriteString(fmt.Sprintf("Cgroup IO (read/write):   %d / %d\n", cg.IOReadBytes, cg.IOWriteBytes))
+	}
+	if out := r.ShipperOutput; out != nil {
+		buffer.WriteString(fmt.Sprintf("Shipper Output Lines:     %d\n", out.Lines))
+		buffer.WriteString(fmt.Sprintf("Shipper Errors/Warnings:  %d / %d\n", out.ErrorLines, out.WarningLines))
+		if out.FirstError != "" {
+			buffer.WriteString(fmt.Sprintf("First Shipper Error:      %s\n", out.FirstError))
+		}
+		buffer.WriteString(fmt.Sprintf("Shipper output file:      %s\n", out.File))
+	}
+	buffer.WriteString(fmt.Sprintf("Metrics data file:        %s\n", r.MetricsDataFile))
+	if r.LoadTimelineFile != "" {
+		buffer.WriteString(fmt.Sprintf("Load timeline file:       %s\n", r.LoadTimelineFile))
+	}
+	if r.Lag != nil {
+		buffer.WriteString(fmt.Sprintf("Lag timeline file:        %s\n", r.Lag.TimelineFile))
+	}
+	if r.Sink != nil {
+		buffer.WriteString(fmt.Sprintf("Sink timeline file:       %s\n", r.Sink.TimelineFile))
+	}
+	if r.Cgroup != nil {
+		buffer.WriteString(fmt.Sprintf("Cgroup timeline file:     %s\n", r.Cgroup.TimelineFile))
+	}
+	buffer.WriteString("----------------------------------------------------------\n")
+	if out := r.ShipperOutput; out != nil && len(out.LastLines) > 0 {
+		buffer.WriteString(fmt.Sprintf("Last %d lines of shipper output:\n", len(out.LastLines)))
+		for _, line := range out.LastLines {
+			buffer.WriteString(fmt.Sprintf("\t%s\n", line))
+		}
+		buffer.WriteString("----------------------------------------------------------\n")
+	}
+	return buffer.String()
+}
+
+func (r *benchmarkReport) JSON() (string, error) {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b) + "\n", nil
+}
+
+// CSV returns a header line and a single row holding the main results, so
+// reports of several runs can easily be combined in a spreadsheet.
+func (r *benchmarkReport) CSV() (string, error) {
+	header, row := r.csvRecords()
+	var buffer bytes.Buffer
+	w := csv.NewWriter(&buffer)
+	w.Write(header)
+	w.Write(row)
+	w.Flush()
+	return buffer.String(), w.Error()
+}
+
+func (r *benchmarkReport) csvRecords() (header []string, row []string) {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', 3, 64) }
+	i := func(v int64) string { return strconv.FormatInt(v, 10) }
+
+	header = []string{
+		"schema_version", "shipper_name", "shipper_version", "module_name", "start_time", "end_time", "total_seconds",
+		"log_line_size", "num_active_log_files", "write_wait_period_ms", "lines_written", "bytes_written",
+		"lines_per_second", "bytes_per_second",
+	}
+	row = []string{
+		strconv.Itoa(r.SchemaVersion), r.Shipper.Name, r.Shipper.Version, r.Shipper.Module,
+		r.StartTime.Format(time.RFC3339), r.EndTime.Format(time.RFC3339), f(r.TotalSeconds),
+		strconv.Itoa(r.Config.LogLineSize), strconv.Itoa(r.FilesWritten), strconv.Itoa(r.Config.WriteWaitPeriodMs),
+		i(r.LinesWritten), i(r.BytesWritten), f(r.LinesPerSecond), f(r.BytesPerSecond),
+	}
+
+	// Optional sections always have their columns, left empty, so rows of
+	// different runs line up.
+	header = append(header, "lines_received", "lines_lost", "lines_duplicated", "latency_p50_ms", "latency_p90_ms", "latency_p99_ms", "latency_max_ms")
+	if d := r.Delivery; d != nil {
+		ms := func(v time.Duration) string {
+			if !d.Stamped {
+				return ""
+			}
+			return f(v.Seconds() * 1000)
+		}
+		row = append(row, i(d.LinesReceived), i(d.LinesLost), i(d.LinesDuplicated), ms(d.LatencyP50), ms(d.LatencyP90), ms(d.LatencyP99), ms(d.LatencyMax))
+	} else {
+		row = append(row, "", "", "", "", "", "", "")
+	}
+	header = append(header, "broker_records")
+	if b := r.EmbeddedBroker; b != nil {
+		row = append(row, i(b.Records))
+	} else {
+		row = append(row, "")
+	}
+	header = append(header, "cpu_pct_avg", "cpu_pct_max", "rss_bytes_avg", "rss_bytes_max", "max_processes")
+	if res := r.Resources; res != nil {
+		row = append(row, f(res.CPUPctAvg), f(res.CPUPctMax), strconv.FormatUint(res.RSSBytesAvg, 10), strconv.FormatUint(res.RSSBytesMax, 10), strconv.Itoa(res.MaxProcesses))
+	} else {
+		row = append(row, "", "", "", "", "")
+	}
+	header = append(header, "rotation_mode", "rotations")
+	row = append(row, r.Config.RotationMode, i(r.Rotations))
+	header = append(header, "rate_unit", "rate_requested", "rate_achieved")
+	if rs := r.Rate; rs != nil {
+		row = append(row, rs.Unit, f(rs.Requested), f(rs.Achieved))
+	} else {
+		row = append(row, "", "", "")
+	}
+	header = append(header, "lag_max_bytes", "lag_final_bytes")
+	if l := r.Lag; l != nil {
+		row = append(row, i(l.MaxBytes), i(l.FinalBytes))
+	} else {
+		row = append(row, "", "")
+	}
+	header = append(header, "warmup_seconds", "measured_seconds")
+	if m := r.Measurement; m != nil {
+		row = append(row, f(m.WarmupSeconds), f(m.Seconds))
+	} else {
+		row = append(row, "", "")
+	}
+	header = append(header, "drain_outcome", "drain_seconds", "drain_delivered_pct")
+	if d := r.Drain; d != nil {
+		pct := ""
+		if d.DeliveredPct != nil {
+			pct = f(*d.DeliveredPct)
+		}
+		row = append(row, d.Outcome, f(d.Seconds), pct)
+	} else {
+		row = append(row, "", "", "")
+	}
+	header = append(header, "shipper_error_lines", "shipper_warning_lines")
+	if out := r.ShipperOutput; out != nil {
+		row = append(row, i(out.ErrorLines), i(out.WarningLines))
+	} else {
+		row = append(row, "", "")
+	}
+	header = append(header, "failure")
+	if r.Failure != nil {
+		row = append(row, r.Failure.Reason)
+	} else {
+		row = append(row, "")
+	}
+	header = append(header, "restarts")
+	row = append(row, strconv.Itoa(len(r.Restarts)))
+	header = append(header, "sink_rejected_sets", "sink_disconnects", "sink_max_recovery_seconds")
+	if s := r.Sink; s != nil {
+		// Empty when the shipper didn't recover from every window
+		recovery := 0.0
+		for _, w := range s.Windows {
+			if w.RecoverySeconds == nil {
+				recovery = -1
+				break
+			}
+			if *w.RecoverySeconds > recovery {
+				recovery = *w.RecoverySeconds
+			}
+		}
+		recoveryCol := ""
+		if recovery >= 0 {
+			recoveryCol = f(recovery)
+		}
+		row = append(row, i(s.RejectedSets), i(s.Disconnects), recoveryCol)
+	} else {
+		row = append(row, "", "", "")
+	}
+	header = append(header, "cgroup_cpu_pct_avg", "cgroup_throttled_pct", "cgroup_memory_max_bytes", "cgroup_oom_kills")
+	if cg := r.Cgroup; cg != nil {
+		row = append(row, f(cg.CPUPctAvg), f(cg.ThrottledPct), strconv.FormatUint(cg.MemoryMaxBytes, 10), strconv.FormatUint(cg.MemoryOOMKills, 10))
+	} else {
+		row = append(row, "", "", "", "")
+	}
+	header = append(header, "harness_cpus", "shipper_cpus")
+	if p := r.Placement; p != nil {
+		shipperCPUs := ""
+		if p.Shipper != nil {
+			shipperCPUs = p.Shipper.CPUs
+		}
+		row = append(row, p.Harness.CPUs, shipperCPUs)
+	} else {
+		row = append(row, "", "")
+	}
+	return header, row
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateBenchmarkResultsShortRun(t *testing.T) {
+	tests := []struct {
+		name         string
+		totalSeconds float64
+		want         []string
+		notWant      []string
+	}{
+		{
+			// A shipper exiting right away rounds the run down to no second
+			name:         "under a second",
+			totalSeconds: 0.2,
+			want:         []string{"RUN FAILED:               shipper exited", "Calculated lines/s:       15\n", "Delivered lines/s:        5\n"},
+		},
+		{
+			name:    "no time at all",
+			want:    []string{"Calculated lines/s:       0\n"},
+			notWant: []string{"Delivered lines/s:"},
+		},
+	}
+	for _, tt := range tests {
+		r := &benchmarkReport{
+			Config:       &BenchmarkConfig{},
+			StartTime:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			TotalSeconds: tt.totalSeconds,
+			Failure:      &failureSummary{Reason: "shipper exited", AfterSeconds: tt.totalSeconds},
+			Delivery:     &deliverySummary{LinesReceived: 1},
+		}
+		r.setLineCounts(3, 300)
+		results := generateBenchmarkResults(r)
+		for _, s := range tt.want {
+			if !strings.Contains(results, s) {
+				t.Errorf("%s: results don't contain %q:\n%s", tt.name, s, results)
+			}
+		}
+		for _, s := range tt.notWant {
+			if strings.Contains(results, s) {
+				t.Errorf("%s: results contain %q:\n%s", tt.name, s, results)
+			}
+		}
+		if _, err := r.CSV(); err != nil {
+			t.Errorf("%s: CSV() = %v", tt.name, err)
+		}
+	}
+}
+
+func TestDeliveredLinesPerSecond(t *testing.T) {
+	measured := int64(100)
+	tests := []struct {
+		name   string
+		report benchmarkReport
+		want   float64
+		known  bool
+	}{
+		{"not verified", benchmarkReport{TotalSeconds: 10}, 0, false},
+		{"whole run", benchmarkReport{TotalSeconds: 10, Delivery: &deliverySummary{LinesReceived: 500}}, 50, true},
+		{
+			// Lines received during the warm-up and drain are left out
+			"measurement window",
+			benchmarkReport{TotalSeconds: 10, Delivery: &deliverySummary{LinesReceived: 500}, Measurement: &measurementSummary{Seconds: 4, LinesReceived: &measured}},
+			25, true,
+		},
+		{
+			"window without lines received",
+			benchmarkReport{TotalSeconds: 10, Delivery: &deliverySummary{LinesReceived: 500}, Measurement: &measurementSummary{Seconds: 4}},
+			50, true,
+		},
+		{
+			"empty measurement window",
+			benchmarkReport{TotalSeconds: 10, Delivery: &deliverySummary{LinesReceived: 500}, Measurement: &measurementSummary{LinesReceived: &measured}},
+			0, false,
+		},
+	}
+	for _, tt := range tests {
+		got, known := tt.report.deliveredLinesPerSecond()
+		if got != tt.want || known != tt.known {
+			t.Errorf("%s: deliveredLinesPerSecond() = %g, %v, want %g, %v", tt.name, got, known, tt.want, tt.known)
+		}
+	}
+}
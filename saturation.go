@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	utils "github.com/hartfordfive/logshipper-benchmark/lib"
+	rate "github.com/hartfordfive/logshipper-benchmark/lib/rate"
+)
+
+// SaturationConfig declares a search for the highest rate each shipper
+// sustains.  The rate is multiplied by the step factor until the shipper
+// falls behind, and the range between the last rate sustained and the first
+// one which wasn't is then bisected.
+type SaturationConfig struct {
+	ShipperConfigs     []string `json:"shipper_configs"`
+	Unit               string   `json:"unit"`
+	StartRate          float64  `json:"start_rate"`
+	MaxRate            float64  `json:"max_rate"`
+	StepFactor         float64  `json:"step_factor"`
+	RefineSteps        int      `json:"refine_steps"`
+	TolerancePct       float64  `json:"tolerance_pct"`
+	StepRunTimeSeconds int64    `json:"step_run_time_seconds"`
+	SettleSeconds      int      `json:"settle_seconds"`
+	CooldownSeconds    int      `json:"cooldown_seconds"`
+	OutputDir          string   `json:"output_dir"`
+}
+
+// saturationStep is the run of a shipper at a given rate
+type saturationStep struct {
+	Rate       float64 `json:"rate"`
+	Written    float64 `json:"written_lines_per_second"`
+	Delivered  float64 `json:"delivered_lines_per_second"`
+	Sustained  bool    `json:"sustained"`
+	Reason     string  `json:"reason,omitempty"`
+	ReportPath string  `json:"report_path,omitempty"`
+}
+
+// saturationResult holds the steps run for a shipper config, along with the
+// highest rate it sustained
+type saturationResult struct {
+	ShipperConfig string            `json:"shipper_config"`
+	Shipper       string            `json:"shipper"`
+	Unit          string            `json:"unit"`
+	MaxSustained  float64           `json:"max_sustained_rate"`
+	Limit         string            `json:"limit"`
+	Steps         []*saturationStep `json:"steps"`
+}
+
+func LoadSaturationConfig(confPath string) *SaturationConfig {
+	byteValue, err := ioutil.ReadFile(confPath)
+	if err != nil {
+		fmt.Printf("[ERROR] Could not read %s: %s\n", confPath, err)
+		os.Exit(1)
+	}
+	var sc SaturationConfig
+	if err := json.Unmarshal(byteValue, &sc); err != nil {
+		fmt.Println("[ERROR] Could not parse JSON: ", err)
+		os.Exit(1)
+	}
+	if len(sc.ShipperConfigs) == 0 {
+		fmt.Println("[ERROR] The search must list at least one shipper config in shipper_configs")
+		os.Exit(1)
+	}
+	if sc.OutputDir == "" {
+		fmt.Println("[ERROR] The search must set output_dir")
+		os.Exit(1)
+	}
+	if sc.Unit == "" {
+		sc.Unit = rate.ProfileLines
+	}
+	if sc.Unit != rate.ProfileLines && sc.Unit != rate.ProfileMb {
+		fmt.Printf("[ERROR] Unknown unit: %s (must be %s or %s)\n", sc.Unit, rate.ProfileLines, rate.ProfileMb)
+		os.Exit(1)
+	}
+	if sc.StartRate <= 0 {
+		fmt.Println("[ERROR] The search must set start_rate")
+		os.Exit(1)
+	}
+	if sc.StepFactor <= 1 {
+		sc.StepFactor = 2
+	}
+	if sc.RefineSteps < 0 {
+		sc.RefineSteps = 0
+	} else if sc.RefineSteps == 0 {
+		sc.RefineSteps = 3
+	}
+	if sc.TolerancePct <= 0 {
+		sc.TolerancePct = 5
+	}
+	if sc.StepRunTimeSeconds <= 0 {
+		sc.StepRunTimeSeconds = 60
+	}
+	if sc.SettleSeconds <= 0 {
+		sc.SettleSeconds = int(sc.StepRunTimeSeconds / 3)
+	}
+	return &sc
+}
+
+// rateUnit returns how rates of the search are displayed
+func (sc *SaturationConfig) rateUnit() string {
+	if sc.Unit == rate.ProfileMb {
+		return "MB/s"
+	}
+	return "lines/s"
+}
+
+// stepCell returns the run of a shipper config at the given rate.  Delivery
+// is always verified, as the delivered rate tells whether the shipper keeps up.
+func (sc *SaturationConfig) stepCell(confPath string, base *BenchmarkConfig, stepRate float64, index int) *suiteCell {
+	name := strings.TrimSuffix(filepath.Base(confPath), filepath.Ext(confPath))
+	conf := *base
+	conf.TargetLinesPerSecond, conf.TargetMbPerSecond, conf.LoadProfile = 0, 0, nil
+	if sc.Unit == rate.ProfileMb {
+		conf.TargetMbPerSecond = stepRate
+	} else {
+		conf.TargetLinesPerSecond = stepRate
+	}
+	conf.TotalRunTimeSeconds = sc.StepRunTimeSeconds
+	conf.Repetitions = 0
+	conf.VerifyDelivery = true
+
+	combination := fmt.Sprintf("%s_%g%s", name, stepRate, sc.Unit)
+	id := fmt.Sprintf("%03d_%s", index, combination)
+	dir := fmt.Sprintf("%s/%s", strings.TrimRight(sc.OutputDir, "/"), id)
+	conf.WorkingDir = dir
+	conf.MetricsDir = dir + "/metrics"
+	return &suiteCell{ID: id, Combination: combination, ShipperConfig: confPath, Dir: dir, Config: &conf}
+}
+
+// judgeStep tells whether the shipper kept up during a step, from the load
+// timeline once the step settled.  The writers must reach the rate, and the
+// shipper must deliver what was written, both within the tolerance.  An
+// error is returned when the timeline doesn't tell.
+func (sc *SaturationConfig) judgeStep(step *saturationStep, report *benchmarkReport) error {
+	if report.LoadTimelineFile == "" {
+		return fmt.Errorf("no load timeline was saved")
+	}
+	samples, err := readLoadTimeline(report.LoadTimelineFile)
+	if err != nil {
+		return err
+	}
+	var written, delivered, achieved, target float64
+	n := 0
+	for i, s := range samples {
+		if s.DeliveredLinesPerSecond == nil {
+			return fmt.Errorf("delivery wasn't verified")
+		}
+		// The first sample has no timestamp to compare with, but is always early
+		if i == 0 || s.Elapsed < time.Duration(sc.SettleSeconds)*time.Second {
+			continue
+		}
+		written += s.LinesPerSecond
+		delivered += *s.DeliveredLinesPerSecond
+		achieved += s.Achieved
+		target += s.Target
+		n++
+	}
+	if n == 0 {
+		return fmt.Errorf("no load sample after settling, the step must be longer than settle_seconds and metrics_period_ms")
+	}
+	written, delivered, achieved, target = written/float64(n), delivered/float64(n), achieved/float64(n), target/float64(n)
+	if written <= 0 {
+		// Nothing to compare deliveries with
+		return fmt.Errorf("no lines written after settling")
+	}
+	step.Written, step.Delivered = written, delivered
+
+	tolerance := 1 - sc.TolerancePct/100
+	switch {
+	case achieved < target*tolerance:
+		step.Reason = stepWritersBehind
+	case delivered < written*tolerance:
+		step.Reason = fmt.Sprintf("delivered %.0f%% of lines written", delivered/written*100)
+	default:
+		step.Sustained = true
+	}
+	return nil
+}
+
+const stepWritersBehind = "writers fell behind"
+
+// timelineSample is a sample of the load timeline along with the time
+// since the first one
+type timelineSample struct {
+	loadSample
+	Elapsed time.Duration
+}
+
+func readLoadTimeline(filePath string) ([]timelineSample, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var samples []timelineSample
+	var first time.Time
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event loadEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("%s: %s", filePath, err)
+		}
+		ts, err := time.Parse(time.RFC3339Nano, event.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", filePath, err)
+		}
+		if first.IsZero() {
+			first = ts
+		}
+		samples = append(samples, timelineSample{loadSample: event.Load, Elapsed: ts.Sub(first)})
+	}
+	return samples, scanner.Err()
+}
+
+// RunSaturation searches for the highest rate sustained by each shipper
+// config in turn and saves a summary.  It returns the number of shipper
+// configs for which the search failed.
+func RunSaturation(sc *SaturationConfig) int {
+
+	utils.CreateDir(sc.OutputDir)
+	exePath, err := os.Executable()
+	if err != nil {
+		fmt.Println("[ERROR] Could not find the benchmark executable: ", err)
+		os.Exit(1)
+	}
+	stopChan := stopOnSignal()
+	stopped := func() bool {
+		select {
+		case <-stopChan:
+			return true
+		default:
+			return false
+		}
+	}
+
+	var results []*saturationResult
+	failed := 0
+	index := 0
+	for _, confPath := range sc.ShipperConfigs {
+		base := LoadConfig(confPath)
+		res := &saturationResult{ShipperConfig: confPath, Shipper: base.LogShipperName, Unit: sc.rateUnit()}
+		results = append(results, res)
+
+		// run returns whether the shipper sustained the rate, and false along
+		// with an error when the step couldn't be run
+		run := func(stepRate float64) (bool, error) {
+			if index > 0 && sc.CooldownSeconds > 0 && !stopped() {
+				fmt.Printf("[INFO] Cooling down for %d seconds...\n", sc.CooldownSeconds)
+				select {
+				case <-time.After(time.Duration(sc.CooldownSeconds) * time.Second):
+				case <-stopChan:
+				}
+			}
+			if stopped() {
+				return false, fmt.Errorf("stopped")
+			}
+			index++
+			cell := sc.stepCell(confPath, base, stepRate, index)
+			fmt.Printf("[INFO] Running %s at %g %s\n", base.LogShipperName, stepRate, sc.rateUnit())
+			report, reportPath, err := runCell(exePath, cell, stopChan)
+			if err != nil {
+				return false, err
+			}
+			step := &saturationStep{Rate: stepRate, ReportPath: reportPath}
+			if err := sc.judgeStep(step, report); err != nil {
+				return false, err
+			}
+			res.Steps = append(res.Steps, step)
+			if step.Sustained {
+				fmt.Printf("[INFO] %s sustained %g %s\n", base.LogShipperName, stepRate, sc.rateUnit())
+			} else {
+				fmt.Printf("[INFO] %s didn't sustain %g %s: %s\n", base.LogShipperName, stepRate, sc.rateUnit(), step.Reason)
+			}
+			return step.Sustained, nil
+		}
+
+		// Raise the rate until the shipper falls behind
+		var sustained, notSustained float64
+		var limit string
+		var runErr error
+		for stepRate := sc.StartRate; sc.MaxRate <= 0 || stepRate <= sc.MaxRate; stepRate *= sc.StepFactor {
+			ok, err := run(stepRate)
+			if err != nil {
+				runErr = err
+				break
+			}
+			if !ok {
+				notSustained, limit = stepRate, res.Steps[len(res.Steps)-1].Reason
+				break
+			}
+			sustained = stepRate
+		}
+		// Then narrow the range down
+		for i := 0; runErr == nil && notSustained > 0 && i < sc.RefineSteps; i++ {
+			stepRate := (sustained + notSustained) / 2
+			ok, err := run(stepRate)
+			if err != nil {
+				runErr = err
+			} else if ok {
+				sustained = stepRate
+			} else {
+				notSustained, limit = stepRate, res.Steps[len(res.Steps)-1].Reason
+			}
+		}
+
+		res.MaxSustained = sustained
+		switch {
+		case runErr != nil:
+			res.Limit = runErr.Error()
+			fmt.Printf("[ERROR] Search for %s failed: %s\n", confPath, runErr)
+			failed++
+		case notSustained == 0:
+			res.Limit = "max_rate reached"
+		case limit == stepWritersBehind:
+			res.Limit = limit
+		default:
+			res.Limit = fmt.Sprintf("fell behind at %g %s", notSustained, sc.rateUnit())
+		}
+	}
+
+	t := time.Now()
+	dt := fmt.Sprintf("%d%02d%02d%02d%02d%02d", t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second())
+	basePath := fmt.Sprintf("%s/saturation-summary_%s", strings.TrimRight(sc.OutputDir, "/"), dt)
+
+	text := generateSaturationSummary(results)
+	fmt.Print(text)
+	if err := SaveToFile(basePath+".txt", text, 0644); err != nil {
+		fmt.Println("[ERROR] Could not save saturation summary: ", err)
+	}
+	if b, err := json.MarshalIndent(results, "", "  "); err != nil {
+		fmt.Println("[ERROR] Could not generate JSON saturation summary: ", err)
+	} else if err := SaveToFile(basePath+".json", string(b)+"\n", 0644); err != nil {
+		fmt.Println("[ERROR] Could not save JSON saturation summary: ", err)
+	}
+	return failed
+}
+
+func generateSaturationSummary(results []*saturationResult) string {
+
+	var buffer bytes.Buffer
+	buffer.WriteString("\n-------------------- Saturation Results -------------------\n")
+	w := tabwriter.NewWriter(&buffer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CONFIG\tSHIPPER\tMAX SUSTAINED\tLIMIT")
+	for _, res := range results {
+		fmt.Fprintf(w, "%s\t%s\t%g %s\t%s\n", res.ShipperConfig, res.Shipper, res.MaxSustained, res.Unit, res.Limit)
+	}
+	w.Flush()
+	for _, res := range results {
+		buffer.WriteString(fmt.Sprintf("\n%s (%s):\n", res.Shipper, res.ShipperConfig))
+		w := tabwriter.NewWriter(&buffer, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "RATE (%s)\tWRITTEN LINES/S\tDELIVERED LINES/S\tSUSTAINED\n", res.Unit)
+		for _, step := range res.Steps {
+			status := "yes"
+			if !step.Sustained {
+				status = "no, " + step.Reason
+			}
+			fmt.Fprintf(w, "%g\t%.1f\t%.1f\t%s\n", step.Rate, step.Written, step.Delivered, status)
+		}
+		w.Flush()
+	}
+	buffer.WriteString("----------------------------------------------------------\n")
+	return buffer.String()
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeLoadTimeline writes a timeline with a sample every second, rates
+// holding target, achieved, written and delivered lines/s
+func writeLoadTimeline(t *testing.T, rates [][4]float64, verified bool) string {
+	filePath := filepath.Join(t.TempDir(), "metrics-load.log")
+	file, err := os.Create(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	enc := json.NewEncoder(file)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, r := range rates {
+		sample := loadSample{Unit: "lines", Target: r[0], Achieved: r[1], LinesPerSecond: r[2]}
+		if verified {
+			delivered := r[3]
+			sample.DeliveredLinesPerSecond = &delivered
+		}
+		enc.Encode(&loadEvent{Timestamp: start.Add(time.Duration(i) * time.Second).Format(time.RFC3339Nano), Load: sample})
+	}
+	return filePath
+}
+
+func TestJudgeStep(t *testing.T) {
+	sc := &SaturationConfig{TolerancePct: 5, SettleSeconds: 2}
+	tests := []struct {
+		name      string
+		rates     [][4]float64
+		verified  bool
+		sustained bool
+		reason    string // Start of the reason, or of the error
+		written   float64
+		delivered float64
+	}{
+		{
+			name: "kept up",
+			// The first samples, before settling, are ignored
+			rates:     [][4]float64{{1000, 0, 0, 0}, {1000, 500, 500, 0}, {1000, 1000, 1000, 990}, {1000, 1000, 1000, 970}},
+			verified:  true,
+			sustained: true,
+			written:   1000,
+			delivered: 980,
+		},
+		{
+			name:     "writers behind",
+			rates:    [][4]float64{{1000, 0, 0, 0}, {1000, 900, 900, 900}, {1000, 900, 900, 900}},
+			verified: true,
+			reason:   stepWritersBehind,
+			written:  900, delivered: 900,
+		},
+		{
+			name:     "shipper behind",
+			rates:    [][4]float64{{1000, 0, 0, 0}, {1000, 1000, 1000, 800}, {1000, 1000, 1000, 800}},
+			verified: true,
+			reason:   "delivered 80% of lines written",
+			written:  1000, delivered: 800,
+		},
+		{
+			name:     "within tolerance",
+			rates:    [][4]float64{{1000, 0, 0, 0}, {1000, 960, 960, 920}, {1000, 960, 960, 920}},
+			verified: true, sustained: true,
+			written: 960, delivered: 920,
+		},
+		{
+			name:   "not verified",
+			rates:  [][4]float64{{1000, 0, 0, 0}, {1000, 1000, 1000, 0}, {1000, 1000, 1000, 0}},
+			reason: "error: delivery wasn't verified",
+		},
+		{
+			name:     "nothing written",
+			rates:    [][4]float64{{0, 0, 0, 0}, {0, 0, 0, 0}, {0, 0, 0, 0}},
+			verified: true,
+			reason:   "error: no lines written after settling",
+		},
+		{
+			name:     "too short to settle",
+			rates:    [][4]float64{{1000, 0, 0, 0}, {1000, 1000, 1000, 1000}},
+			verified: true,
+			reason:   "error: no load sample after settling",
+		},
+	}
+	for _, tt := range tests {
+		step := &saturationStep{Rate: 1000}
+		report := &benchmarkReport{LoadTimelineFile: writeLoadTimeline(t, tt.rates, tt.verified)}
+		err := sc.judgeStep(step, report)
+		if strings.HasPrefix(tt.reason, "error: ") {
+			if err == nil || !strings.HasPrefix(err.Error(), strings.TrimPrefix(tt.reason, "error: ")) {
+				t.Errorf("%s: judgeStep() = %v, want error %q", tt.name, err, tt.reason)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: judgeStep() = %v", tt.name, err)
+			continue
+		}
+		if step.Sustained != tt.sustained || !strings.HasPrefix(step.Reason, tt.reason) || step.Written != tt.written || step.Delivered != tt.delivered {
+			t.Errorf("%s: step = %+v, want sustained %v, reason %q, written %g and delivered %g",
+				tt.name, step, tt.sustained, tt.reason, tt.written, tt.delivered)
+		}
+	}
+}
+
+func TestJudgeStepWithoutTimeline(t *testing.T) {
+	sc := &SaturationConfig{}
+	if err := sc.judgeStep(&saturationStep{}, &benchmarkReport{}); err == nil {
+		t.Error("judgeStep() without a load timeline didn't fail")
+	}
+}
@@ -3,12 +3,21 @@ package main
 import (
 	"os/exec"
 	"sync"
+
+	utils "github.com/hartfordfive/logshipper-benchmark/lib"
 )
 
 type Shipper interface {
 	Name() string
-	Run(binPath string, cmdArgs []string, workingDir string, filesToMonitor []string, kafkaBrokers []string, filebeatExec chan *exec.Cmd, terminate chan bool, wg *sync.WaitGroup)
+	Run(binPath string, cmdArgs []string, workingDir string, filesToMonitor []string, kafkaBrokers []string, opts *utils.RunOptions, filebeatExec chan *exec.Cmd, startErr chan error, terminate chan bool, wg *sync.WaitGroup)
 	CleanupFiles()
-	BuildConfig(confDestPath string, filesToMonitor []string, kafkTopicName string, kafkaBrokersList []string)
+	BuildConfig(confDestPath string, filesToMonitor []string, kafkTopicName string, kafkaBrokersList []string, opts *utils.RunOptions)
 	GetVersion() string
 }
+
+// PositionReporter is implemented by shippers which save how far they read
+// each file, such as in the filebeat registry.  ReadPositions returns the
+// offsets saved so far, keyed by absolute path.
+type PositionReporter interface {
+	ReadPositions() (map[string]int64, error)
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"strings"
 	"sync"
 	"syscall"
@@ -42,8 +45,13 @@ filebeat.prospectors:
 - enabled: true
   fields_under_root: true
   type: log
-  scan_frequency: 10s
+  scan_frequency: {{if $.ScanInterval}}{{$.ScanInterval}}s{{else}}10s{{end}}
   close_eof: true
+{{- if $.MultilineStart}}
+  multiline.pattern: '{{$.MultilineStart}}'
+  multiline.negate: true
+  multiline.match: after
+{{- end}}
   paths:
   - "{{.}}"
 {{- end}}
@@ -53,28 +61,79 @@ type config struct {
 	KafkaBrokers   []string
 	KafkaTopic     string
 	FilesToMonitor []string
+	ScanInterval   int
+	MultilineStart string
+}
+
+type shipper struct {
+	workDir string // Where the config and state files are kept
+	lock    sync.Mutex
 }
 
-type shipper struct{}
+// dir returns the working directory of the shipper, which is only known
+// once its config was built
+func (s *shipper) dir() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.workDir
+}
 
-func (s shipper) Name() string { return "filebeat" }
+func (s *shipper) Name() string { return "filebeat" }
 
-func (s shipper) CleanupFiles() {
-	if _, err := os.Stat("registry"); err == nil {
-		os.Remove("registry")
+func (s *shipper) CleanupFiles() {
+	files := []string{"registry", "meta.json", "filebeat.yml"}
+	for _, f := range files {
+		f = path.Join(s.dir(), f)
+		if _, err := os.Stat(f); err == nil {
+			os.Remove(f)
+		}
 	}
-	if _, err := os.Stat("meta.json"); err == nil {
-		os.Remove("meta.json")
+}
+
+// registryEntry is the state filebeat saves for each file it harvests
+type registryEntry struct {
+	Source string `json:"source"`
+	Offset int64  `json:"offset"`
+}
+
+// ReadPositions returns the offsets saved in the registry, which filebeat
+// only writes once events are acknowledged by the output.
+func (s *shipper) ReadPositions() (map[string]int64, error) {
+	workDir := s.dir()
+	data, err := ioutil.ReadFile(path.Join(workDir, "registry"))
+	if os.IsNotExist(err) {
+		return map[string]int64{}, nil
+	} else if err != nil {
+		return nil, err
 	}
-	if _, err := os.Stat("filebeat.yml"); err == nil {
-		os.Remove("filebeat.yml")
+	var entries []registryEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
 	}
-
+	positions := make(map[string]int64, len(entries))
+	for _, e := range entries {
+		source := e.Source
+		if !filepath.IsAbs(source) {
+			source = filepath.Join(workDir, source)
+		}
+		if source, err = filepath.Abs(source); err != nil {
+			continue
+		}
+		if e.Offset > positions[source] {
+			positions[source] = e.Offset
+		}
+	}
+	return positions, nil
 }
 
-func (s shipper) BuildConfig(confDestPath string, filesToMonitor []string, kafkTopicName string, kafkaBrokersList []string) {
+func (s *shipper) BuildConfig(confDestPath string, filesToMonitor []string, kafkTopicName string, kafkaBrokersList []string, opts *utils.RunOptions) {
 
-	s.CleanupFiles()
+	s.lock.Lock()
+	s.workDir = path.Dir(confDestPath)
+	s.lock.Unlock()
+	if !opts.PreserveState {
+		s.CleanupFiles()
+	}
 
 	confBaseName := path.Base(confDestPath)
 	t := template.Must(template.New(fmt.Sprintf("%s.tpl", confBaseName)).Parse(configTpl))
@@ -90,6 +149,8 @@ func (s shipper) BuildConfig(confDestPath string, filesToMonitor []string, kafkT
 		KafkaBrokers:   kafkaBrokersList,
 		KafkaTopic:     kafkTopicName,
 		FilesToMonitor: filesToMonitor,
+		ScanInterval:   opts.FileScanIntervalSecs,
+		MultilineStart: opts.MultilineStart,
 	})
 
 	if err != nil {
@@ -97,7 +158,7 @@ func (s shipper) BuildConfig(confDestPath string, filesToMonitor []string, kafkT
 	}
 }
 
-func (s shipper) Run(binPath string, cmdArgs []string, workingDir string, filesToMonitor []string, kafkBrokers []string, execChan chan *exec.Cmd, shutdownChan chan bool, wg *sync.WaitGroup) {
+func (s *shipper) Run(binPath string, cmdArgs []string, workingDir string, filesToMonitor []string, kafkBrokers []string, opts *utils.RunOptions, execChan chan *exec.Cmd, startErrChan chan error, shutdownChan chan bool, wg *sync.WaitGroup) {
 
 	// First generate, the filebeat config
 	s.BuildConfig(
@@ -105,6 +166,7 @@ func (s shipper) Run(binPath string, cmdArgs []string, workingDir string, filesT
 		filesToMonitor,
 		fmt.Sprintf("dev-logs-shipper-benchmarks-%s", s.Name()),
 		kafkBrokers,
+		opts,
 	)
 
 	// Ensure the working directory exists, if not create it
@@ -118,26 +180,29 @@ func (s shipper) Run(binPath string, cmdArgs []string, workingDir string, filesT
 	cmd := exec.Command(binPath, cmdArgs...)
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	cmd.Dir = workingDir
-	// var stdout, stderr bytes.Buffer
-	// cmd.Stdout = &stdout
-	// cmd.Stderr = &stderr
+	opts.CaptureOutput(cmd)
+	opts.ConfineShipper(cmd)
 	err := cmd.Start()
 
-	go utils.CollectCpuStats(cmd.Process.Pid, fmt.Sprintf("%s/metrics/%s", strings.TrimRight(workingDir, "/"), s.Name()), shutdownChan)
-
 	if err != nil {
 		fmt.Printf("[ERROR] Could not run %s: %s\n", s.Name(), err)
-		os.Exit(1)
+		startErrChan <- err
+		return
 	}
 
-	execChan <- cmd
+	go utils.CollectCpuStats(cmd.Process.Pid, fmt.Sprintf("%s/metrics/%s", strings.TrimRight(workingDir, "/"), s.Name()), shutdownChan)
 
-	// outStr, errStr := string(stdout.Bytes()), string(stderr.Bytes())
-	// fmt.Printf("out:\n%s\nerr:\n%s\n", outStr, errStr)
+	execChan <- cmd
 
+	exited := make(chan bool)
 	go func(shudownChan <-chan bool, cmd *exec.Cmd) {
 		fmt.Printf("[INFO] Waiting for signal to shutdown %s...\n", s.Name())
-		<-shutdownChan
+		select {
+		case <-shutdownChan:
+		case <-exited:
+			// Nothing left to shut down, such as once restarted
+			return
+		}
 		fmt.Printf("[INFO] Terminating shipper...\n")
 		// err := cmd.Process.Signal(os.Interrupt)
 		err := syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
@@ -146,18 +211,18 @@ func (s shipper) Run(binPath string, cmdArgs []string, workingDir string, filesT
 		} else {
 			fmt.Printf("[INFO] %s has been shut down.\n", s.Name())
 		}
-		s.CleanupFiles()
 	}(shutdownChan, cmd)
 
 	fmt.Printf("[INFO] %s is now running.\n", s.Name())
 	cmd.Wait()
+	close(exited)
 }
 
-func (s shipper) GetVersion() string {
+func (s *shipper) GetVersion() string {
 	return fmt.Sprintf("%d.%d.%d", supportedShipperVersionMajor, supportedShipperVersionMinor, supportedShipperVersionPatch)
 }
 
 func InitShipper() (s interface{}, err error) {
-	s = shipper{}
+	s = &shipper{}
 	return
 }
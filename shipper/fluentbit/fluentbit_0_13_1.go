@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"strings"
 	"sync"
 	"syscall"
@@ -26,6 +27,9 @@ var configTpl = `
     Log_Level       debug
     HTTP_Monitoring On
     HTTP_Port       2020
+{{- if .MultilineStart}}
+    Parsers_File    {{.ParsersFile}}
+{{- end}}
 
 {{range $index, $file := .FilesToMonitor}}
 [INPUT]
@@ -33,6 +37,13 @@ var configTpl = `
     Path        {{$file}}
     #Path_Key	source
     Tag         file{{$index}}
+{{- if $.MultilineStart}}
+    Multiline   On
+    Parser_Firstline multiline_start
+{{- end}}
+{{- if $.ScanInterval}}
+    Refresh_Interval {{$.ScanInterval}}
+{{- end}}
 {{end}}
 
 [OUTPUT]
@@ -42,26 +53,56 @@ var configTpl = `
     Topics      {{.KafkaTopic}}
 `
 
+// parsersTpl defines the parser matching the first line of multi-line entries
+const parsersTpl = `
+[PARSER]
+    Name   multiline_start
+    Format regex
+    Regex  (?<log>{{.MultilineStart}}.*)
+`
+
 type config struct {
 	KafkaBrokers   []string
 	KafkaTopic     string
 	FilesToMonitor []string
+	ScanInterval   int
+	MultilineStart string
+	ParsersFile    string
 }
 
-type shipper struct{}
+type shipper struct {
+	workDir string // Where the config and state files are kept
+	lock    sync.Mutex
+}
 
-func (s shipper) Name() string { return "fluentbit" }
+// dir returns the working directory of the shipper, which is only known
+// once its config was built
+func (s *shipper) dir() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.workDir
+}
 
-func (s shipper) CleanupFiles() {
-	if _, err := os.Stat("td-agent-bit.conf"); err == nil {
-		os.Remove("td-agent-bit.conf")
-	}
+func (s *shipper) Name() string { return "fluentbit" }
 
+func (s *shipper) CleanupFiles() {
+	files := []string{"td-agent-bit.conf", "parsers.conf"}
+	for _, f := range files {
+		f = path.Join(s.dir(), f)
+		if _, err := os.Stat(f); err == nil {
+			os.Remove(f)
+		}
+	}
 }
 
-func (s shipper) BuildConfig(confDestPath string, filesToMonitor []string, kafkTopicName string, kafkaBrokersList []string) {
+func (s *shipper) BuildConfig(confDestPath string, filesToMonitor []string, kafkTopicName string, kafkaBrokersList []string, opts *utils.RunOptions) {
 
-	s.CleanupFiles()
+	s.lock.Lock()
+	s.workDir = path.Dir(confDestPath)
+	s.lock.Unlock()
+	if !opts.PreserveState {
+		s.CleanupFiles()
+	}
 
 	confBaseName := path.Base(confDestPath)
 	t := template.Must(template.New(fmt.Sprintf("%s.tpl", confBaseName)).Parse(configTpl))
@@ -73,18 +114,38 @@ func (s shipper) BuildConfig(confDestPath string, filesToMonitor []string, kafkT
 	}
 	defer fh.Close()
 
-	err = t.Execute(fh, &config{
+	conf := &config{
 		KafkaBrokers:   kafkaBrokersList,
 		KafkaTopic:     kafkTopicName,
 		FilesToMonitor: filesToMonitor,
-	})
+		ScanInterval:   opts.FileScanIntervalSecs,
+		MultilineStart: opts.MultilineStart,
+	}
+	if conf.MultilineStart != "" {
+		// Parsers can only be defined in a file of their own
+		if conf.ParsersFile, err = filepath.Abs(path.Join(s.dir(), "parsers.conf")); err != nil {
+			panic(err)
+		}
+		pfh, err := os.Create(conf.ParsersFile)
+		if err != nil {
+			fmt.Println("[ERROR] Could not create parsers: ", err)
+			os.Exit(1)
+		}
+		err = template.Must(template.New("parsers.tpl").Parse(parsersTpl)).Execute(pfh, conf)
+		pfh.Close()
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	err = t.Execute(fh, conf)
 
 	if err != nil {
 		panic(err)
 	}
 }
 
-func (s shipper) Run(binPath string, cmdArgs []string, workingDir string, filesToMonitor []string, kafkBrokers []string, execChan chan *exec.Cmd, shutdownChan chan bool, wg *sync.WaitGroup) {
+func (s *shipper) Run(binPath string, cmdArgs []string, workingDir string, filesToMonitor []string, kafkBrokers []string, opts *utils.RunOptions, execChan chan *exec.Cmd, startErrChan chan error, shutdownChan chan bool, wg *sync.WaitGroup) {
 
 	// First generate, the filebeat config
 	s.BuildConfig(
@@ -92,6 +153,7 @@ func (s shipper) Run(binPath string, cmdArgs []string, workingDir string, filesT
 		filesToMonitor,
 		fmt.Sprintf("dev-logs-shipper-benchmarks-%s", s.Name()),
 		kafkBrokers,
+		opts,
 	)
 
 	// Now run the binary
@@ -99,26 +161,29 @@ func (s shipper) Run(binPath string, cmdArgs []string, workingDir string, filesT
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	cmd.Dir = workingDir
 
-	// var stdout, stderr bytes.Buffer
-	// cmd.Stdout = &stdout
-	// cmd.Stderr = &stderr
+	opts.CaptureOutput(cmd)
+	opts.ConfineShipper(cmd)
 	err := cmd.Start()
 
-	go utils.CollectCpuStats(cmd.Process.Pid, fmt.Sprintf("%s/metrics/%s", strings.TrimRight(workingDir, "/"), s.Name()), shutdownChan)
-
 	if err != nil {
 		fmt.Printf("[ERROR] Could not run %s: %s\n", s.Name(), err)
-		os.Exit(1)
+		startErrChan <- err
+		return
 	}
 
-	execChan <- cmd
+	go utils.CollectCpuStats(cmd.Process.Pid, fmt.Sprintf("%s/metrics/%s", strings.TrimRight(workingDir, "/"), s.Name()), shutdownChan)
 
-	// outStr, errStr := string(stdout.Bytes()), string(stderr.Bytes())
-	// fmt.Printf("out:\n%s\nerr:\n%s\n", outStr, errStr)
+	execChan <- cmd
 
+	exited := make(chan bool)
 	go func(shudownChan <-chan bool, cmd *exec.Cmd) {
 		fmt.Printf("[INFO] Waiting for signal to shutdown %s...\n", s.Name())
-		<-shutdownChan
+		select {
+		case <-shutdownChan:
+		case <-exited:
+			// Nothing left to shut down, such as once restarted
+			return
+		}
 		fmt.Printf("[INFO] Terminating shipper...\n")
 		//err := cmd.Process.Signal(os.Interrupt)
 		err := syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
@@ -127,19 +192,19 @@ func (s shipper) Run(binPath string, cmdArgs []string, workingDir string, filesT
 		} else {
 			fmt.Printf("[INFO] %s has been shut down.\n", s.Name())
 		}
-		s.CleanupFiles()
 	}(shutdownChan, cmd)
 
 	fmt.Printf("[INFO] %s is now running.\n", s.Name())
 	cmd.Wait()
+	close(exited)
 
 }
 
-func (s shipper) GetVersion() string {
+func (s *shipper) GetVersion() string {
 	return fmt.Sprintf("%d.%d.%d", supportedShipperVersionMajor, supportedShipperVersionMinor, supportedShipperVersionPatch)
 }
 
 func InitShipper() (s interface{}, err error) {
-	s = shipper{}
+	s = &shipper{}
 	return
 }
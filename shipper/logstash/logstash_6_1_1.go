@@ -40,6 +40,17 @@ input {
   file { 
     path => "{{$file}}"
     start_position => "beginning"
+{{- if $.ScanInterval}}
+    discover_interval => {{$.ScanInterval}}
+{{- end}}
+{{- if $.MultilineStart}}
+    codec => multiline {
+      pattern => "{{$.MultilineStart}}"
+      negate => true
+      what => "previous"
+      auto_flush_interval => 1
+    }
+{{- end}}
   }
 {{end}}
 }
@@ -58,26 +69,43 @@ type config struct {
 	KafkaBrokers   []string
 	KafkaTopic     string
 	FilesToMonitor []string
+	ScanInterval   int
+	MultilineStart string
 }
 
-type shipper struct{}
+type shipper struct {
+	workDir string // Where the config and state files are kept
+	lock    sync.Mutex
+}
 
-func (s shipper) Name() string { return "logstash" }
+// dir returns the working directory of the shipper, which is only known
+// once its config was built
+func (s *shipper) dir() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.workDir
+}
 
-func (s shipper) CleanupFiles() {
+func (s *shipper) Name() string { return "logstash" }
 
-	files := []string{"logstash.yml"}
+func (s *shipper) CleanupFiles() {
+	files := []string{"logstash.yml", "main.conf"}
 	for _, f := range files {
+		f = path.Join(s.dir(), f)
 		if _, err := os.Stat(f); err == nil {
 			os.Remove(f)
 		}
 	}
-
 }
 
-func (s shipper) BuildConfig(confDestPath string, filesToMonitor []string, kafkTopicName string, kafkaBrokersList []string) {
+func (s *shipper) BuildConfig(confDestPath string, filesToMonitor []string, kafkTopicName string, kafkaBrokersList []string, opts *utils.RunOptions) {
 
-	s.CleanupFiles()
+	s.lock.Lock()
+	s.workDir = path.Dir(confDestPath)
+	s.lock.Unlock()
+	if !opts.PreserveState {
+		s.CleanupFiles()
+	}
 
 	confBaseName := path.Base(confDestPath)
 	confBaseDir := path.Dir(confDestPath)
@@ -95,6 +123,8 @@ func (s shipper) BuildConfig(confDestPath string, filesToMonitor []string, kafkT
 		KafkaBrokers:   kafkaBrokersList,
 		KafkaTopic:     kafkTopicName,
 		FilesToMonitor: filesToMonitor,
+		ScanInterval:   opts.FileScanIntervalSecs,
+		MultilineStart: opts.MultilineStart,
 	})
 	fh.Close()
 
@@ -118,7 +148,7 @@ func (s shipper) BuildConfig(confDestPath string, filesToMonitor []string, kafkT
 	}
 }
 
-func (s shipper) Run(binPath string, cmdArgs []string, workingDir string, filesToMonitor []string, kafkBrokers []string, execChan chan *exec.Cmd, shutdownChan chan bool, wg *sync.WaitGroup) {
+func (s *shipper) Run(binPath string, cmdArgs []string, workingDir string, filesToMonitor []string, kafkBrokers []string, opts *utils.RunOptions, execChan chan *exec.Cmd, startErrChan chan error, shutdownChan chan bool, wg *sync.WaitGroup) {
 
 	// First generate, the config
 	s.BuildConfig(
@@ -126,6 +156,7 @@ func (s shipper) Run(binPath string, cmdArgs []string, workingDir string, filesT
 		filesToMonitor,
 		fmt.Sprintf("dev-logs-shipper-benchmarks-%s", s.Name()),
 		kafkBrokers,
+		opts,
 	)
 
 	// Ensure the working directory exists, if not create it
@@ -139,28 +170,31 @@ func (s shipper) Run(binPath string, cmdArgs []string, workingDir string, filesT
 	cmd := exec.Command(binPath, cmdArgs...)
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	cmd.Dir = workingDir
-	cmd.Env = append(cmd.Env, fmt.Sprintf("LOGSTASH_HOME=%", workingDir))
-	cmd.Env = append(cmd.Env, fmt.Sprintf("LS_HOME=%", workingDir))
-	// var stdout, stderr bytes.Buffer
-	// cmd.Stdout = &stdout
-	// cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), fmt.Sprintf("LOGSTASH_HOME=%s", workingDir))
+	cmd.Env = append(cmd.Env, fmt.Sprintf("LS_HOME=%s", workingDir))
+	opts.CaptureOutput(cmd)
+	opts.ConfineShipper(cmd)
 	err := cmd.Start()
 
-	go utils.CollectCpuStats(cmd.Process.Pid, fmt.Sprintf("%s/metrics/%s", strings.TrimRight(workingDir, "/"), s.Name()), shutdownChan)
-
 	if err != nil {
 		fmt.Printf("[ERROR] Could not run %s: %s\n", s.Name(), err)
-		os.Exit(1)
+		startErrChan <- err
+		return
 	}
 
-	execChan <- cmd
+	go utils.CollectCpuStats(cmd.Process.Pid, fmt.Sprintf("%s/metrics/%s", strings.TrimRight(workingDir, "/"), s.Name()), shutdownChan)
 
-	// outStr, errStr := string(stdout.Bytes()), string(stderr.Bytes())
-	// fmt.Printf("out:\n%s\nerr:\n%s\n", outStr, errStr)
+	execChan <- cmd
 
+	exited := make(chan bool)
 	go func(shudownChan <-chan bool, cmd *exec.Cmd) {
 		fmt.Printf("[INFO] Waiting for signal to shutdown %s...\n", s.Name())
-		<-shutdownChan
+		select {
+		case <-shutdownChan:
+		case <-exited:
+			// Nothing left to shut down, such as once restarted
+			return
+		}
 		fmt.Printf("[INFO] Terminating shipper...\n")
 		// err := cmd.Process.Signal(os.Interrupt)
 		err := syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
@@ -169,18 +203,18 @@ func (s shipper) Run(binPath string, cmdArgs []string, workingDir string, filesT
 		} else {
 			fmt.Printf("[INFO] %s has been shut down.\n", s.Name())
 		}
-		s.CleanupFiles()
 	}(shutdownChan, cmd)
 
 	fmt.Printf("[INFO] %s is now running.\n", s.Name())
 	cmd.Wait()
+	close(exited)
 }
 
-func (s shipper) GetVersion() string {
+func (s *shipper) GetVersion() string {
 	return fmt.Sprintf("%d.%d.%d", supportedShipperVersionMajor, supportedShipperVersionMinor, supportedShipperVersionPatch)
 }
 
 func InitShipper() (s interface{}, err error) {
-	s = shipper{}
+	s = &shipper{}
 	return
 }
@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"strings"
 	"sync"
 	"syscall"
 	"text/template"
@@ -34,12 +35,24 @@ PidFile ~/nxlog.pid
 ########################################
 # Modules #
 ########################################
+{{- if .MultilineStart}}
+<Extension multiline>
+  Module xm_multiline
+  HeaderLine /{{.MultilineStart}}/
+</Extension>
+{{- end}}
 {{range $index, $file := .FilesToMonitor}}
 <Input inFile{{$index}}>
   Module im_file
   File "{{$file}}"
   SavePos TRUE
   Recursive TRUE
+{{- if $.MultilineStart}}
+  InputType multiline
+{{- end}}
+{{- if $.ScanInterval}}
+  DirCheckInterval {{$.ScanInterval}}
+{{- end}}
 </Input>
 
 {{end}}
@@ -68,22 +81,43 @@ type config struct {
 	KafkaBrokers   []string
 	KafkaTopic     string
 	FilesToMonitor []string
+	ScanInterval   int
+	MultilineStart string
 }
 
-type shipper struct{}
+type shipper struct {
+	workDir string // Where the config and state files are kept
+	lock    sync.Mutex
+}
 
-func (s shipper) Name() string { return "nxlog" }
+// dir returns the working directory of the shipper, which is only known
+// once its config was built
+func (s *shipper) dir() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.workDir
+}
 
-func (s shipper) CleanupFiles() {
-	if _, err := os.Stat("nxlog.conf"); err == nil {
-		os.Remove("nxlog.conf")
-	}
+func (s *shipper) Name() string { return "nxlog" }
 
+func (s *shipper) CleanupFiles() {
+	files := []string{"nxlog.conf"}
+	for _, f := range files {
+		f = path.Join(s.dir(), f)
+		if _, err := os.Stat(f); err == nil {
+			os.Remove(f)
+		}
+	}
 }
 
-func (s shipper) BuildConfig(confDestPath string, filesToMonitor []string, kafkTopicName string, kafkaBrokersList []string) {
+func (s *shipper) BuildConfig(confDestPath string, filesToMonitor []string, kafkTopicName string, kafkaBrokersList []string, opts *utils.RunOptions) {
 
-	s.CleanupFiles()
+	s.lock.Lock()
+	s.workDir = path.Dir(confDestPath)
+	s.lock.Unlock()
+	if !opts.PreserveState {
+		s.CleanupFiles()
+	}
 
 	confBaseName := path.Base(confDestPath)
 	t := template.Must(template.New(fmt.Sprintf("%s.tpl", confBaseName)).Parse(configTpl))
@@ -99,6 +133,8 @@ func (s shipper) BuildConfig(confDestPath string, filesToMonitor []string, kafkT
 		KafkaBrokers:   kafkaBrokersList,
 		KafkaTopic:     kafkTopicName,
 		FilesToMonitor: filesToMonitor,
+		ScanInterval:   opts.FileScanIntervalSecs,
+		MultilineStart: opts.MultilineStart,
 	})
 
 	if err != nil {
@@ -106,40 +142,48 @@ func (s shipper) BuildConfig(confDestPath string, filesToMonitor []string, kafkT
 	}
 }
 
-func (s shipper) Run(binPath string, cmdArgs []string, filesToMonitor []string, kafkBrokers []string, execChan chan *exec.Cmd, shutdownChan chan bool, wg *sync.WaitGroup) {
+func (s *shipper) Run(binPath string, cmdArgs []string, workingDir string, filesToMonitor []string, kafkBrokers []string, opts *utils.RunOptions, execChan chan *exec.Cmd, startErrChan chan error, shutdownChan chan bool, wg *sync.WaitGroup) {
 
 	// First generate, the filebeat config
 	s.BuildConfig(
-		"nxlog.conf",
+		fmt.Sprintf("%s/nxlog.conf", strings.TrimRight(workingDir, "/")),
 		filesToMonitor,
 		fmt.Sprintf("dev-logs-shipper-benchmarks-%s", s.Name()),
 		kafkBrokers,
+		opts,
 	)
 
+	if Debug {
+		fmt.Println("[DEBUG] Changing to working dir: ", workingDir)
+	}
+
 	// Now run the binary
 	cmd := exec.Command(binPath, cmdArgs...)
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-
-	// var stdout, stderr bytes.Buffer
-	// cmd.Stdout = &stdout
-	// cmd.Stderr = &stderr
+	cmd.Dir = workingDir
+	opts.CaptureOutput(cmd)
+	opts.ConfineShipper(cmd)
 	err := cmd.Start()
 
-	go utils.CollectCpuStats(cmd.Process.Pid, "metrics/"+s.Name(), shutdownChan)
-
 	if err != nil {
 		fmt.Printf("[ERROR] Could not run %s: %s\n", s.Name(), err)
-		os.Exit(1)
+		startErrChan <- err
+		return
 	}
 
-	execChan <- cmd
+	go utils.CollectCpuStats(cmd.Process.Pid, fmt.Sprintf("%s/metrics/%s", strings.TrimRight(workingDir, "/"), s.Name()), shutdownChan)
 
-	// outStr, errStr := string(stdout.Bytes()), string(stderr.Bytes())
-	// fmt.Printf("out:\n%s\nerr:\n%s\n", outStr, errStr)
+	execChan <- cmd
 
+	exited := make(chan bool)
 	go func(shudownChan <-chan bool, cmd *exec.Cmd) {
 		fmt.Printf("[INFO] Waiting for signal to shutdown %s...\n", s.Name())
-		<-shutdownChan
+		select {
+		case <-shutdownChan:
+		case <-exited:
+			// Nothing left to shut down, such as once restarted
+			return
+		}
 		fmt.Printf("[INFO] Terminating shipper...\n")
 		err := cmd.Process.Signal(os.Interrupt)
 		if err != nil {
@@ -151,10 +195,15 @@ func (s shipper) Run(binPath string, cmdArgs []string, filesToMonitor []string,
 
 	fmt.Printf("[INFO] %s is now running.\n", s.Name())
 	cmd.Wait()
+	close(exited)
+
+}
 
+func (s *shipper) GetVersion() string {
+	return fmt.Sprintf("%d.%d.%d", SupportedShipperVersionMajor, SupportedShipperVersionMinor, SupportedShipperVersionPatch)
 }
 
 func InitShipper() (s interface{}, err error) {
-	s = shipper{}
+	s = &shipper{}
 	return
 }
@@ -26,6 +26,10 @@ module(load="omkafka")   # Output module to kafka
 input(type="imfile"
   File="{{$file}}"
   Tag="file{{$index}}"
+{{- if $.MultilineStart}}
+  startmsg.regex="{{$.MultilineStart}}"
+  readTimeout="1"
+{{- end}}
 )
 {{end}}
 
@@ -65,25 +69,42 @@ type config struct {
 	KafkaBrokers   []string
 	KafkaTopic     string
 	FilesToMonitor []string
+	MultilineStart string
 }
 
-type shipper struct{}
+type shipper struct {
+	workDir string // Where the config and state files are kept
+	lock    sync.Mutex
+}
 
-func (s shipper) Name() string { return "rsyslogd" }
+// dir returns the working directory of the shipper, which is only known
+// once its config was built
+func (s *shipper) dir() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.workDir
+}
 
-func (s shipper) CleanupFiles() {
-	if _, err := os.Stat("rsyslog.conf"); err == nil {
-		os.Remove("rsyslog.conf")
-	}
-	if _, err := os.Stat("rsyslog.pid"); err == nil {
-		os.Remove("rsyslog.pid")
-	}
+func (s *shipper) Name() string { return "rsyslogd" }
 
+func (s *shipper) CleanupFiles() {
+	files := []string{"rsyslog.conf", "rsyslog.pid"}
+	for _, f := range files {
+		f = path.Join(s.dir(), f)
+		if _, err := os.Stat(f); err == nil {
+			os.Remove(f)
+		}
+	}
 }
 
-func (s shipper) BuildConfig(confDestPath string, filesToMonitor []string, kafkTopicName string, kafkaBrokersList []string) {
+func (s *shipper) BuildConfig(confDestPath string, filesToMonitor []string, kafkTopicName string, kafkaBrokersList []string, opts *utils.RunOptions) {
 
-	s.CleanupFiles()
+	s.lock.Lock()
+	s.workDir = path.Dir(confDestPath)
+	s.lock.Unlock()
+	if !opts.PreserveState {
+		s.CleanupFiles()
+	}
 
 	confBaseName := path.Base(confDestPath)
 	t := template.Must(template.New(fmt.Sprintf("%s.tpl", confBaseName)).Parse(configTpl))
@@ -99,6 +120,7 @@ func (s shipper) BuildConfig(confDestPath string, filesToMonitor []string, kafkT
 		KafkaBrokers:   kafkaBrokersList,
 		KafkaTopic:     kafkTopicName,
 		FilesToMonitor: filesToMonitor,
+		MultilineStart: opts.MultilineStart,
 	})
 
 	if err != nil {
@@ -106,7 +128,7 @@ func (s shipper) BuildConfig(confDestPath string, filesToMonitor []string, kafkT
 	}
 }
 
-func (s shipper) Run(binPath string, cmdArgs []string, workingDir string, filesToMonitor []string, kafkBrokers []string, execChan chan *exec.Cmd, shutdownChan chan bool, wg *sync.WaitGroup) {
+func (s *shipper) Run(binPath string, cmdArgs []string, workingDir string, filesToMonitor []string, kafkBrokers []string, opts *utils.RunOptions, execChan chan *exec.Cmd, startErrChan chan error, shutdownChan chan bool, wg *sync.WaitGroup) {
 
 	// First generate, the filebeat config
 	s.BuildConfig(
@@ -114,6 +136,7 @@ func (s shipper) Run(binPath string, cmdArgs []string, workingDir string, filesT
 		filesToMonitor,
 		fmt.Sprintf("dev-logs-shipper-benchmarks-%s", s.Name()),
 		kafkBrokers,
+		opts,
 	)
 
 	if Debug {
@@ -124,26 +147,29 @@ func (s shipper) Run(binPath string, cmdArgs []string, workingDir string, filesT
 	cmd := exec.Command(binPath, cmdArgs...)
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	cmd.Dir = workingDir
-	// var stdout, stderr bytes.Buffer
-	// cmd.Stdout = &stdout
-	// cmd.Stderr = &stderr
+	opts.CaptureOutput(cmd)
+	opts.ConfineShipper(cmd)
 	err := cmd.Start()
 
-	go utils.CollectCpuStats(cmd.Process.Pid, fmt.Sprintf("%s/metrics/%s", strings.TrimRight(workingDir, "/"), s.Name()), shutdownChan)
-
 	if err != nil {
 		fmt.Printf("[ERROR] Could not run %s: %s\n", s.Name(), err)
-		os.Exit(1)
+		startErrChan <- err
+		return
 	}
 
-	execChan <- cmd
+	go utils.CollectCpuStats(cmd.Process.Pid, fmt.Sprintf("%s/metrics/%s", strings.TrimRight(workingDir, "/"), s.Name()), shutdownChan)
 
-	// outStr, errStr := string(stdout.Bytes()), string(stderr.Bytes())
-	// fmt.Printf("out:\n%s\nerr:\n%s\n", outStr, errStr)
+	execChan <- cmd
 
+	exited := make(chan bool)
 	go func(shudownChan <-chan bool, cmd *exec.Cmd) {
 		fmt.Printf("[INFO] Waiting for signal to shutdown %s...\n", s.Name())
-		<-shutdownChan
+		select {
+		case <-shutdownChan:
+		case <-exited:
+			// Nothing left to shut down, such as once restarted
+			return
+		}
 		fmt.Printf("[INFO] Terminating shipper...\n")
 		//err := cmd.Process.Signal(os.Interrupt)
 		err := syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
@@ -152,19 +178,19 @@ func (s shipper) Run(binPath string, cmdArgs []string, workingDir string, filesT
 		} else {
 			fmt.Printf("[INFO] %s has been shut down.\n", s.Name())
 		}
-		s.CleanupFiles()
 	}(shutdownChan, cmd)
 
 	fmt.Printf("[INFO] %s is now running.\n", s.Name())
 	cmd.Wait()
+	close(exited)
 
 }
 
-func (s shipper) GetVersion() string {
+func (s *shipper) GetVersion() string {
 	return fmt.Sprintf("%d.%d.%d", supportedShipperVersionMajor, supportedShipperVersionMinor, supportedShipperVersionPatch)
 }
 
 func InitShipper() (s interface{}, err error) {
-	s = shipper{}
+	s = &shipper{}
 	return
 }
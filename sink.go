@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	utils "github.com/hartfordfive/logshipper-benchmark/lib"
+	kafka "github.com/hartfordfive/logshipper-benchmark/lib/kafka"
+)
+
+// sinkEvent is a sample of the sink timeline, which is written next to the
+// metrics data file so the state of the embedded broker can be lined up
+// with the resource usage of the shipper.
+type sinkEvent struct {
+	Timestamp string            `json:"@timestamp"`
+	Metricset map[string]string `json:"metricset"`
+	Sink      sinkSample        `json:"sink"`
+}
+
+type sinkSample struct {
+	State             string  `json:"state"`
+	MaxMbPerSecond    float64 `json:"max_mb_per_second,omitempty"`
+	LatencyMs         int     `json:"latency_ms,omitempty"`
+	ErrorRate         float64 `json:"error_rate,omitempty"`
+	RecordsPerSecond  float64 `json:"records_per_second"`
+	BytesPerSecond    float64 `json:"bytes_per_second"`
+	RejectedPerSecond float64 `json:"rejected_per_second"`
+	Disconnects       int64   `json:"disconnects"`
+	BacklogLines      int64   `json:"backlog_lines"`   // Written but not produced yet
+	StateDirBytes     int64   `json:"state_dir_bytes"` // Size of the working directory of the shipper
+}
+
+// sinkWindowRecord is how the shipper went through a window of the schedule.
+// It recovered once every line written before the end of the window was
+// produced to the broker.
+type sinkWindowRecord struct {
+	kafka.SinkWindow
+	State             string   `json:"state"`
+	MaxBacklogLines   int64    `json:"max_backlog_lines"`
+	MaxStateDirBytes  int64    `json:"max_state_dir_bytes"`
+	LinesWrittenAtEnd int64    `json:"lines_written_at_end"`
+	RecoverySeconds   *float64 `json:"recovery_seconds,omitempty"`
+	ended             bool
+}
+
+// sinkSummary holds what the embedded broker did to the shipper
+type sinkSummary struct {
+	kafka.SinkStats
+	Windows      []*sinkWindowRecord `json:"windows"`
+	TimelineFile string              `json:"timeline_file"`
+}
+
+// sinkTimelineFile returns the path of the sink timeline of a metrics data file
+func sinkTimelineFile(metricsDataFile string) string {
+	return strings.TrimSuffix(metricsDataFile, ".log") + "-sink.log"
+}
+
+// sinkMonitor applies a schedule to the embedded broker, and follows how far
+// behind the shipper falls and how long it takes to recover.
+type sinkMonitor struct {
+	filePath string
+	broker   *kafka.Broker
+	topic    string
+	stateDir string
+	counters *writeCounters
+	start    time.Time
+	windows  []*sinkWindowRecord
+	lock     sync.Mutex
+}
+
+// NewSinkMonitor starts the schedule of the broker, the times of its windows
+// being measured from now on.  stateDir is where the shipper keeps its
+// registry and any queue on disk.
+func NewSinkMonitor(filePath string, broker *kafka.Broker, schedule []kafka.SinkWindow, topic string, stateDir string, counters *writeCounters) *sinkMonitor {
+	sm := &sinkMonitor{filePath: filePath, broker: broker, topic: topic, stateDir: stateDir, counters: counters}
+	for _, w := range kafka.SortSinkWindows(schedule) {
+		sm.windows = append(sm.windows, &sinkWindowRecord{SinkWindow: w, State: w.State()})
+	}
+	broker.SetSinkSchedule(schedule)
+	sm.start = time.Now()
+	return sm
+}
+
+// Summary returns what happened so far
+func (sm *sinkMonitor) Summary() *sinkSummary {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	s := &sinkSummary{SinkStats: sm.broker.SinkStats(), TimelineFile: sm.filePath}
+	for _, w := range sm.windows {
+		record := *w
+		s.Windows = append(s.Windows, &record)
+	}
+	return s
+}
+
+// Run writes the state of the sink every period and checks whether the
+// shipper recovered from past windows until shutdown
+func (sm *sinkMonitor) Run(period time.Duration, shutdownChan <-chan bool, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	utils.CreateDir(path.Dir(sm.filePath))
+	file, err := os.OpenFile(sm.filePath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		fmt.Printf("[ERROR] Could not open %s: %s\n", sm.filePath, err)
+		return
+	}
+	defer file.Close()
+	out := bufio.NewWriter(file)
+	defer out.Flush()
+	enc := json.NewEncoder(out)
+
+	sampleTicker := time.NewTicker(period)
+	defer sampleTicker.Stop()
+	checkTicker := time.NewTicker(phaseCheckInterval)
+	defer checkTicker.Stop()
+
+	last := time.Now()
+	lastRecords, lastBytes := sm.broker.Received(sm.topic)
+	lastStats := sm.broker.SinkStats()
+	for {
+		select {
+		case now := <-checkTicker.C:
+			sm.check(now)
+		case now := <-sampleTicker.C:
+			records, bytes := sm.broker.Received(sm.topic)
+			stats := sm.broker.SinkStats()
+			secs := now.Sub(last).Seconds()
+			sample := sinkSample{
+				State:             kafka.SinkHealthy,
+				RecordsPerSecond:  float64(records-lastRecords) / secs,
+				BytesPerSecond:    float64(bytes-lastBytes) / secs,
+				RejectedPerSecond: float64(stats.RejectedSets-lastStats.RejectedSets) / secs,
+				Disconnects:       stats.Disconnects - lastStats.Disconnects,
+				BacklogLines:      sm.backlog(records),
+				StateDirBytes:     dirSize(sm.stateDir),
+			}
+			if w := sm.broker.SinkCondition(); w != nil {
+				sample.State = w.State()
+				sample.MaxMbPerSecond, sample.LatencyMs, sample.ErrorRate = w.MaxMbPerSecond, w.LatencyMs, w.ErrorRate
+			}
+			sm.recordStateDir(now, sample.StateDirBytes)
+			enc.Encode(&sinkEvent{
+				Timestamp: now.UTC().Format(time.RFC3339Nano),
+				Metricset: map[string]string{"module": "benchmark", "name": "sink"},
+				Sink:      sample,
+			})
+			last, lastRecords, lastBytes, lastStats = now, records, bytes, stats
+		case <-shutdownChan:
+			return
+		}
+	}
+}
+
+// backlog returns the lines written but not produced yet, given the records
+// produced.  Duplicates may make up for lines lost, so it's only a guide.
+func (sm *sinkMonitor) backlog(records int64) int64 {
+	if backlog := sm.counters.Lines.Value() - records; backlog > 0 {
+		return backlog
+	}
+	return 0
+}
+
+// active returns the windows which started and which the shipper didn't
+// recover from yet.  The lock must be held.
+func (sm *sinkMonitor) active(now time.Time) []*sinkWindowRecord {
+	var active []*sinkWindowRecord
+	elapsed := now.Sub(sm.start)
+	for _, w := range sm.windows {
+		if elapsed >= time.Duration(w.StartSeconds)*time.Second && w.RecoverySeconds == nil {
+			active = append(active, w)
+		}
+	}
+	return active
+}
+
+func (sm *sinkMonitor) check(now time.Time) {
+	records, _ := sm.broker.Received(sm.topic)
+	backlog := sm.backlog(records)
+	elapsed := now.Sub(sm.start)
+
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	for _, w := range sm.active(now) {
+		if backlog > w.MaxBacklogLines {
+			w.MaxBacklogLines = backlog
+		}
+		end := time.Duration(w.EndSeconds) * time.Second
+		if elapsed < end {
+			continue
+		}
+		if !w.ended {
+			w.ended = true
+			w.LinesWrittenAtEnd = sm.counters.Lines.Value()
+		}
+		if records >= w.LinesWrittenAtEnd {
+			recovery := (elapsed - end).Seconds()
+			w.RecoverySeconds = &recovery
+			fmt.Printf("[INFO] The shipper recovered %.1fs after the %s ending at %ds.\n", recovery, w.State, w.EndSeconds)
+		}
+	}
+}
+
+func (sm *sinkMonitor) recordStateDir(now time.Time, size int64) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	for _, w := range sm.active(now) {
+		if size > w.MaxStateDirBytes {
+			w.MaxStateDirBytes = size
+		}
+	}
+}
+
+// dirSize returns the total size of the files under dir
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
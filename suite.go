@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	utils "github.com/hartfordfive/logshipper-benchmark/lib"
+)
+
+// SuiteConfig declares a matrix of benchmark runs.  Every combination of the
+// listed values is run, and an empty list keeps the value found in the
+// shipper's own config.
+type SuiteConfig struct {
+	ShipperConfigs      []string `json:"shipper_configs"`
+	LogLineSizes        []int    `json:"log_line_sizes"`
+	NumActiveLogFiles   []int    `json:"num_active_log_files"`
+	WriteWaitPeriodsMs  []int    `json:"write_wait_periods_ms"`
+	Repetitions         int      `json:"repetitions"`
+	TotalRunTimeSeconds int64    `json:"total_run_time_seconds"`
+	CooldownSeconds     int      `json:"cooldown_seconds"`
+	OutputDir           string   `json:"output_dir"`
+}
+
+// suiteCell is a single run of the matrix
+type suiteCell struct {
+	ID            string
+	Combination   string
+	ShipperConfig string
+	Repetition    int
+	Dir           string
+	Config        *BenchmarkConfig
+}
+
+type suiteResult struct {
+	Cell       *suiteCell
+	Report     *benchmarkReport
+	ReportPath string
+	Err        error
+}
+
+func LoadSuiteConfig(suitePath string) *SuiteConfig {
+	byteValue, err := ioutil.ReadFile(suitePath)
+	if err != nil {
+		fmt.Printf("[ERROR] Could not read %s: %s\n", suitePath, err)
+		os.Exit(1)
+	}
+	var suite SuiteConfig
+	if err := json.Unmarshal(byteValue, &suite); err != nil {
+		fmt.Println("[ERROR] Could not parse JSON: ", err)
+		os.Exit(1)
+	}
+	if len(suite.ShipperConfigs) == 0 {
+		fmt.Println("[ERROR] The suite must list at least one shipper config in shipper_configs")
+		os.Exit(1)
+	}
+	if suite.OutputDir == "" {
+		fmt.Println("[ERROR] The suite must set output_dir")
+		os.Exit(1)
+	}
+	if suite.Repetitions <= 0 {
+		suite.Repetitions = 1
+	}
+	return &suite
+}
+
+// orDefault returns values, or a list holding only def when it's empty
+func orDefault(values []int, def int) []int {
+	if len(values) == 0 {
+		return []int{def}
+	}
+	return values
+}
+
+// cells expands the matrix.  Repetitions are the outermost loop so that the
+// runs of a given combination are spread over the whole suite, rather than
+// all being affected by whatever happens on the host at the same time.
+func (suite *SuiteConfig) cells() []*suiteCell {
+	var cells []*suiteCell
+	for rep := 1; rep <= suite.Repetitions; rep++ {
+		for _, confPath := range suite.ShipperConfigs {
+			base := LoadConfig(confPath)
+			// Named after the config, as several may be for the same shipper
+			name := strings.TrimSuffix(filepath.Base(confPath), filepath.Ext(confPath))
+			for _, lineSize := range orDefault(suite.LogLineSizes, base.LogLineSize) {
+				for _, numFiles := range orDefault(suite.NumActiveLogFiles, base.NumActiveLogFiles) {
+					for _, waitMs := range orDefault(suite.WriteWaitPeriodsMs, base.WriteWaitPeriodMs) {
+						conf := *base
+						conf.LogLineSize = lineSize
+						conf.NumActiveLogFiles = numFiles
+						conf.WriteWaitPeriodMs = waitMs
+						if suite.TotalRunTimeSeconds > 0 {
+							conf.TotalRunTimeSeconds = suite.TotalRunTimeSeconds
+						}
+						// Runs are repeated by the suite, not by each of them
+						conf.Repetitions = 0
+						combination := fmt.Sprintf("%s_%db_%df_%dms", name, lineSize, numFiles, waitMs)
+						id := fmt.Sprintf("%03d_%s_rep%d", len(cells)+1, combination, rep)
+						dir := fmt.Sprintf("%s/%s", strings.TrimRight(suite.OutputDir, "/"), id)
+						// Each run keeps its reports, metrics and shipper state apart
+						conf.WorkingDir = dir
+						conf.MetricsDir = dir + "/metrics"
+						cells = append(cells, &suiteCell{
+							ID:            id,
+							Combination:   combination,
+							ShipperConfig: confPath,
+							Repetition:    rep,
+							Dir:           dir,
+							Config:        &conf,
+						})
+					}
+				}
+			}
+		}
+	}
+	return cells
+}
+
+// runCell runs the benchmark for a single cell in a child process, so a run
+// which fails or crashes doesn't take the rest of the suite down with it.
+func runCell(exePath string, cell *suiteCell, stopChan <-chan bool) (*benchmarkReport, string, error) {
+
+	utils.CreateDir(cell.Dir)
+	confJSON, err := json.MarshalIndent(cell.Config, "", "  ")
+	if err != nil {
+		return nil, "", err
+	}
+	confPath := cell.Dir + "/config.json"
+	if err := SaveToFile(confPath, string(confJSON), 0644); err != nil {
+		return nil, "", err
+	}
+
+	logPath := cell.Dir + "/benchmark.log"
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		return nil, "", err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exePath, confPath)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	// In its own process group, the run only gets the signal forwarded below
+	// rather than a second copy of the one sent to the terminal.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, "", err
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case err = <-exited:
+	case <-stopChan:
+		cmd.Process.Signal(syscall.SIGINT)
+		err = <-exited
+	}
+	if err != nil {
+		// A run aborted by the shipper exiting still saves its report
+		if report, reportPath, rerr := latestReport(cell.Dir, logPath); rerr == nil && report.Failure != nil {
+			return report, reportPath, fmt.Errorf("%s (see %s)", report.Failure.Reason, logPath)
+		}
+		return nil, "", fmt.Errorf("%s (see %s)", err, logPath)
+	}
+	return latestReport(cell.Dir, logPath)
+}
+
+// latestReport loads the last JSON report saved in dir
+func latestReport(dir string, logPath string) (*benchmarkReport, string, error) {
+	reports, _ := filepath.Glob(dir + "/report-*.json")
+	if len(reports) == 0 {
+		return nil, "", fmt.Errorf("no report was saved (see %s)", logPath)
+	}
+	sort.Strings(reports)
+	reportPath := reports[len(reports)-1]
+	data, err := ioutil.ReadFile(reportPath)
+	if err != nil {
+		return nil, "", err
+	}
+	var report benchmarkReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, "", err
+	}
+	return &report, reportPath, nil
+}
+
+// stopOnSignal returns a channel closed on the first signal, which lets the
+// current run finish cleanly and skips the remaining ones
+func stopOnSignal() <-chan bool {
+	stopChan := make(chan bool)
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		fmt.Println("[INFO] Caught signal. Stopping the current run and skipping the remaining ones.")
+		close(stopChan)
+	}()
+	return stopChan
+}
+
+// RunSuite runs every cell of the suite one after the other and saves a
+// combined summary, even if some of the runs failed.  It returns the number
+// of failed runs.
+func RunSuite(suite *SuiteConfig) int {
+
+	cells := suite.cells()
+	utils.CreateDir(suite.OutputDir)
+
+	exePath, err := os.Executable()
+	if err != nil {
+		fmt.Println("[ERROR] Could not find the benchmark executable: ", err)
+		os.Exit(1)
+	}
+
+	stopChan := stopOnSignal()
+
+	fmt.Printf("[INFO] Running suite of %d benchmarks, results are saved in %s\n", len(cells), suite.OutputDir)
+
+	var results []*suiteResult
+	failed := 0
+	stopped := func() bool {
+		select {
+		case <-stopChan:
+			return true
+		default:
+			return false
+		}
+	}
+	for i, cell := range cells {
+		if i > 0 && suite.CooldownSeconds > 0 && !stopped() {
+			fmt.Printf("[INFO] Cooling down for %d seconds...\n", suite.CooldownSeconds)
+			select {
+			case <-time.After(time.Duration(suite.CooldownSeconds) * time.Second):
+			case <-stopChan:
+			}
+		}
+		if stopped() {
+			results = append(results, &suiteResult{Cell: cell, Err: fmt.Errorf("skipped")})
+			failed++
+			continue
+		}
+
+		fmt.Printf("[INFO] (%d/%d) Running %s\n", i+1, len(cells), cell.ID)
+		report, reportPath, err := runCell(exePath, cell, stopChan)
+		if err != nil {
+			fmt.Printf("[ERROR] Benchmark %s failed: %s\n", cell.ID, err)
+			failed++
+		} else {
+			fmt.Printf("[INFO] Benchmark %s completed: %.1f lines/s\n", cell.ID, report.LinesPerSecond)
+		}
+		results = append(results, &suiteResult{Cell: cell, Report: report, ReportPath: reportPath, Err: err})
+	}
+
+	t := time.Now()
+	dt := fmt.Sprintf("%d%02d%02d%02d%02d%02d", t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second())
+	basePath := fmt.Sprintf("%s/suite-summary_%s", strings.TrimRight(suite.OutputDir, "/"), dt)
+
+	summary := newSuiteSummary(results)
+	text := generateSuiteSummary(results) + summary.Text()
+	fmt.Print(text)
+	if err := SaveToFile(basePath+".txt", text, 0644); err != nil {
+		fmt.Println("[ERROR] Could not save suite summary: ", err)
+	}
+	if jsonSummary, err := summary.JSON(); err != nil {
+		fmt.Println("[ERROR] Could not generate JSON suite summary: ", err)
+	} else if err := SaveToFile(basePath+".json", jsonSummary, 0644); err != nil {
+		fmt.Println("[ERROR] Could not save JSON suite summary: ", err)
+	}
+	if csvSummary, err := generateSuiteCSV(results); err != nil {
+		fmt.Println("[ERROR] Could not generate CSV suite summary: ", err)
+	} else if err := SaveToFile(basePath+".csv", csvSummary, 0644); err != nil {
+		fmt.Println("[ERROR] Could not save CSV suite summary: ", err)
+	}
+	return failed
+}
+
+func suiteStatus(res *suiteResult) string {
+	if res.Err != nil {
+		return res.Err.Error()
+	}
+	return "ok"
+}
+
+func generateSuiteSummary(results []*suiteResult) string {
+
+	var buffer bytes.Buffer
+	buffer.WriteString("\n----------------------- Suite Results ---------------------\n")
+	w := tabwriter.NewWriter(&buffer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN\tSHIPPER\tLINE SIZE\tFILES\tWAIT (ms)\tREP\tLINES/S\tDELIVERED/S\tCPU % AVG\tRSS MAX (MB)\tSTATUS")
+	for _, res := range results {
+		conf := res.Cell.Config
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t", res.Cell.ID, conf.LogShipperName, conf.LogLineSize, conf.NumActiveLogFiles, conf.WriteWaitPeriodMs, res.Cell.Repetition)
+		if r := res.Report; r != nil {
+			delivered := "-"
+			if perSecond, ok := r.deliveredLinesPerSecond(); ok {
+				delivered = fmt.Sprintf("%.1f", perSecond)
+			}
+			cpu, rss := "-", "-"
+			if r.Resources != nil {
+				cpu = fmt.Sprintf("%.2f", r.Resources.CPUPctAvg)
+				rss = fmt.Sprintf("%.1f", float64(r.Resources.RSSBytesMax)/1024/1024)
+			}
+			fmt.Fprintf(w, "%.1f\t%s\t%s\t%s\t", r.LinesPerSecond, delivered, cpu, rss)
+		} else {
+			fmt.Fprint(w, "-\t-\t-\t-\t")
+		}
+		fmt.Fprintf(w, "%s\n", suiteStatus(res))
+	}
+	w.Flush()
+	buffer.WriteString("----------------------------------------------------------\n")
+	return buffer.String()
+}
+
+// generateSuiteCSV combines the CSV rows of every run, with the columns of
+// failed runs left empty.
+func generateSuiteCSV(results []*suiteResult) (string, error) {
+
+	reportHeader, _ := (&benchmarkReport{Config: &BenchmarkConfig{}}).csvRecords()
+	header := append([]string{"run", "repetition", "status"}, reportHeader...)
+
+	var buffer bytes.Buffer
+	w := csv.NewWriter(&buffer)
+	w.Write(header)
+	for _, res := range results {
+		row := []string{res.Cell.ID, fmt.Sprintf("%d", res.Cell.Repetition), suiteStatus(res)}
+		if res.Report != nil {
+			_, reportRow := res.Report.csvRecords()
+			row = append(row, reportRow...)
+		} else {
+			row = append(row, make([]string, len(reportHeader))...)
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return buffer.String(), w.Error()
+}
+
+// RunRepetitions runs the benchmark of a single config several times, as a
+// suite holding just that config, so its results come with their variance.
+func RunRepetitions(confPath string, config *BenchmarkConfig) int {
+	t := time.Now()
+	dt := fmt.Sprintf("%d%02d%02d%02d%02d%02d", t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second())
+	return RunSuite(&SuiteConfig{
+		ShipperConfigs:  []string{confPath},
+		Repetitions:     config.Repetitions,
+		CooldownSeconds: config.RepetitionCooldownSeconds,
+		OutputDir:       fmt.Sprintf("%s/repetitions-%s_%s", strings.TrimRight(config.WorkingDir, "/"), config.LogShipperName, dt),
+	})
+}
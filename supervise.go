@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// failureSummary tells why a run was aborted
+type failureSummary struct {
+	Reason       string  `json:"reason"`
+	ExitCode     *int    `json:"exit_code,omitempty"`
+	Signal       string  `json:"signal,omitempty"`
+	AfterSeconds float64 `json:"after_seconds"`
+}
+
+// shipperSupervisor runs the shipper and watches for it exiting before it's
+// told to, such as when it can't parse its config, in which case the run is
+// aborted rather than writing to files nobody reads until the end of the
+// run.  The shipper may also be restarted on purpose, which isn't a failure.
+type shipperSupervisor struct {
+	name       string
+	start      func(preserveState bool) (*exec.Cmd, <-chan bool, error)
+	started    time.Time
+	cmd        *exec.Cmd
+	exited     <-chan bool
+	pids       []int
+	restarting bool
+	restarted  chan bool
+	failure    *failureSummary
+	done       chan bool
+	lock       sync.Mutex
+}
+
+// SuperviseShipper starts the shipper with start, which returns the command
+// once it's running along with a channel closed once it exited, or the error
+// it couldn't be started with.  shutdown is called when it exits
+// unexpectedly or can't be restarted.  start is told to preserve the state
+// of the shipper when restarting it.
+func SuperviseShipper(name string, start func(preserveState bool) (*exec.Cmd, <-chan bool, error), shutdown func(), shutdownChan <-chan bool) (*shipperSupervisor, error) {
+	s := &shipperSupervisor{name: name, start: start, restarted: make(chan bool, 1), done: make(chan bool)}
+	cmd, exited, err := start(false)
+	if err != nil {
+		return nil, err
+	}
+	s.cmd, s.exited, s.pids = cmd, exited, []int{cmd.Process.Pid}
+	s.started = time.Now()
+	go s.run(shutdown, shutdownChan)
+	return s, nil
+}
+
+// Current returns the command of the running shipper, along with a channel
+// closed once it exited
+func (s *shipperSupervisor) Current() (*exec.Cmd, <-chan bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.cmd, s.exited
+}
+
+// Pids returns the pid of every shipper process started so far, the first
+// one first
+func (s *shipperSupervisor) Pids() []int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]int{}, s.pids...)
+}
+
+// Elapsed returns the time since the shipper was first started
+func (s *shipperSupervisor) Elapsed() time.Duration {
+	return time.Since(s.started)
+}
+
+func (s *shipperSupervisor) run(shutdown func(), shutdownChan <-chan bool) {
+
+	defer close(s.done)
+
+	for {
+		cmd, exited := s.Current()
+		select {
+		case <-exited:
+			// The shipper is only told to exit once shutdownChan is closed
+			select {
+			case <-shutdownChan:
+				return
+			default:
+			}
+			s.lock.Lock()
+			restarting, replaced, failure := s.restarting, s.cmd != cmd, s.failure
+			s.lock.Unlock()
+			if replaced {
+				continue
+			}
+			if restarting {
+				select {
+				case <-s.restarted:
+				case <-shutdownChan:
+					return
+				}
+				continue
+			}
+			if failure == nil {
+				// Otherwise it couldn't be restarted
+				failure = describeExit(s.name, cmd.ProcessState, s.Elapsed().Round(time.Millisecond))
+			}
+			s.lock.Lock()
+			s.failure = failure
+			s.lock.Unlock()
+			fmt.Printf("[ERROR] %s, aborting the run.\n", failure.Reason)
+			shutdown()
+			return
+		case <-shutdownChan:
+			return
+		}
+	}
+}
+
+// Restart sends sig to the process group of the shipper, waits for it to
+// exit and starts it again after downtime.  The state of the shipper, such
+// as its registry, is kept.  It returns the time the shipper took to exit,
+// and false if shutdown came first, in which case it isn't started again, or
+// if it couldn't be started again, which fails the run.
+func (s *shipperSupervisor) Restart(sig syscall.Signal, downtime time.Duration, shutdownChan <-chan bool) (time.Duration, bool) {
+	s.lock.Lock()
+	s.restarting = true
+	cmd, exited := s.cmd, s.exited
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		s.restarting = false
+		s.lock.Unlock()
+		select {
+		case s.restarted <- true:
+		default:
+		}
+	}()
+
+	killed := time.Now()
+	if err := syscall.Kill(-cmd.Process.Pid, sig); err != nil {
+		fmt.Printf("[ERROR] Could not send %s to %s: %s\n", sig, s.name, err)
+	}
+	select {
+	case <-exited:
+	case <-time.After(shipperExitTimeout):
+		fmt.Printf("[ERROR] %s didn't exit within %s, killing it.\n", s.name, shipperExitTimeout)
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-exited
+	case <-shutdownChan:
+		return time.Since(killed), false
+	}
+	exitTime := time.Since(killed)
+
+	select {
+	case <-time.After(downtime):
+	case <-shutdownChan:
+		return exitTime, false
+	}
+	// The restarted shipper carries on from the state it saved
+	newCmd, newExited, err := s.start(true)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err != nil {
+		s.failure = &failureSummary{
+			Reason:       fmt.Sprintf("%s could not be restarted: %s", s.name, err),
+			AfterSeconds: s.Elapsed().Seconds(),
+		}
+		return exitTime, false
+	}
+	s.cmd, s.exited = newCmd, newExited
+	s.pids = append(s.pids, newCmd.Process.Pid)
+	return exitTime, true
+}
+
+// Failure returns why the shipper exited early, or nil if it didn't.  It
+// waits until the supervisor is done, which is once the shipper exited or
+// shutdown started.
+func (s *shipperSupervisor) Failure() *failureSummary {
+	<-s.done
+	return s.failure
+}
+
+func describeExit(name string, state *os.ProcessState, after time.Duration) *failureSummary {
+	f := &failureSummary{AfterSeconds: after.Seconds()}
+	if state == nil {
+		f.Reason = fmt.Sprintf("%s exited unexpectedly after %s", name, after)
+		return f
+	}
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		f.Signal = ws.Signal().String()
+		f.Reason = fmt.Sprintf("%s was killed by signal %s after %s", name, f.Signal, after)
+		return f
+	}
+	code := state.ExitCode()
+	f.ExitCode = &code
+	f.Reason = fmt.Sprintf("%s exited unexpectedly with code %d after %s", name, code, after)
+	return f
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestSupervisorFailedRestart(t *testing.T) {
+	starts := 0
+	start := func(preserveState bool) (*exec.Cmd, <-chan bool, error) {
+		starts++
+		if starts > 1 {
+			return nil, nil, errors.New("no such file")
+		}
+		cmd := exec.Command("sleep", "60")
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		exited := make(chan bool)
+		go func() {
+			cmd.Wait()
+			close(exited)
+		}()
+		return cmd, exited, nil
+	}
+	shutdownChan := make(chan bool)
+	shutdownCalled := make(chan bool)
+	shutdown := func() {
+		close(shutdownCalled)
+		close(shutdownChan)
+	}
+
+	s, err := SuperviseShipper("sleep", start, shutdown, shutdownChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, restarted := s.Restart(syscall.SIGTERM, 0, shutdownChan); restarted {
+		t.Error("Restart() = true when the shipper couldn't be started again")
+	}
+	<-shutdownCalled
+	if f := s.Failure(); f == nil || !strings.Contains(f.Reason, "could not be restarted: no such file") {
+		t.Errorf("Failure() = %+v, want the restart error", f)
+	}
+	if pids := s.Pids(); len(pids) != 1 {
+		t.Errorf("Pids() = %v, want the first shipper only", pids)
+	}
+}
+
+func TestSupervisorFailedStart(t *testing.T) {
+	start := func(preserveState bool) (*exec.Cmd, <-chan bool, error) {
+		return nil, nil, errors.New("no such file")
+	}
+	if _, err := SuperviseShipper("sleep", start, func() {}, make(chan bool)); err == nil {
+		t.Error("SuperviseShipper() didn't fail when the shipper couldn't be started")
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	counter "github.com/hartfordfive/logshipper-benchmark/lib/counter"
+	histogram "github.com/hartfordfive/logshipper-benchmark/lib/histogram"
+	kafka "github.com/hartfordfive/logshipper-benchmark/lib/kafka"
+	stamp "github.com/hartfordfive/logshipper-benchmark/lib/stamp"
+)
+
+const verifierPollWait = 200 * time.Millisecond
+
+type deliverySummary struct {
+	LinesReceived   int64 `json:"lines_received"`
+	LinesLost       int64 `json:"lines_lost"`
+	LinesDuplicated int64 `json:"lines_duplicated"`
+	BytesReceived   int64 `json:"bytes_received"`
+	DecodeErrors    int64 `json:"decode_errors"`
+
+	// Only set when lines are stamped
+	Stamped        bool          `json:"stamped"`
+	LinesUnstamped int64         `json:"lines_unstamped,omitempty"`
+	LatencyP50     time.Duration `json:"latency_p50_ns,omitempty"`
+	LatencyP90     time.Duration `json:"latency_p90_ns,omitempty"`
+	LatencyP99     time.Duration `json:"latency_p99_ns,omitempty"`
+	LatencyMax     time.Duration `json:"latency_max_ns,omitempty"`
+}
+
+type deliveryVerifier struct {
+	consumer     *kafka.Consumer
+	topic        string
+	gracePeriod  time.Duration
+	received     int64
+	delivered    int64 // Distinct lines received, when lines are stamped
+	bytes        int64
+	decodeErrors int64
+	linesWritten *counter.Counter
+
+	// Only accessed from the goroutine running the verifier until it's done
+	stamped   bool
+	unstamped int64
+	tracker   *stamp.Tracker
+	latencies *histogram.Histogram
+}
+
+// NewDeliveryVerifier creates a verifier which consumes the topic the shipper
+// produces to.  gracePeriod is how long the verifier keeps consuming after
+// shutdown without seeing any new record.  When lines are stamped, lost and
+// duplicated lines are identified individually and the latency between
+// writing a line and receiving it is measured.
+func NewDeliveryVerifier(brokers []string, topic string, gracePeriod time.Duration, stamped bool, linesWritten *counter.Counter) *deliveryVerifier {
+	return &deliveryVerifier{
+		consumer:     kafka.NewConsumer(brokers, topic, true),
+		topic:        topic,
+		gracePeriod:  gracePeriod,
+		stamped:      stamped,
+		linesWritten: linesWritten,
+		tracker:      stamp.NewTracker(),
+		latencies:    histogram.New(),
+	}
+}
+
+// Start positions the consumer at the current end of the topic, so records
+// left over from previous runs aren't counted.  It must be called before
+// the shipper is started.
+func (v *deliveryVerifier) Start() error {
+	return v.consumer.Refresh()
+}
+
+func (v *deliveryVerifier) Run(shutdownChan chan bool, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+	defer v.consumer.Close()
+
+	fmt.Printf("[INFO] Verifying delivery of lines to topic %s\n", v.topic)
+
+	refreshTicker := time.NewTicker(time.Second * 10)
+	defer refreshTicker.Stop()
+
+	for {
+		select {
+		case <-shutdownChan:
+			v.drain()
+			return
+		case <-refreshTicker.C:
+			// Pick up partitions created after startup
+			if err := v.consumer.Refresh(); err != nil {
+				fmt.Printf("[ERROR] Could not refresh metadata for %s: %s\n", v.topic, err)
+			}
+		default:
+			v.poll()
+		}
+	}
+}
+
+// drain keeps consuming until nothing new has arrived for the grace period
+func (v *deliveryVerifier) drain() {
+	fmt.Printf("[INFO] Waiting up to %s of inactivity for remaining lines to be delivered...\n", v.gracePeriod)
+	lastRecord := time.Now()
+	for time.Since(lastRecord) < v.gracePeriod {
+		if v.poll() > 0 {
+			lastRecord = time.Now()
+		}
+	}
+	fmt.Printf("[INFO] Delivery verification complete, %d lines received.\n", v.Received())
+}
+
+func (v *deliveryVerifier) poll() int {
+	n, err := v.consumer.Poll(verifierPollWait, func(r *kafka.Record) {
+		atomic.AddInt64(&v.received, 1)
+		atomic.AddInt64(&v.bytes, int64(len(r.Value)))
+		if v.stamped {
+			v.track(r, time.Now())
+		}
+	})
+	// Failing to reach the brokers is only logged, as nothing is lost
+	atomic.StoreInt64(&v.decodeErrors, v.consumer.DecodeErrors())
+	if err != nil {
+		fmt.Printf("[ERROR] Could not consume from %s: %s\n", v.topic, err)
+		if n == 0 {
+			time.Sleep(verifierPollWait)
+		}
+	}
+	return n
+}
+
+func (v *deliveryVerifier) track(r *kafka.Record, received time.Time) {
+	s, ok := stamp.Find(r.Value)
+	// No file has more lines than were written in total, anything beyond is
+	// a stamp mangled on its way which mustn't grow the tracker
+	if !ok || s.Seq >= uint64(v.linesWritten.Value()) {
+		v.unstamped++
+		return
+	}
+	// Latency is only measured on the first delivery of a line
+	if v.tracker.Add(s) {
+		atomic.AddInt64(&v.delivered, 1)
+		v.latencies.Record(int64(received.Sub(s.Written) / time.Microsecond))
+	}
+}
+
+func (v *deliveryVerifier) Received() int64 {
+	return atomic.LoadInt64(&v.received)
+}
+
+// Delivered returns the number of distinct lines received so far when lines
+// are stamped, and otherwise every line received, duplicates included
+func (v *deliveryVerifier) Delivered() int64 {
+	if v.stamped {
+		return atomic.LoadInt64(&v.delivered)
+	}
+	return v.Received()
+}
+
+// Summary compares what was received with the number of lines written.
+// Unless lines are stamped, lost and duplicated lines can only be told apart
+// by their totals.  It must only be called once the verifier is done.
+func (v *deliveryVerifier) Summary(linesWritten int64) *deliverySummary {
+	s := &deliverySummary{
+		LinesReceived: v.Received(),
+		BytesReceived: atomic.LoadInt64(&v.bytes),
+		DecodeErrors:  atomic.LoadInt64(&v.decodeErrors),
+	}
+	if v.stamped {
+		unique := v.tracker.Unique()
+		s.Stamped = true
+		s.LinesUnstamped = v.unstamped
+		s.LinesDuplicated = s.LinesReceived - v.unstamped - unique
+		if unique < linesWritten {
+			s.LinesLost = linesWritten - unique
+		}
+		s.LatencyP50 = time.Duration(v.latencies.Quantile(0.50)) * time.Microsecond
+		s.LatencyP90 = time.Duration(v.latencies.Quantile(0.90)) * time.Microsecond
+		s.LatencyP99 = time.Duration(v.latencies.Quantile(0.99)) * time.Microsecond
+		s.LatencyMax = time.Duration(v.latencies.Max()) * time.Microsecond
+		return s
+	}
+	if s.LinesReceived < linesWritten {
+		s.LinesLost = linesWritten - s.LinesReceived
+	} else {
+		s.LinesDuplicated = s.LinesReceived - linesWritten
+	}
+	return s
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	utils "github.com/hartfordfive/logshipper-benchmark/lib"
+	counter "github.com/hartfordfive/logshipper-benchmark/lib/counter"
+	generator "github.com/hartfordfive/logshipper-benchmark/lib/generator"
+	rate "github.com/hartfordfive/logshipper-benchmark/lib/rate"
+	replay "github.com/hartfordfive/logshipper-benchmark/lib/replay"
+	stamp "github.com/hartfordfive/logshipper-benchmark/lib/stamp"
+)
+
+// Rotation modes of the log files
+const (
+	rotationNone         = "none"
+	rotationRename       = "rename"       // fileN.log is renamed to fileN.log.1 and recreated
+	rotationCopyTruncate = "copytruncate" // fileN.log is copied to fileN.log.1 and truncated
+	rotationNumbered     = "numbered"     // Writing moves on to fileN-000001.log, fileN-000002.log, ...
+	rotationDated        = "dated"        // Writing moves on to fileN-<DATE>-000001.log, ...
+)
+
+const defaultRotationMaxFiles = 5
+
+// rateBatchInterval is how often writers following a target rate write
+const rateBatchInterval = 10 * time.Millisecond
+
+// Timings of replayed lines
+const (
+	replayTimingRate       = "rate"       // A line every write wait period
+	replayTimingTimestamps = "timestamps" // Lines follow the delays between their timestamps
+)
+
+// logWriter appends lines to one of the benchmark's log files and rotates
+// it once it reaches the configured size or age.
+type logWriter struct {
+	index    int
+	basePath string // The path of the file without its extension
+	path     string // The path of the file currently written to
+	config   *BenchmarkConfig
+	fh       *os.File
+	buf      *bufio.Writer
+	size     int64
+	opened   time.Time
+	seq      int      // Number of the current file for numbered rollovers
+	rotated  []string // Files rotated out which are still kept, oldest first
+	retire   chan bool
+	done     chan bool
+	line     []byte // Buffer for stamped entries
+	lineSeq  uint64 // Sequence number of the next stamped entry
+	maxEntry int    // Size of the largest entry written
+}
+
+// NewLogWriter creates the log file of the writer, which must exist before
+// the shipper is started.
+func NewLogWriter(index int, config *BenchmarkConfig) (*logWriter, error) {
+	w := &logWriter{
+		index:    index,
+		basePath: fmt.Sprintf("%s/file%d", config.LogFilesBaseDir, index),
+		config:   config,
+		retire:   make(chan bool),
+		done:     make(chan bool),
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// CheckRotationConfig returns an error if the rotation settings can't be used
+func CheckRotationConfig(config *BenchmarkConfig) error {
+	switch config.RotationMode {
+	case "", rotationNone:
+		return nil
+	case rotationRename, rotationCopyTruncate, rotationNumbered, rotationDated:
+	default:
+		return fmt.Errorf("unknown rotation mode: %s", config.RotationMode)
+	}
+	if config.RotationMaxSizeKb <= 0 && config.RotationIntervalSecs <= 0 {
+		return fmt.Errorf("rotation mode %s requires rotation_max_size_kb or rotation_interval_seconds", config.RotationMode)
+	}
+	return nil
+}
+
+// MonitorPath returns the path the shipper must be configured with, which
+// is a pattern when every rollover creates a new file.
+func (w *logWriter) MonitorPath() string {
+	switch w.config.RotationMode {
+	case rotationNumbered, rotationDated:
+		return w.basePath + "-*.log"
+	}
+	return w.path
+}
+
+func (w *logWriter) maxRotatedFiles() int {
+	if w.config.RotationMaxFiles <= 0 {
+		return defaultRotationMaxFiles
+	}
+	return w.config.RotationMaxFiles
+}
+
+func (w *logWriter) open() error {
+	switch w.config.RotationMode {
+	case rotationNumbered:
+		w.path = fmt.Sprintf("%s-%06d.log", w.basePath, w.seq)
+	case rotationDated:
+		// The sequence keeps names unique when rotating faster than the clock
+		w.path = fmt.Sprintf("%s-%s-%06d.log", w.basePath, time.Now().Format("20060102T150405.000"), w.seq)
+	default:
+		w.path = w.basePath + ".log"
+	}
+	// Appending keeps the writes at the end of the file once it's truncated
+	fh, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w.fh = fh
+	w.buf = bufio.NewWriterSize(fh, 4096*8) // 32K buffer
+	w.size = 0
+	w.opened = time.Now()
+	return nil
+}
+
+func (w *logWriter) needsRotation() bool {
+	switch w.config.RotationMode {
+	case "", rotationNone:
+		return false
+	}
+	if w.config.RotationMaxSizeKb > 0 && w.size >= int64(w.config.RotationMaxSizeKb)*1024 {
+		return true
+	}
+	return w.config.RotationIntervalSecs > 0 && time.Since(w.opened) >= time.Duration(w.config.RotationIntervalSecs)*time.Second
+}
+
+// shiftRotated renames fileN.log.1 to fileN.log.2 and so on, dropping the
+// files past the retention limit, to make room for a new fileN.log.1.
+func (w *logWriter) shiftRotated() {
+	for n := len(w.rotated); n >= 1; n-- {
+		from := fmt.Sprintf("%s.%d", w.path, n)
+		if n >= w.maxRotatedFiles() {
+			os.Remove(from)
+			continue
+		}
+		os.Rename(from, fmt.Sprintf("%s.%d", w.path, n+1))
+	}
+	if len(w.rotated) < w.maxRotatedFiles() {
+		w.rotated = append(w.rotated, fmt.Sprintf("%s.%d", w.path, len(w.rotated)+1))
+	}
+}
+
+func (w *logWriter) rotate() error {
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+
+	switch w.config.RotationMode {
+	case rotationRename:
+		w.fh.Close()
+		w.shiftRotated()
+		if err := os.Rename(w.path, w.path+".1"); err != nil {
+			// Keep writing to the same file rather than to a closed one
+			if fh, openErr := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); openErr == nil {
+				w.fh = fh
+				w.buf.Reset(fh)
+			}
+			return err
+		}
+		return w.open()
+
+	case rotationCopyTruncate:
+		w.shiftRotated()
+		src, err := os.Open(w.path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		dst, err := os.Create(w.path + ".1")
+		if err != nil {
+			return err
+		}
+		defer dst.Close()
+		if _, err := io.Copy(dst, src); err != nil {
+			return err
+		}
+		// Whatever the shipper didn't read before this point is lost, as it
+		// would be with logrotate.
+		if err := w.fh.Truncate(0); err != nil {
+			return err
+		}
+		w.size = 0
+		w.opened = time.Now()
+		return nil
+
+	default:
+		w.fh.Close()
+		w.rotated = append(w.rotated, w.path)
+		for len(w.rotated) > w.maxRotatedFiles() {
+			os.Remove(w.rotated[0])
+			w.rotated = w.rotated[1:]
+		}
+		w.seq++
+		return w.open()
+	}
+}
+
+// close flushes the lines still buffered, which have already been counted
+func (w *logWriter) close() {
+	w.buf.Flush()
+	w.fh.Close()
+}
+
+// Retire stops the writer without deleting its files, and waits for it to
+// be done.
+func (w *logWriter) Retire() {
+	close(w.retire)
+	<-w.done
+}
+
+// RemoveFiles deletes the log file along with its rotated files, once the
+// writer is done.
+func (w *logWriter) RemoveFiles() {
+	for _, filePath := range append(w.rotated, w.path) {
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			if utils.Debug {
+				fmt.Printf("[ERROR] Coud not delete %s: %s\n", filePath, err)
+			}
+		}
+	}
+}
+
+// stampEntry appends the entry to dst with the start of its message replaced
+// by a stamp, so its size is unchanged unless the message is shorter.
+func stampEntry(dst []byte, entry *generator.Entry, file int, seq uint64, t time.Time) []byte {
+	dst = append(dst, entry.Text[:entry.MessageOffset]...)
+	start := len(dst)
+	dst = stamp.Append(dst, file, seq, t)
+	if n := len(dst) - start; n < entry.MessageLen {
+		return append(dst, entry.Text[entry.MessageOffset+n:]...)
+	}
+	return append(dst, entry.Text[entry.MessageOffset+entry.MessageLen:]...)
+}
+
+// writeCounters are shared by every writer
+type writeCounters struct {
+	Lines     *counter.Counter
+	Bytes     *counter.Counter
+	Rotations *counter.Counter
+}
+
+// entrySource provides the entries written by writers
+type entrySource interface {
+	// Next returns the entry to write next, or nil if none is available yet,
+	// and false once there won't be any more.
+	Next() (*generator.Entry, bool)
+}
+
+// corpusSource goes through a corpus, starting at a random entry so files
+// don't all hold the same sequence.  Each writer has its own.
+type corpusSource struct {
+	corpus *generator.Corpus
+	next   int
+}
+
+func newCorpusSource(corpus *generator.Corpus) *corpusSource {
+	return &corpusSource{corpus: corpus, next: rand.Intn(corpus.Len())}
+}
+
+func (s *corpusSource) Next() (*generator.Entry, bool) {
+	e := s.corpus.Entry(s.next)
+	s.next++
+	return e, true
+}
+
+// replaySource hands out the lines of a replay, which is shared by every
+// writer.  When paced, writers wait for each line to be due rather than
+// writing every write wait period.
+type replaySource struct {
+	lines <-chan replay.Line
+	paced bool
+}
+
+func (s *replaySource) Next() (*generator.Entry, bool) {
+	select {
+	case line, ok := <-s.lines:
+		if !ok {
+			return nil, false
+		}
+		return &line.Entry, true
+	default:
+		return nil, true
+	}
+}
+
+// write appends an entry to the file, rotating it when needed, and returns
+// the number of bytes written
+func (w *logWriter) write(entry *generator.Entry, flush bool, counters *writeCounters) int {
+	var err error
+	written := len(entry.Text)
+	if w.config.StampLines {
+		// Stamped lines are flushed right away so latency doesn't include buffering
+		w.line = stampEntry(w.line[:0], entry, w.index, w.lineSeq, time.Now())
+		w.lineSeq++
+		written = len(w.line)
+		_, err = w.buf.Write(w.line)
+		flush = true
+	} else {
+		_, err = w.buf.Write(entry.Text)
+	}
+	if err == nil && flush {
+		err = w.buf.Flush()
+	}
+	if err != nil {
+		fmt.Println(err)
+	}
+	w.size += int64(written)
+	if written > w.maxEntry {
+		w.maxEntry = written
+	}
+	counters.Lines.Incr(1)
+	counters.Bytes.Incr(int64(written))
+
+	if w.needsRotation() {
+		if err := w.rotate(); err != nil {
+			fmt.Printf("[ERROR] Could not rotate %s: %s\n", w.path, err)
+		} else {
+			counters.Rotations.Incr(1)
+		}
+	}
+	return written
+}
+
+// Run writes entries from src to the file until stopChan is closed, or until
+// the writer is retired.  When target isn't nil, the writer follows its share
+// of it rather than writing every write wait period.  Files are only removed
+// on shutdown, so the shipper can drain them once writers stopped.
+func (w *logWriter) Run(src entrySource, target *rate.Target, counters *writeCounters, stopChan <-chan bool, shutdownChan <-chan bool, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+	defer close(w.done)
+
+	if rs, ok := src.(*replaySource); ok && rs.paced {
+		w.runPaced(rs.lines, counters, stopChan)
+	} else if target != nil {
+		w.runRated(src, rate.NewPacer(target, w.config.NumActiveLogFiles), counters, stopChan)
+	} else {
+		w.runTicked(src, counters, stopChan)
+	}
+
+	w.close()
+	select {
+	case <-w.retire:
+		// The files of retired writers are removed by the churner
+	default:
+		<-shutdownChan
+		if utils.Debug {
+			fmt.Printf("[INFO] Terminating file writter for %s\n", w.path)
+		}
+		w.RemoveFiles()
+	}
+}
+
+// runTicked writes an entry every write wait period
+func (w *logWriter) runTicked(src entrySource, counters *writeCounters, shutdownChan <-chan bool) {
+
+	writeWaitPeriod := w.config.WriteWaitPeriodMs
+	if w.config.EnableRandom {
+		writeWaitPeriod = utils.GetRandInt(w.config.RandomWriteWait[0], w.config.RandomWriteWait[1])
+	}
+	ticker_write := time.NewTicker(time.Millisecond * time.Duration(writeWaitPeriod))
+	ticker_flush := time.NewTicker(time.Millisecond * 2000)
+	defer ticker_write.Stop()
+	defer ticker_flush.Stop()
+
+	writeChan := ticker_write.C
+	for {
+		select {
+		case <-writeChan:
+			entry, more := src.Next()
+			if entry != nil {
+				w.write(entry, false, counters)
+			}
+			if !more {
+				writeChan = nil
+			}
+		case <-ticker_flush.C:
+			if w.buf.Available() < w.maxEntry {
+				w.buf.Flush()
+			}
+		case <-shutdownChan:
+			return
+		case <-w.retire:
+			return
+		}
+	}
+}
+
+// runRated writes a batch of entries every rate batch interval, as many as
+// the pacer allows
+func (w *logWriter) runRated(src entrySource, pacer *rate.Pacer, counters *writeCounters, shutdownChan <-chan bool) {
+
+	ticker_write := time.NewTicker(rateBatchInterval)
+	ticker_flush := time.NewTicker(time.Millisecond * 2000)
+	defer ticker_write.Stop()
+	defer ticker_flush.Stop()
+
+	writeChan := ticker_write.C
+	for {
+		select {
+		case <-writeChan:
+			// A batch never takes longer than the interval, so shutdown isn't held up
+			deadline := time.Now().Add(rateBatchInterval)
+			for pacer.Allow(time.Now()) && time.Now().Before(deadline) {
+				entry, more := src.Next()
+				if !more {
+					writeChan = nil
+				}
+				if entry == nil {
+					break
+				}
+				pacer.Spend(1, w.write(entry, false, counters))
+			}
+		case <-ticker_flush.C:
+			if w.buf.Available() < w.maxEntry {
+				w.buf.Flush()
+			}
+		case <-shutdownChan:
+			return
+		case <-w.retire:
+			return
+		}
+	}
+}
+
+// runPaced writes each line once it's due.  Writers wait for their line
+// concurrently, so a slow file doesn't hold the others back.
+func (w *logWriter) runPaced(lines <-chan replay.Line, counters *writeCounters, shutdownChan <-chan bool) {
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				lines = nil
+				continue
+			}
+			if wait := time.Until(line.Due); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-shutdownChan:
+					timer.Stop()
+					return
+				case <-w.retire:
+					timer.Stop()
+					return
+				}
+			}
+			w.write(&line.Entry, true, counters)
+		case <-shutdownChan:
+			return
+		case <-w.retire:
+			return
+		}
+	}
+}